@@ -0,0 +1,400 @@
+package imagor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/cshum/imagor/imagorpath"
+	"go.uber.org/zap"
+	"io"
+	"time"
+)
+
+// bytesPerPixel approximate decoded memory per pixel, i.e. RGBA
+const bytesPerPixel = 4
+
+// defaultPixels pixel count assumed if dimensions cannot be sniffed
+const defaultPixels = 2048 * 2048
+
+// maxSniffSize maximum bytes read for sniffing image header
+const maxSniffSize = 1 << 20
+
+// ImageHeader dimensions of image sniffed from header before decode
+type ImageHeader struct {
+	Width  int
+	Height int
+	Frames int
+}
+
+// SniffImageHeader sniffs image dimensions and number of frames from blob header without decoding.
+// Returns false if not supported or cannot be determined
+func SniffImageHeader(blob *Blob) (h ImageHeader, ok bool) {
+	if isBlobEmpty(blob) {
+		return
+	}
+	// reading beyond peeked bytes only if blob can be read again without reloading
+	rereadable := blob.FilePath() != "" || (blob.Size() > 0 && blob.Size() < maxBodySize)
+	var newReader = func() (io.ReadCloser, error) {
+		if !rereadable {
+			return io.NopCloser(bytes.NewReader(blob.Sniff())), nil
+		}
+		r, _, err := blob.NewReader()
+		return r, err
+	}
+	reader, err := newReader()
+	if err != nil || reader == nil {
+		return
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	br := bufio.NewReader(reader)
+	switch blob.BlobType() {
+	case BlobTypeJPEG:
+		h, err = sniffJPEG(io.LimitReader(br, maxSniffSize))
+	case BlobTypePNG:
+		h, err = sniffPNG(br)
+	case BlobTypeGIF:
+		h, err = sniffGIF(br)
+	case BlobTypeWEBP:
+		h, err = sniffWEBP(br)
+	case BlobTypeTIFF:
+		if !rereadable {
+			return
+		}
+		var rs io.ReadSeekCloser
+		if rs, _, err = blob.NewReadSeeker(); err != nil {
+			return
+		}
+		defer func() {
+			_ = rs.Close()
+		}()
+		h, err = sniffTIFF(rs)
+	default:
+		return
+	}
+	if err != nil || h.Width <= 0 || h.Height <= 0 {
+		return ImageHeader{}, false
+	}
+	if h.Frames < 1 {
+		h.Frames = 1
+	}
+	return h, true
+}
+
+// estimateCost estimates decoded memory cost in bytes of processing the blob
+func estimateCost(blob *Blob) int64 {
+	if h, ok := SniffImageHeader(blob); ok {
+		return int64(h.Width) * int64(h.Height) * int64(h.Frames) * bytesPerPixel
+	}
+	return defaultPixels * bytesPerPixel
+}
+
+// acquireMemory acquires memory budget weighted by estimated cost of processing the blob.
+// Cost exceeding the budget is capped such that the image processes alone
+func (app *Imagor) acquireMemory(ctx context.Context, blob *Blob, p imagorpath.Params) (func(), error) {
+	if app.memorySema == nil {
+		return func() {}, nil
+	}
+	cost := estimateCost(blob)
+	if cost > app.ProcessMemoryBudget {
+		cost = app.ProcessMemoryBudget
+	}
+	acquireCtx := ctx
+	if app.ProcessQueueTimeout > 0 {
+		var cancel func()
+		acquireCtx, cancel = context.WithTimeout(ctx, app.ProcessQueueTimeout)
+		defer cancel()
+	}
+	if err := app.memorySema.Acquire(acquireCtx, cost); err != nil {
+		if ctx.Err() == nil {
+			// queue timeout
+			err = ErrTooManyRequests
+		}
+		if app.Debug {
+			app.Logger.Debug("memory-acquire", zap.Any("params", p), zap.Int64("cost", cost), zap.Error(err))
+		}
+		return nil, err
+	}
+	if app.Debug {
+		app.Logger.Debug("memory-acquired", zap.Any("params", p), zap.Int64("cost", cost))
+	}
+	var start = time.Now()
+	return func() {
+		app.memorySema.Release(cost)
+		if app.Debug {
+			app.Logger.Debug("memory-released", zap.Int64("cost", cost), zap.Duration("duration", time.Since(start)))
+		}
+	}, nil
+}
+
+var processLimitCtxKey = &contextKey{"ProcessLimit"}
+
+// WithProcessLimit returns context with limit func checked before the image being processed,
+// i.e. result not available from Result Storage, such that cache hits are not limited.
+// Processing is rejected with the error returned, e.g. ErrTooManyRequests
+func WithProcessLimit(ctx context.Context, limit func() error) context.Context {
+	return context.WithValue(ctx, processLimitCtxKey, limit)
+}
+
+// checkProcessLimit checks process limit of context if any, skipping background refresh
+func checkProcessLimit(ctx context.Context) error {
+	if limit, ok := ctx.Value(processLimitCtxKey).(func() error); ok && limit != nil && !isRefresh(ctx) {
+		return limit()
+	}
+	return nil
+}
+
+var errSniff = errors.New("sniff failed")
+
+func sniffPNG(r io.Reader) (h ImageHeader, err error) {
+	var buf [24]byte
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return
+	}
+	if string(buf[12:16]) != "IHDR" {
+		return h, errSniff
+	}
+	h.Width = int(binary.BigEndian.Uint32(buf[16:20]))
+	h.Height = int(binary.BigEndian.Uint32(buf[20:24]))
+	return
+}
+
+func sniffJPEG(r io.Reader) (h ImageHeader, err error) {
+	br := bufio.NewReader(r)
+	var buf [2]byte
+	if _, err = io.ReadFull(br, buf[:]); err != nil {
+		return
+	}
+	for {
+		var marker byte
+		// skip to marker, allowing fill bytes
+		for {
+			var b byte
+			if b, err = br.ReadByte(); err != nil {
+				return
+			}
+			if b != 0xFF {
+				continue
+			}
+			for b == 0xFF {
+				if b, err = br.ReadByte(); err != nil {
+					return
+				}
+			}
+			marker = b
+			break
+		}
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			// markers without length
+			continue
+		}
+		if marker == 0xD9 || marker == 0xDA {
+			// end of image or start of scan before frame header
+			return h, errSniff
+		}
+		if _, err = io.ReadFull(br, buf[:]); err != nil {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(buf[:])) - 2
+		if length < 0 {
+			return h, errSniff
+		}
+		if marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC {
+			// start of frame: precision, height, width
+			var sof [5]byte
+			if _, err = io.ReadFull(br, sof[:]); err != nil {
+				return
+			}
+			h.Height = int(binary.BigEndian.Uint16(sof[1:3]))
+			h.Width = int(binary.BigEndian.Uint16(sof[3:5]))
+			return
+		}
+		if _, err = br.Discard(length); err != nil {
+			return
+		}
+	}
+}
+
+func sniffGIF(r *bufio.Reader) (h ImageHeader, err error) {
+	var buf [13]byte
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return
+	}
+	h.Width = int(binary.LittleEndian.Uint16(buf[6:8]))
+	h.Height = int(binary.LittleEndian.Uint16(buf[8:10]))
+	if buf[10]&0x80 != 0 {
+		// global color table
+		if _, err = r.Discard(3 << ((buf[10] & 0x07) + 1)); err != nil {
+			return h, nil
+		}
+	}
+	// count image descriptors, remaining stream is best effort
+	for {
+		var b byte
+		if b, err = r.ReadByte(); err != nil {
+			return h, nil
+		}
+		switch b {
+		case 0x2C:
+			h.Frames++
+			var desc [9]byte
+			if _, err = io.ReadFull(r, desc[:]); err != nil {
+				return h, nil
+			}
+			if desc[8]&0x80 != 0 {
+				// local color table
+				if _, err = r.Discard(3 << ((desc[8] & 0x07) + 1)); err != nil {
+					return h, nil
+				}
+			}
+			// LZW minimum code size
+			if _, err = r.ReadByte(); err != nil {
+				return h, nil
+			}
+			if err = skipGIFSubBlocks(r); err != nil {
+				return h, nil
+			}
+		case 0x21:
+			// extension label
+			if _, err = r.ReadByte(); err != nil {
+				return h, nil
+			}
+			if err = skipGIFSubBlocks(r); err != nil {
+				return h, nil
+			}
+		default:
+			// trailer or unknown
+			return h, nil
+		}
+	}
+}
+
+func skipGIFSubBlocks(r *bufio.Reader) error {
+	for {
+		n, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if _, err = r.Discard(int(n)); err != nil {
+			return err
+		}
+	}
+}
+
+func sniffWEBP(r *bufio.Reader) (h ImageHeader, err error) {
+	var buf [12]byte
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return
+	}
+	var frames int
+	for {
+		var chunk [8]byte
+		if _, e := io.ReadFull(r, chunk[:]); e != nil {
+			break
+		}
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+		var payload []byte
+		switch string(chunk[:4]) {
+		case "VP8X":
+			if payload, err = readPayload(r, size, 10); err != nil {
+				return
+			}
+			h.Width = int(uint32(payload[4])|uint32(payload[5])<<8|uint32(payload[6])<<16) + 1
+			h.Height = int(uint32(payload[7])|uint32(payload[8])<<8|uint32(payload[9])<<16) + 1
+			if payload[0]&0x02 == 0 {
+				// not animated
+				return
+			}
+		case "VP8 ":
+			if payload, err = readPayload(r, size, 10); err != nil {
+				return
+			}
+			if h.Width == 0 {
+				h.Width = int(binary.LittleEndian.Uint16(payload[6:8]) & 0x3FFF)
+				h.Height = int(binary.LittleEndian.Uint16(payload[8:10]) & 0x3FFF)
+			}
+			return
+		case "VP8L":
+			if payload, err = readPayload(r, size, 5); err != nil {
+				return
+			}
+			if h.Width == 0 {
+				bits := binary.LittleEndian.Uint32(payload[1:5])
+				h.Width = int(bits&0x3FFF) + 1
+				h.Height = int((bits>>14)&0x3FFF) + 1
+			}
+			return
+		case "ANMF":
+			frames++
+			fallthrough
+		default:
+			if _, e := r.Discard(size + size&1); e != nil {
+				h.Frames = frames
+				return h, nil
+			}
+			continue
+		}
+		// remaining of VP8X
+		if _, e := r.Discard(size - len(payload) + size&1); e != nil {
+			break
+		}
+	}
+	h.Frames = frames
+	return h, nil
+}
+
+// readPayload reads n bytes of chunk payload, requires size at least n
+func readPayload(r io.Reader, size, n int) ([]byte, error) {
+	if size < n {
+		return nil, errSniff
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
+
+func sniffTIFF(r io.ReadSeeker) (h ImageHeader, err error) {
+	var buf [8]byte
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if buf[0] == 'M' {
+		order = binary.BigEndian
+	}
+	if _, err = r.Seek(int64(order.Uint32(buf[4:8])), io.SeekStart); err != nil {
+		return
+	}
+	var count [2]byte
+	if _, err = io.ReadFull(r, count[:]); err != nil {
+		return
+	}
+	for i := 0; i < int(order.Uint16(count[:])); i++ {
+		var entry [12]byte
+		if _, err = io.ReadFull(r, entry[:]); err != nil {
+			return
+		}
+		var value int
+		switch order.Uint16(entry[2:4]) {
+		case 3: // SHORT
+			value = int(order.Uint16(entry[8:10]))
+		case 4: // LONG
+			value = int(order.Uint32(entry[8:12]))
+		default:
+			continue
+		}
+		switch order.Uint16(entry[0:2]) {
+		case 256:
+			h.Width = value
+		case 257:
+			h.Height = value
+		}
+	}
+	return
+}
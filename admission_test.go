@@ -0,0 +1,116 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSniffImageHeader(t *testing.T) {
+	for _, tt := range []struct {
+		file   string
+		header ImageHeader
+		ok     bool
+	}{
+		{"Canon_40D.jpg", ImageHeader{100, 68, 1}, true},
+		{"demo1.jpg", ImageHeader{200, 200, 1}, true},
+		{"gopher.png", ImageHeader{1634, 2224, 1}, true},
+		{"dancing-banana.gif", ImageHeader{121, 128, 8}, true},
+		{"nyan-cat.gif", ImageHeader{500, 198, 12}, true},
+		{"demo3.webp", ImageHeader{70, 87, 8}, true},
+		{"gopher.tiff", ImageHeader{200, 100, 1}, true},
+		{"gopher-front.avif", ImageHeader{}, false},
+	} {
+		t.Run(tt.file, func(t *testing.T) {
+			h, ok := SniffImageHeader(NewBlobFromFile("testdata/" + tt.file))
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.header, h)
+
+			buf, err := os.ReadFile("testdata/" + tt.file)
+			assert.NoError(t, err)
+			h, ok = SniffImageHeader(NewBlobFromBytes(buf))
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.header, h)
+		})
+	}
+	_, ok := SniffImageHeader(NewBlobFromBytes([]byte("foo")))
+	assert.False(t, ok)
+	_, ok = SniffImageHeader(NewBlobFromBytes([]byte("\x89PNG\r\n\x1a\n")))
+	assert.False(t, ok)
+	assert.Equal(t, int64(1634*2224*4), estimateCost(NewBlobFromFile("testdata/gopher.png")))
+	assert.Equal(t, int64(defaultPixels*4), estimateCost(NewBlobFromBytes([]byte("foo"))))
+}
+
+func TestWithProcessMemoryBudget(t *testing.T) {
+	loader := WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+		return NewBlobFromFile("testdata/" + image), nil
+	}))
+	processor := func(processing, max *int64) Option {
+		return WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			n := atomic.AddInt64(processing, 1)
+			for {
+				m := atomic.LoadInt64(max)
+				if n <= m || atomic.CompareAndSwapInt64(max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond * 20)
+			atomic.AddInt64(processing, -1)
+			return NewBlobFromBytes([]byte(p.Path)), nil
+		}))
+	}
+	serve := func(app *Imagor, paths ...string) (codes []int) {
+		var l sync.Mutex
+		var wg sync.WaitGroup
+		for _, path := range paths {
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/"+path, nil))
+				l.Lock()
+				codes = append(codes, w.Code)
+				l.Unlock()
+			}(path)
+		}
+		wg.Wait()
+		return
+	}
+
+	t.Run("large images serialized", func(t *testing.T) {
+		var processing, max int64
+		app := New(WithUnsafe(true), WithProcessMemoryBudget(20<<20), loader, processor(&processing, &max))
+		codes := serve(app, "100x0/gopher.png", "200x0/gopher.png", "300x0/gopher.png")
+		assert.Equal(t, []int{200, 200, 200}, codes)
+		assert.Equal(t, int64(1), max)
+	})
+
+	t.Run("small images concurrent", func(t *testing.T) {
+		var processing, max int64
+		app := New(WithUnsafe(true), WithProcessMemoryBudget(20<<20), loader, processor(&processing, &max))
+		codes := serve(app, "100x0/demo1.jpg", "200x0/demo1.jpg", "300x0/demo1.jpg")
+		assert.Equal(t, []int{200, 200, 200}, codes)
+		assert.Equal(t, int64(3), max)
+	})
+
+	t.Run("cost capped by budget", func(t *testing.T) {
+		var processing, max int64
+		app := New(WithUnsafe(true), WithProcessMemoryBudget(1<<20), loader, processor(&processing, &max))
+		assert.Equal(t, []int{200}, serve(app, "gopher.png"))
+	})
+
+	t.Run("queue timeout", func(t *testing.T) {
+		var processing, max int64
+		app := New(WithUnsafe(true), WithProcessMemoryBudget(20<<20), WithProcessQueueTimeout(time.Millisecond*5),
+			loader, processor(&processing, &max))
+		codes := serve(app, "100x0/gopher.png", "200x0/gopher.png")
+		assert.ElementsMatch(t, []int{200, 429}, codes)
+	})
+}
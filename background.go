@@ -0,0 +1,84 @@
+package imagor
+
+import (
+	"context"
+	"sync"
+)
+
+// tracker tracks work detached from requests, e.g. storage saves and deletes
+// that continue after response, such that they can be drained upon shutdown
+type tracker struct {
+	mu     sync.Mutex
+	count  int64
+	closed bool
+	idle   chan struct{}
+}
+
+// add adds work, returns false if closed for shutdown
+func (t *tracker) add() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	if t.count == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.count++
+	return true
+}
+
+// done marks work done
+func (t *tracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count--
+	if t.count == 0 {
+		close(t.idle)
+	}
+}
+
+// goFunc runs fn in goroutine as background work, returns false if closed for shutdown
+func (t *tracker) goFunc(fn func()) bool {
+	if !t.add() {
+		return false
+	}
+	go func() {
+		defer t.done()
+		fn()
+	}()
+	return true
+}
+
+// wait waits for outstanding work until context done, returns count of work not yet done
+func (t *tracker) wait(ctx context.Context) int64 {
+	t.mu.Lock()
+	if t.count == 0 {
+		t.mu.Unlock()
+		return 0
+	}
+	idle := t.idle
+	t.mu.Unlock()
+	select {
+	case <-idle:
+		return 0
+	case <-ctx.Done():
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.count
+	}
+}
+
+// pending returns count of outstanding work
+func (t *tracker) pending() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// close stops accepting new work
+func (t *tracker) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+}
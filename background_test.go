@@ -0,0 +1,109 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	var tr tracker
+	assert.Equal(t, int64(0), tr.wait(context.Background()))
+
+	release := make(chan struct{})
+	assert.True(t, tr.goFunc(func() { <-release }))
+	assert.True(t, tr.goFunc(func() { <-release }))
+	assert.Equal(t, int64(2), tr.pending())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+	defer cancel()
+	assert.Equal(t, int64(2), tr.wait(ctx), "not done until context done")
+
+	tr.close()
+	assert.False(t, tr.goFunc(func() {}), "closed")
+	assert.False(t, tr.add())
+	close(release)
+	assert.Equal(t, int64(0), tr.wait(context.Background()))
+	assert.Equal(t, int64(0), tr.pending())
+}
+
+func TestShutdownDrainsSaves(t *testing.T) {
+	var saved int32
+	release := make(chan struct{})
+	loader := WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+		return NewBlobFromBytes([]byte(image)), nil
+	}))
+	resultStorage := WithResultStorages(saverFunc(func(ctx context.Context, image string, blob *Blob) error {
+		<-release
+		atomic.AddInt32(&saved, 1)
+		return nil
+	}))
+	t.Run("drained", func(t *testing.T) {
+		app := New(WithUnsafe(true), loader, resultStorage)
+		_, err := app.Serve(context.Background(), imagorpath.Parse("unsafe/foo.jpg"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), app.Pending(), "result save outstanding")
+
+		shutdown := make(chan error)
+		go func() {
+			shutdown <- app.Shutdown(context.Background())
+		}()
+		assert.Eventually(t, func() bool {
+			app.background.mu.Lock()
+			defer app.background.mu.Unlock()
+			return app.background.closed
+		}, time.Second, time.Millisecond)
+		served := make(chan error)
+		go func() {
+			_, err := app.Serve(context.Background(), imagorpath.Parse("unsafe/bar.jpg"))
+			served <- err
+		}()
+		select {
+		case <-shutdown:
+			t.Fatal("shutdown should wait for outstanding save")
+		case <-served:
+			t.Fatal("request after shutdown should save within the request")
+		case <-time.After(time.Millisecond * 10):
+		}
+		assert.Equal(t, int64(1), app.Pending(), "save after shutdown not in background")
+		release <- struct{}{}
+		release <- struct{}{}
+		assert.NoError(t, <-served, "served after shutdown")
+		assert.NoError(t, <-shutdown)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&saved))
+		assert.Equal(t, int64(0), app.Pending())
+	})
+	t.Run("abandoned", func(t *testing.T) {
+		core, logs := observer.New(zapcore.WarnLevel)
+		app := New(WithUnsafe(true), WithLogger(zap.New(core)), loader, resultStorage)
+		_, err := app.Serve(context.Background(), imagorpath.Parse("unsafe/foo.jpg"))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.NoError(t, app.Shutdown(ctx))
+		require.Equal(t, 1, logs.FilterMessage("shutdown-abandoned").Len())
+		assert.Equal(t, int64(1), logs.FilterMessage("shutdown-abandoned").All()[0].ContextMap()["count"])
+		close(release)
+	})
+}
+
+func TestShutdownServesResultHits(t *testing.T) {
+	resultStore := newMapStore()
+	app := New(WithUnsafe(true), WithResultStorages(resultStore))
+	require.NoError(t, resultStore.Put(context.Background(), "foo.jpg", NewBlobFromBytes([]byte("foo"))))
+	require.NoError(t, app.Shutdown(context.Background()))
+	blob, err := app.Serve(context.Background(), imagorpath.Parse("unsafe/foo.jpg"))
+	require.NoError(t, err)
+	buf, err := blob.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(buf))
+}
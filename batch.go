@@ -0,0 +1,253 @@
+package imagor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/cshum/imagor/imagorpath"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxBatchBodySize = int64(1 << 20) // 1MB
+
+// BatchResult manifest of the batch processed variants
+type BatchResult struct {
+	Image    string         `json:"image"`
+	Variants []BatchVariant `json:"variants"`
+}
+
+// BatchVariant result of a batch variant
+type BatchVariant struct {
+	Path   string `json:"path"`
+	Key    string `json:"key,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Format string `json:"format,omitempty"`
+	Error  *Error `json:"error,omitempty"`
+}
+
+// Batch processes multiple variants of one source image from request body,
+// which is a JSON list of endpoint paths or imagorpath.Params.
+// Source image is loaded once, each variant is processed and saved into ResultStorages.
+// Returns BatchResult manifest, in which failure of a variant does not fail the batch
+func (app *Imagor) Batch(r *http.Request) (blob *Blob, err error) {
+	ctx, span := app.startRequestSpan(r, "imagor.Batch")
+	defer func() {
+		endSpan(span, err)
+	}()
+	ctx = DeferContext(withMetricsContext(ctx, app.Metrics))
+	var cancel func()
+	if app.RequestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, app.RequestTimeout)
+		Defer(ctx, cancel)
+	}
+	r = r.WithContext(ctx)
+	if !app.EnableBatch {
+		err = ErrMethodNotAllowed
+		return
+	}
+	var params []imagorpath.Params
+	if params, err = readBatch(r); err != nil {
+		if app.Debug {
+			app.Logger.Debug("batch-read", zap.Error(err))
+		}
+		return
+	}
+	var result = BatchResult{
+		Image:    params[0].Image,
+		Variants: make([]BatchVariant, len(params)),
+	}
+	for i, p := range params {
+		result.Variants[i].Path = p.Path
+		if app.Unsafe && app.isPresetPath(p) {
+			p.Unsafe = true
+		}
+		if e := app.checkSignature(ctx, p); e != nil {
+			result.Variants[i].Error = batchError(e)
+		} else if params[i], e = app.applyPreset(p); e != nil {
+			result.Variants[i].Error = batchError(e)
+		}
+	}
+	// image of the first valid variant, with presets expanded
+	var isValid bool
+	for i, p := range params {
+		if result.Variants[i].Error == nil {
+			result.Image = p.Image
+			isValid = true
+			break
+		}
+	}
+	if !isValid {
+		// source image is not loaded without any authorized variant
+		blob = NewBlobFromJsonMarshal(result)
+		return
+	}
+	for i, p := range params {
+		if result.Variants[i].Error == nil && p.Image != result.Image {
+			result.Variants[i].Error = batchError(ErrImageMismatch)
+		}
+	}
+	span.SetAttributes(attribute.String("imagor.image", result.Image),
+		attribute.Int("imagor.variants", len(params)))
+	var src *Blob
+	var shouldSave bool
+	if src, shouldSave, err = app.loadStorage(r, result.Image); err != nil {
+		if app.Debug {
+			app.Logger.Debug("batch-load", zap.String("image", result.Image), zap.Error(err))
+		}
+		return
+	}
+	if isBlobEmpty(src) {
+		err = ErrNotFound
+		return
+	}
+	if src, err = bufferBlob(src); err != nil {
+		return
+	}
+	if shouldSave {
+		// make sure storage saved before result storage
+		_ = app.save(ctx, "storage", result.Image, src)
+	}
+	load := func(image string) (*Blob, error) {
+		blob, _, err := app.loadStorage(r, image)
+		return blob, err
+	}
+	var wg sync.WaitGroup
+	for i, p := range params {
+		var variant = &result.Variants[i]
+		if variant.Error != nil {
+			continue
+		}
+		if app.BaseParams != "" {
+			p = imagorpath.Apply(p, app.BaseParams)
+			p.Path = imagorpath.GeneratePath(p)
+		}
+		variant.Key = app.resultKey(p, Variant{})
+		if e := checkProcessLimit(r.Context()); e != nil {
+			variant.Error = batchError(e)
+			continue
+		}
+		var start = time.Now()
+		b, e := app.batchProcess(ctx, r, src, p, load)
+		app.emit(ctx, processedEvent(p, variant.Key, start, b, e))
+		if e != nil {
+			if errors.Is(e, context.Canceled) || errors.Is(e, context.DeadlineExceeded) {
+				// request done, no point processing the rest
+				err = e
+				break
+			}
+			variant.Error = batchError(e)
+			continue
+		}
+		variant.Size = b.Size()
+		variant.Format = strings.TrimPrefix(b.ContentType(), "image/")
+		if len(app.ResultStorages) > 0 {
+			wg.Add(1)
+			go func(ctx context.Context, key string, blob *Blob, variant *BatchVariant) {
+				defer wg.Done()
+				if e := app.save(ctx, "result_storage", key, blob); e != nil {
+					variant.Error = batchError(e)
+				}
+			}(withEventParams(ctx, p), variant.Key, b, variant)
+		}
+	}
+	wg.Wait()
+	if err != nil {
+		return
+	}
+	if app.Debug {
+		app.Logger.Debug("batch", zap.Any("result", result))
+	}
+	blob = NewBlobFromJsonMarshal(result)
+	return
+}
+
+func (app *Imagor) batchProcess(
+	ctx context.Context, r *http.Request, src *Blob, p imagorpath.Params, load LoadFunc,
+) (*Blob, error) {
+	release, err := app.acquire(ctx, r, p)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	releaseMemory, err := app.acquireMemory(ctx, src, p)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseMemory()
+	blob, err := checkBlob(app.process(ctx, src, p, load))
+	if err == nil && isBlobEmpty(blob) {
+		err = ErrNotFound
+	}
+	return blob, err
+}
+
+// readBatch reads batch request body of JSON list,
+// each item being either endpoint path string or imagorpath.Params object
+func readBatch(r *http.Request) ([]imagorpath.Params, error) {
+	if r.Body == nil {
+		return nil, ErrInvalid
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, maxBatchBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > maxBatchBodySize {
+		return nil, ErrMaxSizeExceeded
+	}
+	var items []json.RawMessage
+	if err = json.Unmarshal(buf, &items); err != nil || len(items) == 0 {
+		return nil, ErrInvalid
+	}
+	var params = make([]imagorpath.Params, len(items))
+	for i, item := range items {
+		item = bytes.TrimSpace(item)
+		if len(item) > 0 && item[0] == '"' {
+			var path string
+			if err = json.Unmarshal(item, &path); err != nil {
+				return nil, ErrInvalid
+			}
+			params[i] = imagorpath.Parse(path)
+		} else {
+			var p imagorpath.Params
+			if err = json.Unmarshal(item, &p); err != nil {
+				return nil, ErrInvalid
+			}
+			// normalize params from generated path for signature verification
+			params[i] = imagorpath.Parse("unsafe/" + imagorpath.GeneratePath(p))
+			params[i].Unsafe = p.Unsafe
+			params[i].Hash = p.Hash
+		}
+		if params[i].Params || params[i].Image == "" {
+			return nil, ErrInvalid
+		}
+	}
+	return params, nil
+}
+
+// bufferBlob reads blob into memory such that it can be read multiple times,
+// unless file-backed
+func bufferBlob(blob *Blob) (*Blob, error) {
+	if blob.FilePath() != "" {
+		return blob, nil
+	}
+	buf, err := blob.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	b := NewBlobFromBytes(buf)
+	b.SetContentType(blob.ContentType())
+	b.SetStat(blob.Stat())
+	return b, nil
+}
+
+func batchError(err error) *Error {
+	e := WrapError(err)
+	return &e
+}
@@ -0,0 +1,123 @@
+package imagor
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	var loadCnt int64
+	store := newMapStore()
+	resultStore := newMapStore()
+	app := New(
+		WithSigner(imagorpath.NewDefaultSigner("1234")),
+		WithEnableBatch(true),
+		WithStorages(store),
+		WithResultStorages(resultStore),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			atomic.AddInt64(&loadCnt, 1)
+			if image == "notfound.jpg" {
+				return nil, ErrNotFound
+			}
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			if p.Width > 1000 {
+				return nil, ErrMaxResolutionExceeded
+			}
+			buf, _ := blob.ReadAll()
+			return NewBlobFromBytes([]byte(p.Path + ":" + string(buf))), nil
+		})),
+	)
+	signer := imagorpath.NewDefaultSigner("1234")
+	serve := func(app *Imagor, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(method, "https://example.com"+path, strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		app := New()
+		w := serve(app, http.MethodPost, "/batch", `["foo.jpg"]`)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		body, _ := json.Marshal([]interface{}{
+			"unsafe/100x0/foo.jpg",
+			imagorpath.Params{Width: 200, Image: "foo.jpg", Hash: "abcd"},
+		})
+		w := serve(app, http.MethodPost, "/batch", string(body))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, jsonStr(BatchResult{
+			Image: "foo.jpg",
+			Variants: []BatchVariant{
+				{Path: "100x0/foo.jpg", Error: batchError(ErrSignatureMismatch)},
+				{Path: "200x0/foo.jpg", Error: batchError(ErrSignatureMismatch)},
+			},
+		}), w.Body.String())
+		assert.Equal(t, int64(0), atomic.LoadInt64(&loadCnt), "source not loaded")
+		assert.Empty(t, store.Map)
+		assert.Empty(t, resultStore.Map)
+	})
+
+	t.Run("variants", func(t *testing.T) {
+		atomic.StoreInt64(&loadCnt, 0)
+		body, _ := json.Marshal([]interface{}{
+			imagorpath.Generate(imagorpath.Params{Width: 100, Image: "foo.jpg"}, signer),
+			"/" + imagorpath.Generate(imagorpath.Params{Width: 200, Image: "foo.jpg"}, signer),
+			imagorpath.Params{Width: 300, Image: "foo.jpg", Hash: signer.Sign("300x0/foo.jpg")},
+			imagorpath.Params{Width: 400, Image: "foo.jpg", Hash: "abcd"},
+			imagorpath.Generate(imagorpath.Params{Width: 2000, Image: "foo.jpg"}, signer),
+			imagorpath.Generate(imagorpath.Params{Width: 100, Image: "bar.jpg"}, signer),
+		})
+		w := serve(app, http.MethodPost, "/batch", string(body))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "private, no-cache, no-store, must-revalidate", w.Header().Get("Cache-Control"))
+		assert.Equal(t, int64(1), atomic.LoadInt64(&loadCnt))
+		assert.Equal(t, jsonStr(BatchResult{
+			Image: "foo.jpg",
+			Variants: []BatchVariant{
+				{Path: "100x0/foo.jpg", Key: "100x0/foo.jpg", Size: 21, Format: "text/plain; charset=utf-8"},
+				{Path: "200x0/foo.jpg", Key: "200x0/foo.jpg", Size: 21, Format: "text/plain; charset=utf-8"},
+				{Path: "300x0/foo.jpg", Key: "300x0/foo.jpg", Size: 21, Format: "text/plain; charset=utf-8"},
+				{Path: "400x0/foo.jpg", Error: batchError(ErrSignatureMismatch)},
+				{Path: "2000x0/foo.jpg", Key: "2000x0/foo.jpg", Error: batchError(ErrMaxResolutionExceeded)},
+				{Path: "100x0/bar.jpg", Error: batchError(ErrImageMismatch)},
+			},
+		}), w.Body.String())
+		for _, key := range []string{"100x0/foo.jpg", "200x0/foo.jpg", "300x0/foo.jpg"} {
+			require.Contains(t, resultStore.Map, key)
+			buf, err := resultStore.Map[key].ReadAll()
+			require.NoError(t, err)
+			assert.Equal(t, key+":foo.jpg", string(buf))
+		}
+		assert.Len(t, resultStore.Map, 3)
+		assert.Contains(t, store.Map, "foo.jpg")
+	})
+
+	t.Run("source not found", func(t *testing.T) {
+		w := serve(app, http.MethodPost, "/batch", jsonStr([]string{
+			imagorpath.Generate(imagorpath.Params{Width: 100, Image: "notfound.jpg"}, signer),
+		}))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, jsonStr(ErrNotFound), w.Body.String())
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		for _, body := range []string{``, `[]`, `{}`, `[1]`, `["params/foo.jpg"]`, `[""]`} {
+			w := serve(app, http.MethodPost, "/batch", body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+			assert.Equal(t, jsonStr(ErrInvalid), w.Body.String(), body)
+		}
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"sync"
+	"time"
 )
 
 type BlobType int
@@ -39,6 +40,11 @@ type Blob struct {
 	blobType    BlobType
 	filepath    string
 	contentType string
+	stat        *Stat
+	etag        string
+	modTime     time.Time
+	notModified bool
+	stale       bool
 }
 
 func NewBlob(newReader func() (reader io.ReadCloser, size int64, err error)) *Blob {
@@ -57,9 +63,17 @@ func NewBlobFromFile(filepath string, checks ...func(os.FileInfo) error) *Blob {
 			}
 		}
 	}
+	var blobStat *Stat
+	if err == nil {
+		blobStat = &Stat{
+			ModifiedTime: stat.ModTime(),
+			Size:         stat.Size(),
+		}
+	}
 	return &Blob{
 		err:      err,
 		filepath: filepath,
+		stat:     blobStat,
 		newReader: func() (io.ReadCloser, int64, error) {
 			if err != nil {
 				return nil, 0, err
@@ -237,6 +251,18 @@ func (b *Blob) SetContentType(contentType string) {
 	b.contentType = contentType
 }
 
+// SetStat sets attributes of the blob from its storage,
+// which can be set inside the Blob reader function
+func (b *Blob) SetStat(stat *Stat) {
+	b.stat = stat
+}
+
+// Stat returns attributes of the blob from its storage if available
+func (b *Blob) Stat() *Stat {
+	b.init()
+	return b.stat
+}
+
 func (b *Blob) ContentType() string {
 	b.init()
 	return b.contentType
@@ -259,6 +285,33 @@ func (b *Blob) NewReader() (reader io.ReadCloser, size int64, err error) {
 	return
 }
 
+// NewReadSeeker create new io.ReadSeekCloser of the blob.
+// Seeks from file directly if blob is file-backed,
+// otherwise seeks from buffered bytes of the blob in memory
+func (b *Blob) NewReadSeeker() (io.ReadSeekCloser, int64, error) {
+	b.init()
+	if b.err != nil {
+		return nil, 0, b.err
+	}
+	if b.filepath != "" {
+		file, err := os.Open(b.filepath)
+		if err != nil {
+			return nil, 0, err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, 0, err
+		}
+		return file, stat.Size(), nil
+	}
+	buf, err := b.ReadAll()
+	if err != nil {
+		return nil, 0, err
+	}
+	return &readSeekNopCloser{bytes.NewReader(buf)}, int64(len(buf)), nil
+}
+
 func (b *Blob) ReadAll() ([]byte, error) {
 	b.init()
 	if b.blobType == BlobTypeEmpty {
@@ -293,3 +346,9 @@ func checkBlob(blob *Blob, err error) (*Blob, error) {
 	}
 	return blob, err
 }
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error { return nil }
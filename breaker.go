@@ -0,0 +1,236 @@
+package imagor
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BackendStatus health status of a Loader, Storage or Result Storage
+type BackendStatus struct {
+	// Kind being either "loader", "storage" or "result_storage"
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// State circuit breaker state, either "closed", "open" or "half-open"
+	State string `json:"state"`
+	// Failures number of consecutive failures
+	Failures int `json:"failures"`
+	// LastError reason of the last failure, either "timeout" or status text,
+	// without backend error message that may expose internal details
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	OpenedAt    *time.Time `json:"opened_at,omitempty"`
+}
+
+// circuitBreaker tracks consecutive failures of a backend.
+// Opens after threshold failures, skipping the backend for cooldown,
+// then lets through probe requests half-open that close the circuit on success
+type circuitBreaker struct {
+	kind      string
+	name      string
+	threshold int
+	cooldown  time.Duration
+	probes    int
+
+	mu          sync.Mutex
+	state       string
+	failures    int
+	successes   int
+	probing     int
+	lastError   string
+	lastFailure time.Time
+	openedAt    time.Time
+}
+
+func newCircuitBreaker(kind, name string, threshold int, cooldown time.Duration, probes int) *circuitBreaker {
+	if probes <= 0 {
+		probes = 1
+	}
+	return &circuitBreaker{
+		kind:      kind,
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		probes:    probes,
+		state:     BreakerClosed,
+	}
+}
+
+// allow returns false if the backend should be skipped
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.successes = 0
+		b.probing = 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing >= b.probes {
+			return false
+		}
+		b.probing++
+	}
+	return true
+}
+
+// done records outcome of a backend call allowed by the breaker
+func (b *circuitBreaker) done(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.probing > 0 {
+		b.probing--
+	}
+	if errors.Is(err, context.Canceled) {
+		// outcome unknown if canceled by caller
+		return
+	}
+	if !isBackendFailure(err) {
+		b.failures = 0
+		if b.state == BreakerHalfOpen {
+			b.successes++
+			if b.successes >= b.probes {
+				b.state = BreakerClosed
+			}
+		}
+		return
+	}
+	b.failures++
+	b.lastError = failureReason(err)
+	b.lastFailure = time.Now()
+	if b.state == BreakerHalfOpen ||
+		(b.state == BreakerClosed && b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = b.lastFailure
+	}
+}
+
+func (b *circuitBreaker) status() BackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BackendStatus{
+		Kind:      b.kind,
+		Name:      b.name,
+		State:     b.state,
+		Failures:  b.failures,
+		LastError: b.lastError,
+	}
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		s.State = BreakerHalfOpen
+	}
+	if !b.lastFailure.IsZero() {
+		t := b.lastFailure
+		s.LastFailure = &t
+	}
+	if b.state != BreakerClosed {
+		t := b.openedAt
+		s.OpenedAt = &t
+	}
+	return s
+}
+
+// isBackendFailure returns true if error indicates backend unavailable,
+// i.e. timeout or server error, as opposed to not found or invalid request
+func isBackendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	e := WrapError(err)
+	return e.Timeout() || e.Code >= http.StatusInternalServerError
+}
+
+// failureReason returns sanitized reason of backend failure
+func failureReason(err error) string {
+	e := WrapError(err)
+	if e.Timeout() {
+		return "timeout"
+	}
+	return strings.ToLower(http.StatusText(e.Code))
+}
+
+func (app *Imagor) initBreakers() {
+	newBreaker := func(kind string, backend interface{}) *circuitBreaker {
+		return newCircuitBreaker(kind, getType(backend),
+			app.CircuitBreakerThreshold, app.CircuitBreakerCooldown, app.CircuitBreakerProbes)
+	}
+	app.breakers = map[string][]*circuitBreaker{}
+	for _, loader := range app.Loaders {
+		app.breakers["loader"] = append(app.breakers["loader"], newBreaker("loader", loader))
+	}
+	for _, storage := range app.Storages {
+		app.breakers["storage"] = append(app.breakers["storage"], newBreaker("storage", storage))
+	}
+	for _, storage := range app.ResultStorages {
+		app.breakers["result_storage"] = append(app.breakers["result_storage"], newBreaker("result_storage", storage))
+	}
+}
+
+// breaker returns circuit breaker of backend by kind and index
+func (app *Imagor) breaker(kind string, i int) *circuitBreaker {
+	if breakers := app.breakers[kind]; i < len(breakers) {
+		return breakers[i]
+	}
+	return nil
+}
+
+// storages returns storages by kind, either "storage" or "result_storage"
+func (app *Imagor) storages(kind string) []Storage {
+	if kind == "result_storage" {
+		return app.ResultStorages
+	}
+	return app.Storages
+}
+
+// BackendStatus returns health status of Loaders, Storages and Result Storages
+func (app *Imagor) BackendStatus() (status []BackendStatus) {
+	for _, kind := range []string{"loader", "storage", "result_storage"} {
+		for _, b := range app.breakers[kind] {
+			status = append(status, b.status())
+		}
+	}
+	return
+}
+
+type statusResp struct {
+	Backends []BackendStatus `json:"backends"`
+}
+
+func (app *Imagor) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !app.checkStatus(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	setCacheHeaders(w, 0, 0)
+	writeJSON(w, r, statusResp{Backends: app.BackendStatus()})
+}
+
+// checkStatus authorizes status endpoint by StatusSecret,
+// or allows it without StatusSecret only if Unsafe
+func (app *Imagor) checkStatus(r *http.Request) bool {
+	if app.StatusSecret == "" {
+		return app.Unsafe
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(app.StatusSecret)) == 1
+}
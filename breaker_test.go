@@ -0,0 +1,164 @@
+package imagor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker("loader", "test", 2, time.Millisecond*20, 2)
+	assert.True(t, b.allow())
+	b.done(ErrNotFound)
+	assert.True(t, b.allow())
+	b.done(ErrTimeout)
+	assert.True(t, b.allow())
+	b.done(context.Canceled)
+	assert.Equal(t, 1, b.status().Failures, "not found and canceled should not count")
+	assert.True(t, b.allow())
+	b.done(errors.New("connection refused"))
+	assert.Equal(t, BreakerOpen, b.status().State)
+	assert.Equal(t, "internal server error", b.status().LastError, "backend error message not exposed")
+	assert.False(t, b.allow())
+
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, BreakerHalfOpen, b.status().State)
+	assert.True(t, b.allow())
+	assert.True(t, b.allow())
+	assert.False(t, b.allow(), "should limit half-open probes")
+	b.done(nil)
+	b.done(errors.New("connection reset"))
+	assert.Equal(t, BreakerOpen, b.status().State, "half-open failure should reopen")
+	assert.False(t, b.allow())
+
+	time.Sleep(time.Millisecond * 20)
+	assert.True(t, b.allow())
+	b.done(nil)
+	assert.True(t, b.allow())
+	b.done(nil)
+	assert.Equal(t, BreakerClosed, b.status().State)
+	assert.Nil(t, b.status().OpenedAt)
+	assert.Equal(t, 0, b.status().Failures)
+
+	var nilBreaker *circuitBreaker
+	assert.True(t, nilBreaker.allow())
+	nilBreaker.done(ErrTimeout)
+}
+
+func TestWithCircuitBreaker(t *testing.T) {
+	var failing int32 = 1
+	var calls int32
+	app := New(
+		WithUnsafe(true),
+		WithCircuitBreakerThreshold(2),
+		WithCircuitBreakerCooldown(time.Millisecond*50),
+		WithStatusPath("status"),
+		WithStatusSecret("foo"),
+		WithStorages(newMapStore()),
+		WithLoaders(
+			loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				atomic.AddInt32(&calls, 1)
+				if atomic.LoadInt32(&failing) == 1 {
+					return nil, ErrTimeout
+				}
+				return NewBlobFromBytes([]byte("foo")), nil
+			}),
+			loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				return NewBlobFromBytes([]byte("bar")), nil
+			}),
+		),
+	)
+	assert.Equal(t, "/status", app.StatusPath)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil)
+		r.Header.Set("Authorization", "Bearer foo")
+		app.ServeHTTP(w, r)
+		return w
+	}
+	status := func() (resp statusResp) {
+		w := get("/status")
+		require.Equal(t, 200, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return
+	}
+	assert.Equal(t, []BackendStatus{
+		{Kind: "loader", Name: "loaderFunc", State: BreakerClosed},
+		{Kind: "loader", Name: "loaderFunc", State: BreakerClosed},
+		{Kind: "storage", Name: "mapStore", State: BreakerClosed},
+	}, status().Backends)
+
+	for i, image := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"} {
+		w := get("/unsafe/" + image)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "bar", w.Body.String())
+		if i < 2 {
+			assert.Equal(t, int32(i+1), atomic.LoadInt32(&calls))
+		}
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "should skip loader with open circuit")
+	backends := status().Backends
+	assert.Equal(t, BreakerOpen, backends[0].State)
+	assert.Equal(t, 2, backends[0].Failures)
+	assert.Equal(t, "timeout", backends[0].LastError)
+	assert.NotNil(t, backends[0].OpenedAt)
+	assert.Equal(t, BreakerClosed, backends[1].State)
+
+	time.Sleep(time.Millisecond * 50)
+	atomic.StoreInt32(&failing, 0)
+	w := get("/unsafe/e.jpg")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "foo", w.Body.String())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, BreakerClosed, status().Backends[0].State)
+}
+
+func TestStatusUnauthorized(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options []Option
+		token   string
+		code    int
+	}{
+		{"no secret", nil, "", 401},
+		{"unsafe without secret", []Option{WithUnsafe(true)}, "", 200},
+		{"invalid secret", []Option{WithUnsafe(true), WithStatusSecret("foo")}, "bar", 401},
+		{"secret", []Option{WithStatusSecret("foo")}, "foo", 200},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := New(append(tt.options, WithStatusPath("/status"))...)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/status", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			app.ServeHTTP(w, r)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestCircuitBreakerUnavailable(t *testing.T) {
+	app := New(
+		WithUnsafe(true),
+		WithCircuitBreakerThreshold(1),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return nil, errors.New("connection refused")
+		})),
+	)
+	for _, code := range []int{500, 503} {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo.jpg", nil))
+		assert.Equal(t, code, w.Code)
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/status", nil))
+	assert.Equal(t, ErrSignatureMismatch.Code, w.Code, "status endpoint disabled by default")
+}
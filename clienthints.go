@@ -0,0 +1,148 @@
+package imagor
+
+import (
+	"github.com/cshum/imagor/imagorpath"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// clientHints request headers, with legacy headers as fallback
+var clientHints = []struct {
+	Name, Legacy string
+}{
+	{"Sec-CH-DPR", "DPR"},
+	{"Sec-CH-Width", "Width"},
+	{"Sec-CH-Viewport-Width", "Viewport-Width"},
+}
+
+var clientHintsHeader = strings.Join([]string{
+	clientHints[0].Name, clientHints[1].Name, clientHints[2].Name,
+}, ", ")
+
+// clientHintsWidthStep step in pixels that width hints are rounded up into,
+// bounding the number of results per params
+const clientHintsWidthStep = 100
+
+// maxClientHintWidth maximum width hints in pixels, hints above are ignored
+// such that they do not overflow dimensions nor fragment results
+const maxClientHintWidth = 10000
+
+// clientHintsDPRs device pixel ratios that DPR hint is rounded up into,
+// bounding the number of results per params
+var clientHintsDPRs = []float64{1, 1.5, 2, 3}
+
+// DimensionsLimiter optional Processor interface
+// that reports maximum output width and height
+type DimensionsLimiter interface {
+	MaxDimensions() (width, height int)
+}
+
+func getClientHint(r *http.Request, i int) float64 {
+	v := r.Header.Get(clientHints[i].Name)
+	if v == "" {
+		v = r.Header.Get(clientHints[i].Legacy)
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || f <= 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0
+	}
+	if i > 0 && f > maxClientHintWidth {
+		return 0
+	}
+	return f
+}
+
+// clientHintDPR returns device pixel ratio from client hint, 0 if absent
+func clientHintDPR(r *http.Request) float64 {
+	return getClientHint(r, 0)
+}
+
+// quantizeWidth rounds up width hint into steps
+func quantizeWidth(width float64) int {
+	return int(math.Ceil(width/clientHintsWidthStep)) * clientHintsWidthStep
+}
+
+// quantizeDPR rounds up DPR hint into the set of device pixel ratios
+func quantizeDPR(dpr float64) float64 {
+	for _, v := range clientHintsDPRs {
+		if dpr <= v {
+			return v
+		}
+	}
+	return clientHintsDPRs[len(clientHintsDPRs)-1]
+}
+
+// applyClientHints resizes params by client hints, quantized into steps.
+// Width and viewport width hints apply if params have no dimensions,
+// otherwise dimensions are scaled by DPR. Dimensions clamped by processor limits.
+// Returns the device pixel ratio output scaled by, 0 if not scaled by DPR, and true if params changed
+func (app *Imagor) applyClientHints(r *http.Request, p *imagorpath.Params) (dpr float64, ok bool) {
+	w, h := p.Width, p.Height
+	if w == 0 && h == 0 {
+		if width := getClientHint(r, 1); width > 0 {
+			// width hint is in physical pixels
+			w = quantizeWidth(width)
+		} else if vw := getClientHint(r, 2); vw > 0 {
+			dpr = quantizeDPR(clientHintDPR(r))
+			w = int(float64(quantizeWidth(vw)) * dpr)
+		}
+	} else if hint := clientHintDPR(r); hint > 0 {
+		dpr = quantizeDPR(hint)
+		w = int(math.Round(float64(w) * dpr))
+		h = int(math.Round(float64(h) * dpr))
+	}
+	if dpr == 1 {
+		dpr = 0
+	}
+	if maxW, maxH := app.maxDimensions(); maxW > 0 || maxH > 0 {
+		scaledW, scaledH := w, h
+		w, h = clampDimensions(w, h, maxW, maxH)
+		if dpr > 0 && scaledW != 0 {
+			dpr = math.Round(dpr*float64(w)/float64(scaledW)*100) / 100
+		} else if dpr > 0 && scaledH != 0 {
+			dpr = math.Round(dpr*float64(h)/float64(scaledH)*100) / 100
+		}
+	}
+	if w == p.Width && h == p.Height {
+		return dpr, false
+	}
+	p.Width, p.Height = w, h
+	return dpr, true
+}
+
+func (app *Imagor) maxDimensions() (width, height int) {
+	for _, processor := range app.Processors {
+		if limiter, ok := processor.(DimensionsLimiter); ok {
+			return limiter.MaxDimensions()
+		}
+	}
+	return
+}
+
+// clampDimensions scales down width and height within max dimensions,
+// keeping aspect ratio and sign of the dimensions
+func clampDimensions(w, h, maxW, maxH int) (int, int) {
+	absW, absH := math.Abs(float64(w)), math.Abs(float64(h))
+	ratio := 1.0
+	if maxW > 0 && absW > float64(maxW) {
+		ratio = float64(maxW) / absW
+	}
+	if maxH > 0 && absH*ratio > float64(maxH) {
+		ratio = float64(maxH) / absH
+	}
+	if ratio == 1 {
+		return w, h
+	}
+	return int(float64(w) * ratio), int(float64(h) * ratio)
+}
+
+// setClientHintsHeaders advertises accepted client hints,
+// with Content-DPR if output scaled by DPR
+func setClientHintsHeaders(w http.ResponseWriter, v *Variant) {
+	w.Header().Set("Accept-CH", clientHintsHeader)
+	if v.DPR > 0 {
+		w.Header().Set("Content-DPR", strconv.FormatFloat(v.DPR, 'f', -1, 64))
+	}
+}
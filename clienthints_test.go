@@ -0,0 +1,94 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type limitedProcessor struct {
+	processorFunc
+}
+
+func (limitedProcessor) MaxDimensions() (int, int) {
+	return 1000, 800
+}
+
+func TestClientHints(t *testing.T) {
+	resultStore := newMapStore()
+	app := New(
+		WithUnsafe(true),
+		WithClientHints(true),
+		WithResultStorages(resultStore),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithProcessors(limitedProcessor{func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			return NewBlobFromBytes([]byte(p.Path)), nil
+		}}),
+	)
+	serve := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		app.ServeHTTP(w, r)
+		return w
+	}
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		result string
+		dpr    string
+	}{
+		{"no hints", "/unsafe/200x100/foo.jpg", nil, "200x100/foo.jpg", ""},
+		{"dpr", "/unsafe/200x100/foo.jpg", map[string]string{"Sec-CH-DPR": "2"}, "400x200/foo.jpg", "2"},
+		{"dpr 1", "/unsafe/200x100/foo.jpg", map[string]string{"Sec-CH-DPR": "1"}, "200x100/foo.jpg", ""},
+		{"quantize dpr", "/unsafe/100x100/foo.jpg", map[string]string{"Sec-CH-DPR": "2.625"}, "300x300/foo.jpg", "3"},
+		{"quantize dpr max", "/unsafe/100x100/foo.jpg", map[string]string{"Sec-CH-DPR": "4"}, "300x300/foo.jpg", "3"},
+		{"legacy dpr", "/unsafe/fit-in/-200x0/foo.jpg", map[string]string{"DPR": "1.25"}, "fit-in/-300x0/foo.jpg", "1.5"},
+		{"invalid dpr", "/unsafe/200x100/foo.jpg", map[string]string{"Sec-CH-DPR": "abc"}, "200x100/foo.jpg", ""},
+		{"clamp max width", "/unsafe/800x400/foo.jpg", map[string]string{"Sec-CH-DPR": "3"}, "1000x500/foo.jpg", "1.25"},
+		{"clamp max height", "/unsafe/300x400/foo.jpg", map[string]string{"Sec-CH-DPR": "3"}, "600x800/foo.jpg", "2"},
+		{"width", "/unsafe/foo.jpg", map[string]string{"Sec-CH-Width": "640", "Sec-CH-DPR": "2"}, "700x0/foo.jpg", ""},
+		{"quantize width", "/unsafe/foo.jpg", map[string]string{"Sec-CH-Width": "601.5"}, "700x0/foo.jpg", ""},
+		{"viewport width", "/unsafe/fit-in/foo.jpg", map[string]string{"Sec-CH-Viewport-Width": "375", "Sec-CH-DPR": "2"}, "fit-in/800x0/foo.jpg", "2"},
+		{"viewport width clamp", "/unsafe/foo.jpg", map[string]string{"Sec-CH-Viewport-Width": "1920"}, "1000x0/foo.jpg", ""},
+		{"width overflow", "/unsafe/foo.jpg", map[string]string{"Sec-CH-Width": "1e300"}, "foo.jpg", ""},
+		{"width above max", "/unsafe/foo.jpg", map[string]string{"Sec-CH-Width": "10001"}, "foo.jpg", ""},
+		{"viewport width overflow", "/unsafe/foo.jpg", map[string]string{"Sec-CH-Viewport-Width": "1e300", "Sec-CH-DPR": "2"}, "foo.jpg", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.path, tt.header)
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, tt.result, w.Body.String())
+			assert.Equal(t, "Sec-CH-DPR, Sec-CH-Width, Sec-CH-Viewport-Width", w.Header().Get("Accept-CH"))
+			assert.Equal(t, "Sec-CH-DPR, Sec-CH-Width, Sec-CH-Viewport-Width", w.Header().Get("Vary"))
+			assert.Equal(t, tt.dpr, w.Header().Get("Content-DPR"))
+			assert.Eventually(t, func() bool {
+				resultStore.l.Lock()
+				defer resultStore.l.Unlock()
+				_, ok := resultStore.Map[tt.result]
+				return ok
+			}, time.Second, time.Millisecond, "result key should fold client hints")
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		app.ClientHints = false
+		defer func() {
+			app.ClientHints = true
+		}()
+		w := serve("/unsafe/200x100/foo.jpg", map[string]string{"Sec-CH-DPR": "2"})
+		assert.Equal(t, "200x100/foo.jpg", w.Body.String())
+		assert.Empty(t, w.Header().Get("Accept-CH"))
+		assert.Empty(t, w.Header().Get("Content-DPR"))
+	})
+}
@@ -1,20 +1,34 @@
 package main
 
 import (
+	"fmt"
 	"github.com/cshum/imagor/config"
 	"github.com/cshum/imagor/config/awsconfig"
 	"github.com/cshum/imagor/config/gcloudconfig"
+	"github.com/cshum/imagor/config/redisconfig"
 	"github.com/cshum/imagor/config/vipsconfig"
 	"os"
 )
 
 func main() {
-	var server = config.CreateServer(
-		os.Args[1:],
+	var funcs = []config.Func{
 		vipsconfig.WithVips,
 		awsconfig.WithAWS,
 		gcloudconfig.WithGCloud,
-	)
+		redisconfig.WithRedis,
+	}
+	if len(os.Args) > 1 && os.Args[1] == "process" {
+		failed, err := config.Process(os.Args[2:], os.Stdout, funcs...)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	var server = config.CreateServer(os.Args[1:], funcs...)
 	if server != nil {
 		server.Run()
 	}
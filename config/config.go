@@ -8,17 +8,25 @@ import (
 	"fmt"
 	"github.com/cshum/imagor"
 	"github.com/cshum/imagor/imagorpath"
+	"github.com/cshum/imagor/metrics/prometheusmetrics"
 	"github.com/cshum/imagor/server"
 	"github.com/peterbourgon/ff/v3"
 	"go.uber.org/zap"
+	"hash"
+	"net/http"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 var baseConfig = []Func{
 	withFileSystem,
 	withHTTPLoader,
+	withMemory,
+	withPrometheus,
+	withOTel,
 }
 
 func NewImagor(
@@ -33,73 +41,245 @@ func NewImagor(
 			"Output WebP format automatically if browser supports")
 		imagorAutoAVIF = fs.Bool("imagor-auto-avif", false,
 			"Output AVIF format automatically if browser supports (experimental)")
+		imagorAcceptBuckets = fs.Bool("imagor-accept-buckets", false,
+			"Normalise Accept header into buckets of supported image types for variant-aware result keys")
+		imagorClientHints = fs.Bool("imagor-client-hints", false,
+			"Enable Client Hints Sec-CH-DPR, Sec-CH-Width and Sec-CH-Viewport-Width for DPR-aware resizing")
 		imagorRequestTimeout = fs.Duration("imagor-request-timeout",
 			time.Second*30, "Timeout for performing Imagor request")
 		imagorLoadTimeout = fs.Duration("imagor-load-timeout",
 			time.Second*20, "Timeout for Imagor Loader request, should be smaller than imagor-request-timeout")
 		imagorSaveTimeout = fs.Duration("imagor-save-timeout",
 			time.Second*20, "Timeout for saving image to Imagor Storage")
+		imagorSaveRetryAttempts = fs.Int("imagor-save-retry-attempts", 0,
+			"Maximum attempts of retrying failed saves to Storage and Result Storage in background, with exponential backoff. Retry disabled if 0")
+		imagorSaveRetryBackoff = fs.Duration("imagor-save-retry-backoff", time.Second,
+			"Backoff before the first retry of failed save, doubled each attempt")
+		imagorSaveRetryQueueSize = fs.Int("imagor-save-retry-queue-size", 1000,
+			"Maximum number of failed saves queued for retry. Failed saves are dropped if queue is full")
+		imagorSaveRetryMemorySize = fs.Int64("imagor-save-retry-memory-size", 64<<20,
+			"Maximum total bytes of failed saves queued for retry in memory, when not spilled to journal. Failed saves are dropped if exceeded")
+		imagorSaveRetryJournal = fs.String("imagor-save-retry-journal", "",
+			"Directory of journal that spills failed saves queued for retry to disk, such that they survive restarts. Queued in memory if empty")
 		imagorProcessTimeout = fs.Duration("imagor-process-timeout",
 			time.Second*20, "Timeout for image processing")
 		imagorBasePathRedirect = fs.String("imagor-base-path-redirect", "",
 			"URL to redirect for Imagor / base path e.g. https://www.google.com")
 		imagorBaseParams = fs.String("imagor-base-params", "",
 			"Imagor endpoint base params that applies to all resulting images e.g. fitlers:watermark(example.jpg)")
+		imagorPresets = fs.String("imagor-presets", "",
+			"Named presets of Imagor endpoint params in semicolon separated name=params, e.g. card=fit-in/300x200;thumb=100x100/filters:quality(80). Used as /p/card/image.jpg or /unsafe/preset:card/image.jpg")
+		imagorPresetsOnly = fs.Bool("imagor-presets-only", false,
+			"Restrict unsafe requests to named presets only, without arbitrary transformations")
 		imagorProcessConcurrency = fs.Int64("imagor-process-concurrency",
 			-1, "Maximum number of image process to be executed simultaneously. Requests that exceed this limit are put in the queue. Set -1 for no limit")
 		imagorProcessQueueSize = fs.Int64("imagor-process-queue-size",
 			-1, "Maximum number of image process that can be put in the queue. Requests that exceed this limit are rejected with HTTP status 429. Set -1 for no limit")
+		imagorProcessQueueTimeout = fs.Duration("imagor-process-queue-timeout",
+			0, "Maximum time of image process waiting in the queue. Requests that exceed this limit are rejected with HTTP status 429")
+		imagorProcessMemoryBudget = fs.Int64("imagor-process-memory-budget",
+			0, "Memory budget in MB of image processes executed simultaneously, weighted by decoded pixels x frames estimated from image header. Requests that exceed this limit are put in the queue. Set 0 for no limit")
+		imagorPriorityClasses = fs.String("imagor-priority-classes", "",
+			"Process queue priority classes in comma separated name:weight or name:weight:image-path-prefix, e.g. interactive:4,batch:1:renditions/. The first class is the default")
+		imagorPriorityHeader = fs.String("imagor-priority-header", "",
+			"Request header that picks process queue priority class by name, e.g. X-Imagor-Priority")
 		imagorCacheHeaderTTL = fs.Duration("imagor-cache-header-ttl",
 			time.Hour*24*7, "Imagor HTTP Cache-Control header TTL for successful image response")
 		imagorCacheHeaderSWR = fs.Duration("imagor-cache-header-swr",
 			time.Hour*24, "Imagor HTTP Cache-Control header stale-while-revalidate for successful image response")
 		imagorCacheHeaderNoCache = fs.Bool("imagor-cache-header-no-cache",
 			false, "Imagor HTTP Cache-Control header no-cache for successful image response")
+		imagorStaleIfError = fs.Duration("imagor-stale-if-error", 0,
+			"Serve stale result if image loading or processing fails, within the duration of Cache-Control stale-if-error. Result is then refreshed in background. Set 0 to disable")
 		imagorModifiedTimeCheck = fs.Bool("imagor-modified-time-check", false,
 			"Check modified time of result image against the source image. This eliminates stale result but require more lookups")
+		imagorEnableUpload = fs.Bool("imagor-enable-upload", false,
+			"Enable POST and PUT requests for uploading images into Imagor Storage. Requires imagor-upload-secret unless imagor-unsafe")
+		imagorUploadSecret = fs.String("imagor-upload-secret", "",
+			"Secret for POST and PUT upload requests, with header Authorization: Bearer <secret>. URL signature does not authorize upload")
+		imagorUploadMaxSize = fs.Int64("imagor-upload-max-size", 32<<20,
+			"Maximum size in bytes of the uploaded image")
+		imagorEnableBatch = fs.Bool("imagor-enable-batch", false,
+			"Enable POST /batch requests for processing multiple variants of one source image, with JSON list of endpoint paths. Requires URL signature unless imagor-unsafe")
+		imagorPurgeSecret = fs.String("imagor-purge-secret", "",
+			"Secret for DELETE requests purging image and its results, with header Authorization: Bearer <secret>. Enable purge endpoint only if this value present")
+		imagorResultKeySourcePrefix = fs.Bool("imagor-result-key-source-prefix", false,
+			"Lay out result storage keys under prefix per source image, which allows purging results derived from an image")
+		imagorCircuitBreakerThreshold = fs.Int("imagor-circuit-breaker-threshold", 0,
+			"Number of consecutive failures, i.e. timeouts or server errors, before skipping a Loader or Storage for the cool-down period. Set 0 to disable")
+		imagorCircuitBreakerCooldown = fs.Duration("imagor-circuit-breaker-cooldown", time.Second*30,
+			"Cool-down period of a failing Loader or Storage before probing it with half-open requests")
+		imagorCircuitBreakerProbes = fs.Int("imagor-circuit-breaker-probes", 1,
+			"Number of successful half-open probe requests that close the circuit of a Loader or Storage")
+		imagorStatusPath = fs.String("imagor-status-path", "",
+			"Path of endpoint reporting health status of Loaders and Storages, e.g. /status. Disabled if empty")
+		imagorStatusSecret = fs.String("imagor-status-secret", "",
+			"Secret for status endpoint requests, with header Authorization: Bearer <secret>. Status endpoint is open without secret only if imagor-unsafe")
 		imagorDisableErrorBody      = fs.Bool("imagor-disable-error-body", false, "Imagor disable response body on error")
 		imagorDisableParamsEndpoint = fs.Bool("imagor-disable-params-endpoint", false, "Imagor disable /params endpoint")
 		imagorSignerType            = fs.String("imagor-signer-type", "sha1", "Imagor URL signature hasher type sha1 or sha256")
 		imagorSignerTruncate        = fs.Int("imagor-signer-truncate", 0, "Imagor URL signature truncate at length")
 
 		options, logger, isDebug = applyFuncs(fs, cb, append(funcs, baseConfig...)...)
-
-		alg = sha1.New
 	)
 
-	if strings.ToLower(*imagorSignerType) == "sha256" {
-		alg = sha256.New
-	} else if strings.ToLower(*imagorSignerType) == "sha512" {
-		alg = sha512.New
+	if *imagorPriorityClasses != "" {
+		classes, err := parsePriorityClasses(*imagorPriorityClasses)
+		if err != nil {
+			logger.Fatal("imagor-priority-classes", zap.Error(err))
+		}
+		options = append(options, imagor.WithPriorityClasses(classes...))
+	}
+
+	if *imagorPresets != "" {
+		presets, err := parsePresets(*imagorPresets)
+		if err != nil {
+			logger.Fatal("imagor-presets", zap.Error(err))
+		}
+		options = append(options, imagor.WithPresets(presets))
+	}
+
+	if *imagorResultKeySourcePrefix {
+		options = append(options, imagor.WithResultKey(imagor.SourcePrefixResultKey{}))
 	}
 
 	return imagor.New(append(
 		options,
 		imagor.WithSigner(imagorpath.NewHMACSigner(
-			alg, *imagorSignerTruncate, *imagorSecret,
+			signerAlg(*imagorSignerType), *imagorSignerTruncate, *imagorSecret,
 		)),
 		imagor.WithBasePathRedirect(*imagorBasePathRedirect),
 		imagor.WithBaseParams(*imagorBaseParams),
+		imagor.WithPresetsOnly(*imagorPresetsOnly),
 		imagor.WithRequestTimeout(*imagorRequestTimeout),
 		imagor.WithLoadTimeout(*imagorLoadTimeout),
 		imagor.WithSaveTimeout(*imagorSaveTimeout),
+		imagor.WithSaveRetryAttempts(*imagorSaveRetryAttempts),
+		imagor.WithSaveRetryBackoff(*imagorSaveRetryBackoff),
+		imagor.WithSaveRetryQueueSize(*imagorSaveRetryQueueSize),
+		imagor.WithSaveRetryMemorySize(*imagorSaveRetryMemorySize),
+		imagor.WithSaveRetryJournal(*imagorSaveRetryJournal),
 		imagor.WithProcessTimeout(*imagorProcessTimeout),
 		imagor.WithProcessConcurrency(*imagorProcessConcurrency),
 		imagor.WithProcessQueueSize(*imagorProcessQueueSize),
+		imagor.WithProcessQueueTimeout(*imagorProcessQueueTimeout),
+		imagor.WithProcessMemoryBudget(*imagorProcessMemoryBudget<<20),
+		imagor.WithPriorityHeader(*imagorPriorityHeader),
 		imagor.WithCacheHeaderTTL(*imagorCacheHeaderTTL),
 		imagor.WithCacheHeaderSWR(*imagorCacheHeaderSWR),
 		imagor.WithCacheHeaderNoCache(*imagorCacheHeaderNoCache),
+		imagor.WithStaleIfError(*imagorStaleIfError),
 		imagor.WithAutoWebP(*imagorAutoWebP),
 		imagor.WithAutoAVIF(*imagorAutoAVIF),
+		imagor.WithAcceptBuckets(*imagorAcceptBuckets),
+		imagor.WithClientHints(*imagorClientHints),
 		imagor.WithModifiedTimeCheck(*imagorModifiedTimeCheck),
 		imagor.WithDisableErrorBody(*imagorDisableErrorBody),
 		imagor.WithDisableParamsEndpoint(*imagorDisableParamsEndpoint),
+		imagor.WithEnableUpload(*imagorEnableUpload),
+		imagor.WithUploadSecret(*imagorUploadSecret),
+		imagor.WithUploadMaxSize(*imagorUploadMaxSize),
+		imagor.WithEnableBatch(*imagorEnableBatch),
+		imagor.WithPurgeSecret(*imagorPurgeSecret),
+		imagor.WithCircuitBreakerThreshold(*imagorCircuitBreakerThreshold),
+		imagor.WithCircuitBreakerCooldown(*imagorCircuitBreakerCooldown),
+		imagor.WithCircuitBreakerProbes(*imagorCircuitBreakerProbes),
+		imagor.WithStatusPath(*imagorStatusPath),
+		imagor.WithStatusSecret(*imagorStatusSecret),
 		imagor.WithUnsafe(*imagorUnsafe),
 		imagor.WithLogger(logger),
 		imagor.WithDebug(isDebug),
 	)...)
 }
 
+func parsePriorityClasses(s string) (classes []imagor.PriorityClass, err error) {
+	for _, seg := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(seg), ":", 3)
+		if parts[0] == "" {
+			continue
+		}
+		class := imagor.PriorityClass{Name: parts[0]}
+		if len(parts) > 1 && parts[1] != "" {
+			if class.Weight, err = strconv.Atoi(parts[1]); err != nil || class.Weight <= 0 {
+				return nil, fmt.Errorf("invalid priority class weight: %s", seg)
+			}
+		}
+		if len(parts) > 2 {
+			class.PathPrefix = parts[2]
+		}
+		classes = append(classes, class)
+	}
+	return
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	return ff.Parse(fs, args,
+		ff.WithEnvVars(),
+		ff.WithConfigFileFlag("config"),
+		ff.WithIgnoreUndefined(true),
+		ff.WithAllowMissingConfigFile(true),
+		ff.WithConfigFileParser(ff.EnvParser),
+	)
+}
+
+func parsePresets(s string) (presets map[string]string, err error) {
+	presets = map[string]string{}
+	for _, seg := range strings.Split(s, ";") {
+		if strings.TrimSpace(seg) == "" {
+			continue
+		}
+		name, params, _ := strings.Cut(seg, "=")
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, "/:") || strings.TrimSpace(params) == "" {
+			return nil, fmt.Errorf("invalid preset: %s", seg)
+		}
+		presets[name] = params
+	}
+	return
+}
+
+// signerAlg returns hasher of signer type sha1, sha256 or sha512, default sha1
+func signerAlg(signerType string) func() hash.Hash {
+	switch strings.ToLower(signerType) {
+	case "sha256":
+		return sha256.New
+	case "sha512":
+		return sha512.New
+	}
+	return sha1.New
+}
+
+// parseSignerKeys parses signing keys by ID in form of "id=secret; id2=secret2"
+func parseSignerKeys(s string, alg func() hash.Hash, truncate int) (signers map[string]imagorpath.Signer, err error) {
+	keys, err := parseKeys(s)
+	if err != nil {
+		return nil, err
+	}
+	signers = map[string]imagorpath.Signer{}
+	for id, secret := range keys {
+		signers[id] = imagorpath.NewHMACSigner(alg, truncate, secret)
+	}
+	return
+}
+
+// parseKeys parses keys by ID e.g. client1=key1;client2=key2
+func parseKeys(s string) (keys map[string]string, err error) {
+	keys = map[string]string{}
+	for _, seg := range strings.Split(s, ";") {
+		if strings.TrimSpace(seg) == "" {
+			continue
+		}
+		id, key, _ := strings.Cut(seg, "=")
+		id = strings.TrimSpace(id)
+		key = strings.TrimSpace(key)
+		if id == "" || key == "" {
+			return nil, fmt.Errorf("invalid key: %s", id)
+		}
+		keys[id] = key
+	}
+	return
+}
+
 func CreateServer(args []string, funcs ...Func) (srv *server.Server) {
 	var (
 		fs     = flag.NewFlagSet("imagor", flag.ExitOnError)
@@ -112,7 +292,7 @@ func CreateServer(args []string, funcs ...Func) (srv *server.Server) {
 		port         = fs.Int("port", 8000, "Sever port")
 		goMaxProcess = fs.Int("gomaxprocs", 0, "GOMAXPROCS")
 
-		_ = fs.String("config", ".env", "Retrieve configuration from the given file")
+		configFile = fs.String("config", ".env", "Retrieve configuration from the given file")
 
 		serverAddress = fs.String("server-address", "",
 			"Server address")
@@ -124,16 +304,34 @@ func CreateServer(args []string, funcs ...Func) (srv *server.Server) {
 			"Enable strip query string redirection")
 		serverAccessLog = fs.Bool("server-access-log", false,
 			"Enable server access log")
+		serverRateLimit = fs.Float64("server-rate-limit", 0,
+			"Maximum requests per second per client including cache hits. Requests that exceed this limit are rejected with HTTP status 429. Set 0 for no limit")
+		serverRateLimitBurst = fs.Int("server-rate-limit-burst", 0,
+			"Maximum burst of requests per client. Default to server-rate-limit rounded up")
+		serverProcessRateLimit = fs.Float64("server-process-rate-limit", 0,
+			"Maximum image processes per second per client, excluding cache hits of result storage. Requests that exceed this limit are rejected with HTTP status 429. Set 0 for no limit")
+		serverProcessRateLimitBurst = fs.Int("server-process-rate-limit-burst", 0,
+			"Maximum burst of image processes per client. Default to server-process-rate-limit rounded up")
+		serverRateLimitHeader = fs.String("server-rate-limit-header", "",
+			"Request header keying rate limit per client e.g. X-API-Key, of keys allowed by server-rate-limit-header-keys, fallback to client IP if absent or not allowed. Keyed by client IP if empty")
+		serverRateLimitHeaderKeys = fs.String("server-rate-limit-header-keys", "",
+			"Keys of server-rate-limit-header allowed by ID e.g. client1=key1;client2=key2, keying rate limit by the ID")
+		serverRateLimitTrustedProxies = fs.String("server-rate-limit-trusted-proxies", "",
+			"Trusted proxy IPs or CIDRs in csv e.g. 10.0.0.0/8, resolving client IP of rate limit from X-Forwarded-For and X-Real-Ip headers of requests from the proxies. Keyed by remote address if empty")
+		serverRateLimitSignerKeys = fs.String("server-rate-limit-signer-keys", "",
+			"Signing keys by ID e.g. client1=secret1;client2=secret2, keying rate limit of URLs signed by the key of imagor-signer-type, fallback to rate limit header or client IP")
+
+		tenants = fs.String("tenants", "",
+			"Tenant config files in csv, each a .env file of tenant specific options overriding the base options, with TENANT_HOSTS or TENANT_PATH_PREFIX routing requests to the tenant. Requests of no tenant matched are served by the base options")
+
+		configWatchInterval = fs.Duration("config-watch-interval", 0,
+			"Interval of polling config file and tenant config files for changes, reloading Imagor upon change without restart. Set 0 to disable")
+		configReloadSignal = fs.Bool("config-reload-signal", false,
+			"Reload Imagor from config file and tenant config files upon SIGHUP without restart")
 	)
 
 	app = NewImagor(fs, func() (*zap.Logger, bool) {
-		if err = ff.Parse(fs, args,
-			ff.WithEnvVars(),
-			ff.WithConfigFileFlag("config"),
-			ff.WithIgnoreUndefined(true),
-			ff.WithAllowMissingConfigFile(true),
-			ff.WithConfigFileParser(ff.EnvParser),
-		); err != nil {
+		if err = parseFlags(fs, args); err != nil {
 			panic(err)
 		}
 		if *debug {
@@ -158,13 +356,70 @@ func CreateServer(args []string, funcs ...Func) (srv *server.Server) {
 		runtime.GOMAXPROCS(*goMaxProcess)
 	}
 
-	return server.New(app,
+	var metricsPath string
+	var metricsHandler http.Handler
+	if m, ok := app.Metrics.(*prometheusmetrics.PrometheusMetrics); ok {
+		metricsPath = m.Path
+		metricsHandler = m
+	}
+
+	service, err := newService(fs, app, logger, *debug, funcs...)
+	if err != nil {
+		logger.Fatal("tenant", zap.Error(err))
+	}
+
+	var watchers []server.Watcher
+	if *configWatchInterval > 0 {
+		watchers = append(watchers, server.FileWatcher(
+			*configWatchInterval, append([]string{*configFile}, splitFiles(*tenants)...)...))
+	}
+	if *configReloadSignal {
+		watchers = append(watchers, server.SignalWatcher(syscall.SIGHUP))
+	}
+	var reloader server.Reloader
+	if len(watchers) > 0 {
+		shareTracerProvider(app)
+		reloader = func() (server.Service, error) {
+			return reload(fs, args, app, logger, *debug, funcs...)
+		}
+	}
+
+	var rateLimitKey = server.RateLimitByIP
+	if *serverRateLimitTrustedProxies != "" {
+		rateLimitKey = server.RateLimitByRealIP(strings.Split(*serverRateLimitTrustedProxies, ",")...)
+	}
+	if *serverRateLimitHeader != "" {
+		keys, err := parseKeys(*serverRateLimitHeaderKeys)
+		if err != nil {
+			logger.Fatal("server-rate-limit-header-keys", zap.Error(err))
+		}
+		if len(keys) == 0 {
+			logger.Warn("server-rate-limit-header without server-rate-limit-header-keys, keyed by client IP")
+		}
+		rateLimitKey = server.RateLimitByHeader(*serverRateLimitHeader, keys, rateLimitKey)
+	}
+	if *serverRateLimitSignerKeys != "" {
+		truncate, _ := strconv.Atoi(fs.Lookup("imagor-signer-truncate").Value.String())
+		signers, err := parseSignerKeys(*serverRateLimitSignerKeys,
+			signerAlg(fs.Lookup("imagor-signer-type").Value.String()), truncate)
+		if err != nil {
+			logger.Fatal("server-rate-limit-signer-keys", zap.Error(err))
+		}
+		rateLimitKey = server.RateLimitBySigner(signers, rateLimitKey)
+	}
+
+	return server.New(service,
+		server.WithMetrics(metricsPath, metricsHandler),
 		server.WithAddress(*serverAddress),
 		server.WithPort(*port),
 		server.WithPathPrefix(*serverPathPrefix),
 		server.WithCORS(*serverCORS),
 		server.WithStripQueryString(*serverStripQueryString),
 		server.WithAccessLog(*serverAccessLog),
+		server.WithRateLimit(*serverRateLimit, *serverRateLimitBurst),
+		server.WithProcessRateLimit(*serverProcessRateLimit, *serverProcessRateLimitBurst),
+		server.WithRateLimitKey(rateLimitKey),
+		server.WithReload(reloader, watchers...),
 		server.WithLogger(logger),
 		server.WithDebug(*debug),
 	)
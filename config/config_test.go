@@ -1,11 +1,19 @@
 package config
 
 import (
+	"context"
+	"crypto/sha256"
 	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
 	"github.com/cshum/imagor/loader/httploader"
+	"github.com/cshum/imagor/metrics/prometheusmetrics"
 	"github.com/cshum/imagor/storage/filestorage"
+	"github.com/cshum/imagor/storage/memorystorage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -148,3 +156,162 @@ func TestFileStorage(t *testing.T) {
 	assert.Equal(t, "/bcda/", resultStorage.PathPrefix)
 	assert.Equal(t, "!", resultStorage.SafeChars)
 }
+
+func TestStaleIfError(t *testing.T) {
+	srv := CreateServer([]string{"-imagor-stale-if-error", "1h"})
+	app := srv.App.(*imagor.Imagor)
+	assert.Equal(t, time.Hour, app.StaleIfError)
+}
+
+func TestClientHints(t *testing.T) {
+	srv := CreateServer([]string{"-imagor-client-hints", "-imagor-accept-buckets"})
+	app := srv.App.(*imagor.Imagor)
+	assert.True(t, app.ClientHints)
+	assert.True(t, app.AcceptBuckets)
+}
+
+func TestPriorityClasses(t *testing.T) {
+	srv := CreateServer([]string{
+		"-imagor-process-concurrency", "10",
+		"-imagor-process-queue-timeout", "5s",
+		"-imagor-process-memory-budget", "512",
+		"-imagor-priority-header", "X-Imagor-Priority",
+		"-imagor-priority-classes", "interactive:4, batch:1:renditions/,bulk",
+	})
+	app := srv.App.(*imagor.Imagor)
+	assert.Equal(t, time.Second*5, app.ProcessQueueTimeout)
+	assert.Equal(t, int64(512<<20), app.ProcessMemoryBudget)
+	assert.Equal(t, "X-Imagor-Priority", app.PriorityHeader)
+	assert.Equal(t, []imagor.PriorityClass{
+		{Name: "interactive", Weight: 4},
+		{Name: "batch", Weight: 1, PathPrefix: "renditions/"},
+		{Name: "bulk"},
+	}, app.PriorityClasses)
+
+	_, err := parsePriorityClasses("foo:bar")
+	assert.Error(t, err)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	srv := CreateServer([]string{
+		"-imagor-circuit-breaker-threshold", "5",
+		"-imagor-circuit-breaker-cooldown", "1m",
+		"-imagor-circuit-breaker-probes", "3",
+		"-imagor-status-path", "/status",
+		"-imagor-status-secret", "foo",
+	})
+	app := srv.App.(*imagor.Imagor)
+	assert.Equal(t, 5, app.CircuitBreakerThreshold)
+	assert.Equal(t, time.Minute, app.CircuitBreakerCooldown)
+	assert.Equal(t, 3, app.CircuitBreakerProbes)
+	assert.Equal(t, "/status", app.StatusPath)
+	assert.Equal(t, "foo", app.StatusSecret)
+}
+
+func TestPresets(t *testing.T) {
+	srv := CreateServer([]string{
+		"-imagor-presets", "card=fit-in/300x200/; thumb = 100x100/filters:quality(80),format(webp)",
+		"-imagor-presets-only",
+	})
+	app := srv.App.(*imagor.Imagor)
+	assert.Equal(t, map[string]string{
+		"card":  "fit-in/300x200",
+		"thumb": "100x100/filters:quality(80),format(webp)",
+	}, app.Presets)
+	assert.True(t, app.PresetsOnly)
+
+	_, err := parsePresets("card")
+	assert.Error(t, err)
+	_, err = parsePresets("p/card=100x100")
+	assert.Error(t, err)
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := CreateServer([]string{
+		"-server-rate-limit", "100",
+		"-server-process-rate-limit", "2.5",
+		"-server-process-rate-limit-burst", "10",
+		"-server-rate-limit-header", "X-API-Key",
+		"-server-rate-limit-header-keys", "foo=abc",
+		"-server-rate-limit-trusted-proxies", "10.0.0.0/8, 192.168.1.1",
+		"-server-rate-limit-signer-keys", "foo=1234; bar=5678",
+		"-imagor-signer-type", "sha256",
+	})
+	assert.Equal(t, float64(100), srv.RateLimit)
+	assert.Equal(t, 0, srv.RateLimitBurst)
+	assert.Equal(t, 2.5, srv.ProcessRateLimit)
+	assert.Equal(t, 10, srv.ProcessRateLimitBurst)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-API-Key", "abc")
+	assert.Equal(t, "X-API-Key:foo", srv.RateLimitKey(r))
+	r.Header.Set("X-API-Key", "xyz")
+	assert.Equal(t, "192.0.2.1", srv.RateLimitKey(r), "key not allowed")
+	r.Header.Set("X-API-Key", "abc")
+
+	signer := imagorpath.NewHMACSigner(sha256.New, 0, "5678")
+	r = httptest.NewRequest(http.MethodGet, "/"+signer.Sign("100x100/foo.jpg")+"/100x100/foo.jpg", nil)
+	r.Header.Set("X-API-Key", "abc")
+	assert.Equal(t, "signer:bar", srv.RateLimitKey(r))
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "8.8.8.8")
+	assert.Equal(t, "8.8.8.8", srv.RateLimitKey(r), "forwarded from trusted proxy")
+	r.RemoteAddr = "1.1.1.1:1234"
+	assert.Equal(t, "1.1.1.1", srv.RateLimitKey(r), "forwarded header not trusted")
+}
+
+func TestBatch(t *testing.T) {
+	srv := CreateServer([]string{"-imagor-enable-batch"})
+	app := srv.App.(*imagor.Imagor)
+	assert.True(t, app.EnableBatch)
+}
+
+func TestPurge(t *testing.T) {
+	srv := CreateServer([]string{
+		"-imagor-purge-secret", "foo",
+		"-imagor-result-key-source-prefix",
+	})
+	app := srv.App.(*imagor.Imagor)
+	assert.Equal(t, "foo", app.PurgeSecret)
+	assert.Equal(t, imagor.SourcePrefixResultKey{}, app.ResultKey)
+}
+
+func TestMemoryResultStorage(t *testing.T) {
+	srv := CreateServer([]string{
+		"-file-result-storage-base-dir", "./bar",
+		"-memory-result-storage-max-size", "1048576",
+		"-memory-result-storage-expiration", "1h",
+	})
+	app := srv.App.(*imagor.Imagor)
+	require.Equal(t, 2, len(app.ResultStorages))
+	memoryStorage := app.ResultStorages[0].(*memorystorage.MemoryStorage)
+	assert.Equal(t, int64(1048576), memoryStorage.MaxSize)
+	assert.Equal(t, time.Hour, memoryStorage.Expiration)
+	assert.IsType(t, &filestorage.FileStorage{}, app.ResultStorages[1])
+}
+
+func TestPrometheus(t *testing.T) {
+	srv := CreateServer([]string{
+		"-prometheus-enable",
+		"-prometheus-path", "/myprom",
+	})
+	app := srv.App.(*imagor.Imagor)
+	assert.IsType(t, &prometheusmetrics.PrometheusMetrics{}, app.Metrics)
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/myprom", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "imagor_queue_waiting")
+}
+
+func TestOTel(t *testing.T) {
+	srv := CreateServer([]string{
+		"-otel-enable",
+		"-otel-insecure",
+		"-otel-service-name", "myimagor",
+	})
+	app := srv.App.(*imagor.Imagor)
+	assert.IsType(t, &sdktrace.TracerProvider{}, app.TracerProvider)
+	assert.NoError(t, app.Shutdown(context.Background()))
+}
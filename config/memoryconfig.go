@@ -0,0 +1,31 @@
+package config
+
+import (
+	"flag"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/storage/memorystorage"
+	"go.uber.org/zap"
+)
+
+func withMemory(fs *flag.FlagSet, cb func() (*zap.Logger, bool)) imagor.Option {
+	var (
+		memoryResultStorageMaxSize = fs.Int64("memory-result-storage-max-size", 0,
+			"Maximum total bytes of Memory Result Storage. Enable Memory Result Storage only if this value present")
+		memoryResultStorageExpiration = fs.Duration("memory-result-storage-expiration", 0,
+			"Memory Result Storage expiration duration e.g. 1h. Default no expiration")
+
+		_, _ = cb()
+	)
+	return func(o *imagor.Imagor) {
+		if *memoryResultStorageMaxSize > 0 {
+			// activate Memory Result Storage only if max size config presents,
+			// placed in front of other result storages
+			o.ResultStorages = append([]imagor.Storage{
+				memorystorage.New(
+					memorystorage.WithMaxSize(*memoryResultStorageMaxSize),
+					memorystorage.WithExpiration(*memoryResultStorageExpiration),
+				),
+			}, o.ResultStorages...)
+		}
+	}
+}
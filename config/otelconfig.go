@@ -0,0 +1,64 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"github.com/cshum/imagor"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.uber.org/zap"
+)
+
+func withOTel(fs *flag.FlagSet, cb func() (*zap.Logger, bool)) imagor.Option {
+	var (
+		otelEnable = fs.Bool("otel-enable", false,
+			"Enable OpenTelemetry tracing with OTLP HTTP exporter")
+		otelEndpoint = fs.String("otel-endpoint", "localhost:4318",
+			"OpenTelemetry OTLP HTTP collector endpoint host:port")
+		otelURLPath = fs.String("otel-url-path", "",
+			"OpenTelemetry OTLP HTTP collector URL path. Default /v1/traces")
+		otelInsecure = fs.Bool("otel-insecure", false,
+			"OpenTelemetry OTLP HTTP exporter uses HTTP instead of HTTPS")
+		otelServiceName = fs.String("otel-service-name", "imagor",
+			"OpenTelemetry service name")
+		otelSampleRatio = fs.Float64("otel-sample-ratio", 1,
+			"OpenTelemetry trace sample ratio 0 to 1, parent-based")
+
+		logger, _ = cb()
+	)
+	return func(app *imagor.Imagor) {
+		if !*otelEnable {
+			return
+		}
+		var options = []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(*otelEndpoint),
+		}
+		if *otelURLPath != "" {
+			options = append(options, otlptracehttp.WithURLPath(*otelURLPath))
+		}
+		if *otelInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), options...)
+		if err != nil {
+			logger.Fatal("otel-exporter", zap.Error(err))
+		}
+		provider := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithSampler(sdktrace.ParentBased(
+				sdktrace.TraceIDRatioBased(*otelSampleRatio))),
+			sdktrace.WithResource(resource.NewWithAttributes(
+				semconv.SchemaURL,
+				semconv.ServiceNameKey.String(*otelServiceName),
+				semconv.ServiceVersionKey.String(imagor.Version),
+			)),
+		)
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		app.TracerProvider = provider
+	}
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcessResult result of an Imagor path processed offline
+type ProcessResult struct {
+	Path        string `json:"path"`
+	OK          bool   `json:"ok"`
+	Cached      bool   `json:"cached,omitempty"`
+	Size        int    `json:"size,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Duration    string `json:"duration"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Process processes Imagor paths of args and manifest file offline, without running the server.
+// Images are processed through the same Imagor pipeline, loaded from input directory or the configured loaders,
+// and saved to output directory or the configured result storages.
+// Result of each path is reported to w as a JSON line. Returns number of failed paths
+func Process(args []string, w io.Writer, funcs ...Func) (failed int, err error) {
+	var (
+		fs     = flag.NewFlagSet("imagor process", flag.ExitOnError)
+		logger *zap.Logger
+		app    *imagor.Imagor
+
+		debug = fs.Bool("debug", false, "Debug mode")
+
+		_ = fs.String("config", ".env", "Retrieve configuration from the given file")
+
+		processManifest = fs.String("process-manifest", "",
+			"Manifest file of Imagor paths to process, one path per line. Lines starting with # are ignored. Read from stdin if -")
+		processInput = fs.String("process-input", "",
+			"Directory of local input files, images of Imagor paths are loaded from. Shorthand of file-loader-base-dir")
+		processOutput = fs.String("process-output", "",
+			"Directory of output files, saved by Imagor paths. Shorthand of file-result-storage-base-dir")
+		processConcurrency = fs.Int("process-concurrency", runtime.NumCPU(),
+			"Number of Imagor paths processed in parallel")
+		processForce = fs.Bool("process-force", false,
+			"Process Imagor paths even if results exist in result storages. Otherwise existing results are reported as cached without processing")
+	)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: imagor process [options] [path ...]\n")
+		fs.PrintDefaults()
+	}
+
+	app = NewImagor(fs, func() (*zap.Logger, bool) {
+		if err = parseFlags(fs, args); err != nil {
+			panic(err)
+		}
+		if *processInput != "" {
+			_ = fs.Set("file-loader-base-dir", *processInput)
+		}
+		if *processOutput != "" {
+			_ = fs.Set("file-result-storage-base-dir", *processOutput)
+		}
+		if *debug {
+			logger, err = zap.NewDevelopment()
+		} else {
+			logger, err = zap.NewProduction()
+		}
+		if err != nil {
+			panic(err)
+		}
+		return logger, *debug
+	}, funcs...)
+	// paths are provided by the operator, signature not required
+	app.Unsafe = true
+	app.Hooks.OnResultHit = append(app.Hooks.OnResultHit, func(ctx context.Context, e imagor.Event) {
+		if cached, ok := ctx.Value(cachedCtxKey{}).(*bool); ok {
+			*cached = true
+		}
+	})
+
+	paths := fs.Args()
+	if *processManifest != "" {
+		var manifest []string
+		if manifest, err = readManifest(*processManifest); err != nil {
+			return
+		}
+		paths = append(paths, manifest...)
+	}
+	if len(paths) == 0 {
+		err = fmt.Errorf("no paths to process")
+		return
+	}
+
+	ctx := context.Background()
+	if err = app.Startup(ctx); err != nil {
+		return
+	}
+	defer func() {
+		if e := app.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	var (
+		l       sync.Mutex
+		wg      sync.WaitGroup
+		ch      = make(chan string)
+		encoder = json.NewEncoder(w)
+	)
+	concurrency := *processConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range ch {
+				result := processPath(ctx, app, path, *processForce)
+				l.Lock()
+				if !result.OK {
+					failed++
+				}
+				_ = encoder.Encode(result)
+				l.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		ch <- path
+	}
+	close(ch)
+	wg.Wait()
+	// make sure results saved before exit
+	app.Wait()
+	logger.Info("process", zap.Int("total", len(paths)), zap.Int("failed", failed))
+	return
+}
+
+type cachedCtxKey struct{}
+
+// processPath processes Imagor path by Imagor Serve,
+// reporting result as cached if served from result storages unless force
+func processPath(ctx context.Context, app *imagor.Imagor, path string, force bool) (result ProcessResult) {
+	var start = time.Now()
+	var err error
+	result.Path = path
+	if force {
+		ctx = imagor.WithRegenerate(ctx)
+	} else {
+		ctx = context.WithValue(ctx, cachedCtxKey{}, &result.Cached)
+	}
+	defer func() {
+		result.Duration = time.Since(start).String()
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.OK = true
+		}
+	}()
+	p := imagorpath.Parse("unsafe/" + strings.TrimPrefix(strings.TrimPrefix(path, "/"), "unsafe/"))
+	blob, err := app.Serve(ctx, p)
+	if err != nil {
+		return
+	}
+	buf, err := blob.ReadAll()
+	if err != nil {
+		return
+	}
+	result.Size = len(buf)
+	result.ContentType = blob.ContentType()
+	return
+}
+
+// readManifest reads Imagor paths of manifest file, one path per line
+func readManifest(file string) (paths []string, err error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r = f
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, line)
+		}
+	}
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	var (
+		input    = t.TempDir()
+		output   = t.TempDir()
+		manifest = filepath.Join(t.TempDir(), "manifest.txt")
+	)
+	require.NoError(t, os.WriteFile(filepath.Join(input, "foo.jpg"), []byte("foo"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(input, "bar.jpg"), []byte("bar"), 0644))
+	require.NoError(t, os.WriteFile(manifest, []byte("# renditions\nfit-in/100x100/bar.jpg\n\nmissing.jpg\n"), 0644))
+
+	var w bytes.Buffer
+	failed, err := Process([]string{
+		"-http-loader-disable",
+		"-process-input", input,
+		"-process-output", output,
+		"-process-manifest", manifest,
+		"-process-concurrency", "2",
+		"unsafe/foo.jpg",
+	}, &w)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failed)
+
+	results := map[string]ProcessResult{}
+	decoder := json.NewDecoder(&w)
+	for decoder.More() {
+		var result ProcessResult
+		require.NoError(t, decoder.Decode(&result))
+		results[result.Path] = result
+	}
+	require.Len(t, results, 3)
+	assert.True(t, results["unsafe/foo.jpg"].OK)
+	assert.Equal(t, 3, results["unsafe/foo.jpg"].Size)
+	assert.True(t, results["fit-in/100x100/bar.jpg"].OK)
+	assert.False(t, results["missing.jpg"].OK)
+	assert.NotEmpty(t, results["missing.jpg"].Error)
+
+	buf, err := os.ReadFile(filepath.Join(output, "foo.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(buf))
+	buf, err = os.ReadFile(filepath.Join(output, "fit-in/100x100/bar.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(buf))
+	assert.False(t, results["unsafe/foo.jpg"].Cached)
+
+	require.NoError(t, os.WriteFile(filepath.Join(input, "foo.jpg"), []byte("foo2"), 0644))
+	process := func(args ...string) ProcessResult {
+		var w bytes.Buffer
+		failed, err := Process(append([]string{
+			"-http-loader-disable",
+			"-process-input", input,
+			"-process-output", output,
+		}, args...), &w)
+		require.NoError(t, err)
+		assert.Equal(t, 0, failed)
+		var result ProcessResult
+		require.NoError(t, json.NewDecoder(&w).Decode(&result))
+		return result
+	}
+	result := process("foo.jpg")
+	assert.True(t, result.OK)
+	assert.True(t, result.Cached, "existing result reported cached")
+	buf, err = os.ReadFile(filepath.Join(output, "foo.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(buf))
+
+	result = process("-process-force", "foo.jpg")
+	assert.True(t, result.OK)
+	assert.False(t, result.Cached)
+	assert.Equal(t, 4, result.Size)
+	buf, err = os.ReadFile(filepath.Join(output, "foo.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo2", string(buf), "result regenerated")
+}
+
+func TestProcessNoPaths(t *testing.T) {
+	_, err := Process([]string{"-http-loader-disable"}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"flag"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/metrics/prometheusmetrics"
+	"go.uber.org/zap"
+)
+
+func withPrometheus(fs *flag.FlagSet, cb func() (*zap.Logger, bool)) imagor.Option {
+	var (
+		prometheusEnable = fs.Bool("prometheus-enable", false,
+			"Enable Prometheus metrics endpoint")
+		prometheusPath = fs.String("prometheus-path", "/metrics",
+			"Prometheus metrics endpoint path")
+
+		_, _ = cb()
+	)
+	return func(app *imagor.Imagor) {
+		if *prometheusEnable {
+			app.Metrics = prometheusmetrics.New(
+				prometheusmetrics.WithPath(*prometheusPath),
+			)
+		}
+	}
+}
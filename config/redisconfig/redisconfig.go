@@ -0,0 +1,91 @@
+package redisconfig
+
+import (
+	"flag"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/storage/redisstorage"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"sync"
+)
+
+// clientKey identity of Redis client
+type clientKey struct {
+	addr, username, password string
+	db                       int
+}
+
+// clients Redis clients shared by address, username, password and database,
+// such that tenants and reloads do not leak connection pools of their own
+var clients = struct {
+	sync.Mutex
+	m map[clientKey]*redis.Client
+}{m: map[clientKey]*redis.Client{}}
+
+// sharedClient returns Redis client of the options, created once per options
+func sharedClient(addr, username, password string, db int) *redis.Client {
+	key := clientKey{addr, username, password, db}
+	clients.Lock()
+	defer clients.Unlock()
+	if client, ok := clients.m[key]; ok {
+		return client
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Username: username,
+		Password: password,
+		DB:       db,
+	})
+	clients.m[key] = client
+	return client
+}
+
+func WithRedis(fs *flag.FlagSet, cb func() (*zap.Logger, bool)) imagor.Option {
+	var (
+		redisAddr = fs.String("redis-addr", "",
+			"Redis address host:port. Required if using Redis Storage")
+		redisUsername = fs.String("redis-username", "",
+			"Redis username")
+		redisPassword = fs.String("redis-password", "",
+			"Redis password")
+		redisDB = fs.Int("redis-db", 0,
+			"Redis database number")
+
+		redisStoragePrefix = fs.String("redis-storage-prefix", "",
+			"Key prefix for Redis Storage e.g. imagor:storage:. Enable Redis Storage only if this value present")
+		redisStorageExpiration = fs.Duration("redis-storage-expiration", 0,
+			"Redis Storage expiration duration e.g. 24h. Default no expiration")
+
+		redisResultStoragePrefix = fs.String("redis-result-storage-prefix", "",
+			"Key prefix for Redis Result Storage e.g. imagor:result:. Enable Redis Result Storage only if this value present")
+		redisResultStorageExpiration = fs.Duration("redis-result-storage-expiration", 0,
+			"Redis Result Storage expiration duration e.g. 24h. Default no expiration")
+
+		_, _ = cb()
+	)
+	return func(app *imagor.Imagor) {
+		if *redisAddr == "" {
+			return
+		}
+		// activate Redis client only if address present
+		client := sharedClient(*redisAddr, *redisUsername, *redisPassword, *redisDB)
+		if *redisStoragePrefix != "" {
+			// activate Redis Storage only if prefix config presents
+			app.Storages = append(app.Storages,
+				redisstorage.New(client,
+					redisstorage.WithPrefix(*redisStoragePrefix),
+					redisstorage.WithExpiration(*redisStorageExpiration),
+				),
+			)
+		}
+		if *redisResultStoragePrefix != "" {
+			// activate Redis Result Storage only if prefix config presents
+			app.ResultStorages = append(app.ResultStorages,
+				redisstorage.New(client,
+					redisstorage.WithPrefix(*redisResultStoragePrefix),
+					redisstorage.WithExpiration(*redisResultStorageExpiration),
+				),
+			)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package redisconfig
+
+import (
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/config"
+	"github.com/cshum/imagor/storage/redisstorage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRedisStorage(t *testing.T) {
+	srv := config.CreateServer([]string{
+		"-redis-addr", "localhost:6379",
+		"-redis-db", "2",
+
+		"-redis-storage-prefix", "a:",
+		"-redis-storage-expiration", "24h",
+
+		"-redis-result-storage-prefix", "b:",
+		"-redis-result-storage-expiration", "1h",
+	}, WithRedis)
+	app := srv.App.(*imagor.Imagor)
+	storage := app.Storages[0].(*redisstorage.RedisStorage)
+	assert.Equal(t, "a:", storage.Prefix)
+	assert.Equal(t, time.Hour*24, storage.Expiration)
+
+	resultStorage := app.ResultStorages[0].(*redisstorage.RedisStorage)
+	assert.Equal(t, "b:", resultStorage.Prefix)
+	assert.Equal(t, time.Hour, resultStorage.Expiration)
+	assert.Same(t, storage.Client, resultStorage.Client)
+
+	srv = config.CreateServer([]string{
+		"-redis-addr", "localhost:6379",
+		"-redis-db", "2",
+		"-redis-storage-prefix", "a:",
+	}, WithRedis)
+	app = srv.App.(*imagor.Imagor)
+	assert.Same(t, storage.Client, app.Storages[0].(*redisstorage.RedisStorage).Client, "client shared across instances")
+
+	srv = config.CreateServer([]string{
+		"-redis-addr", "localhost:6379",
+		"-redis-db", "3",
+		"-redis-storage-prefix", "a:",
+	}, WithRedis)
+	app = srv.App.(*imagor.Imagor)
+	assert.NotSame(t, storage.Client, app.Storages[0].(*redisstorage.RedisStorage).Client)
+}
+
+func TestRedisDisabled(t *testing.T) {
+	srv := config.CreateServer([]string{
+		"-redis-storage-prefix", "a:",
+	}, WithRedis)
+	app := srv.App.(*imagor.Imagor)
+	assert.Empty(t, app.Storages)
+}
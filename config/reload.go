@@ -0,0 +1,118 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/server"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"reflect"
+)
+
+// staticFlags Imagor options that cannot be reloaded without restart,
+// in addition to server options. VIPS runtime is shared, metrics and tracing are shared
+var staticFlags = map[string]bool{
+	"vips-concurrency":     true,
+	"vips-max-cache-files": true,
+	"vips-max-cache-mem":   true,
+	"vips-max-cache-size":  true,
+	"prometheus-enable":    true,
+	"prometheus-path":      true,
+	"otel-enable":          true,
+	"otel-endpoint":        true,
+	"otel-url-path":        true,
+	"otel-insecure":        true,
+	"otel-service-name":    true,
+	"otel-sample-ratio":    true,
+}
+
+// reload rebuilds Imagor service from args, environment variables, config file and tenant config files.
+// Changed options that cannot be reloaded are reported and remain effective as of startup
+func reload(
+	base *flag.FlagSet, args []string, app *imagor.Imagor, logger *zap.Logger, isDebug bool, funcs ...Func,
+) (service server.Service, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	// invalid options should fail the reload, instead of exiting the process
+	logger = logger.WithOptions(zap.WithFatalHook(zapcore.WriteThenPanic))
+	var (
+		fs     = flag.NewFlagSet("imagor", flag.ContinueOnError)
+		static = map[string]bool{}
+		newApp *imagor.Imagor
+	)
+	for name := range staticFlags {
+		static[name] = true
+	}
+	newApp = NewImagor(fs, func() (*zap.Logger, bool) {
+		// server options for parsing args only, except tenants being reloaded
+		base.VisitAll(func(f *flag.Flag) {
+			if fs.Lookup(f.Name) == nil {
+				cloneFlag(fs, f)
+				static[f.Name] = f.Name != "tenants"
+			}
+		})
+		if e := parseFlags(fs, args); e != nil {
+			panic(e)
+		}
+		var changed []string
+		base.VisitAll(func(f *flag.Flag) {
+			if static[f.Name] && fs.Lookup(f.Name).Value.String() != f.Value.String() {
+				changed = append(changed, f.Name)
+			}
+		})
+		if len(changed) > 0 {
+			logger.Warn("reload-requires-restart", zap.Strings("options", changed))
+		}
+		// metrics and tracing shared from base
+		_ = fs.Set("prometheus-enable", "false")
+		_ = fs.Set("otel-enable", "false")
+		return logger, isDebug
+	}, append(funcs, withShared(app))...)
+	return newService(fs, newApp, logger, isDebug, funcs...)
+}
+
+// cloneFlag defines flag of the same type, default value and usage
+func cloneFlag(fs *flag.FlagSet, f *flag.Flag) {
+	v := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+	_ = v.Set(f.DefValue)
+	fs.Var(v, f.Name, f.Usage)
+}
+
+// flushTracerProvider tracer provider that flushes spans upon Shutdown, instead of shutting down.
+// Allows tracer provider being shared across Imagor instances swapped by reload
+type flushTracerProvider struct {
+	trace.TracerProvider
+}
+
+func (tp flushTracerProvider) Shutdown(ctx context.Context) error {
+	if f, ok := tp.TracerProvider.(interface {
+		ForceFlush(ctx context.Context) error
+	}); ok {
+		return f.ForceFlush(ctx)
+	}
+	return nil
+}
+
+// shareTracerProvider wraps tracer provider of the Imagor for being shared
+func shareTracerProvider(app *imagor.Imagor) {
+	app.TracerProvider = sharedTracerProvider(app.TracerProvider)
+}
+
+// sharedTracerProvider returns tracer provider not shutting down the provider of the owner
+func sharedTracerProvider(tp trace.TracerProvider) trace.TracerProvider {
+	if _, ok := tp.(flushTracerProvider); ok {
+		return tp
+	}
+	if _, ok := tp.(interface {
+		Shutdown(ctx context.Context) error
+	}); ok {
+		return flushTracerProvider{tp}
+	}
+	return tp
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.env")
+	tenantFile := filepath.Join(dir, "tenant.env")
+	require.NoError(t, os.WriteFile(file, []byte("IMAGOR_SECRET=foo\nIMAGOR_CACHE_HEADER_TTL=1h\nPROMETHEUS_ENABLE=1\n"), 0644))
+
+	srv := CreateServer([]string{
+		"-config", file,
+		"-port", "8080",
+		"-config-reload-signal",
+		"-config-watch-interval", "1m",
+	})
+	app := srv.App.(*imagor.Imagor)
+	require.NotNil(t, srv.Reloader)
+	assert.Len(t, srv.Watchers, 2)
+	assert.Equal(t, time.Hour, app.CacheHeaderTTL)
+	assert.Equal(t, imagorpath.NewDefaultSigner("foo").Sign("foo.jpg"), app.Signer.Sign("foo.jpg"))
+
+	require.NoError(t, os.WriteFile(tenantFile, []byte("TENANT_PATH_PREFIX=/team-a\nIMAGOR_UNSAFE=1\n"), 0644))
+	require.NoError(t, os.WriteFile(file, []byte(
+		"IMAGOR_SECRET=bar\nIMAGOR_CACHE_HEADER_TTL=2h\nPROMETHEUS_ENABLE=1\nTENANTS="+tenantFile+"\n"), 0644))
+	require.NoError(t, srv.Reload(context.Background()))
+
+	tenants := srv.App.(*imagor.Tenants)
+	reloaded := tenants.Default
+	assert.NotSame(t, app, reloaded)
+	assert.Equal(t, 2*time.Hour, reloaded.CacheHeaderTTL)
+	assert.Equal(t, imagorpath.NewDefaultSigner("bar").Sign("foo.jpg"), reloaded.Signer.Sign("foo.jpg"))
+	assert.Same(t, app.Metrics, reloaded.Metrics, "metrics shared")
+	require.Len(t, tenants.Tenants, 1)
+	assert.True(t, tenants.Tenants[0].App.Unsafe)
+
+	require.NoError(t, os.WriteFile(file, []byte("IMAGOR_PRESETS=invalid\n"), 0644))
+	assert.Error(t, srv.Reload(context.Background()), "invalid options should fail reload")
+	assert.Same(t, tenants, srv.App)
+}
+
+func TestReloadRequiresRestart(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.env")
+	require.NoError(t, os.WriteFile(file, []byte("IMAGOR_SECRET=foo\n"), 0644))
+	args := []string{"-config", file, "-port", "8000"}
+	base := flag.NewFlagSet("imagor", flag.ContinueOnError)
+	base.String("config", ".env", "")
+	base.Int("port", 8000, "")
+	base.Bool("server-cors", false, "")
+	base.String("tenants", "", "")
+	app := NewImagor(base, func() (*zap.Logger, bool) {
+		require.NoError(t, parseFlags(base, args))
+		return zap.NewNop(), false
+	})
+
+	require.NoError(t, os.WriteFile(file, []byte("IMAGOR_SECRET=bar\nPORT=9000\nSERVER_CORS=1\nPROMETHEUS_PATH=/foo\n"), 0644))
+	core, logs := observer.New(zapcore.WarnLevel)
+	service, err := reload(base, args, app, zap.New(core), false)
+	require.NoError(t, err)
+	assert.Equal(t, imagorpath.NewDefaultSigner("bar").Sign("foo.jpg"),
+		service.(*imagor.Imagor).Signer.Sign("foo.jpg"))
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, "reload-requires-restart", logs.All()[0].Message)
+	assert.ElementsMatch(t, []interface{}{"server-cors", "prometheus-path"}, logs.All()[0].ContextMap()["options"],
+		"port of args takes precedence")
+}
+
+func TestShareTracerProvider(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	app := imagor.New(imagor.WithTracerProvider(provider))
+	shareTracerProvider(app)
+	assert.Equal(t, flushTracerProvider{provider}, app.TracerProvider)
+	assert.NoError(t, app.Shutdown(context.Background()))
+
+	_, span := provider.Tracer("imagor").Start(context.Background(), "foo")
+	span.End()
+	assert.Len(t, recorder.Ended(), 1, "tracer provider remains usable")
+	shareTracerProvider(app)
+	assert.Equal(t, flushTracerProvider{provider}, app.TracerProvider, "should not wrap twice")
+}
+
+func TestTenantSharedTracerProvider(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	app := imagor.New(imagor.WithTracerProvider(provider))
+	tenant := imagor.New(withShared(app)(nil, nil))
+	assert.Equal(t, flushTracerProvider{provider}, tenant.TracerProvider)
+	assert.NoError(t, tenant.Shutdown(context.Background()))
+
+	_, span := provider.Tracer("imagor").Start(context.Background(), "foo")
+	span.End()
+	assert.Len(t, recorder.Ended(), 1, "tracer provider not shut down by tenant")
+	assert.NoError(t, app.Shutdown(context.Background()))
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/server"
+	"github.com/peterbourgon/ff/v3"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NewTenant creates tenant from .env config file, with Imagor options inherited from the base flag set
+// and overridden by the config file. Tenant is routed by tenant-hosts or tenant-path-prefix of the config file.
+// Metrics and tracing are shared from the base Imagor
+func NewTenant(
+	base *flag.FlagSet, file string, app *imagor.Imagor, logger *zap.Logger, isDebug bool, funcs ...Func,
+) (tenant imagor.Tenant, err error) {
+	var (
+		fs = flag.NewFlagSet("imagor", flag.ContinueOnError)
+
+		tenantHosts = fs.String("tenant-hosts", "",
+			"Tenant hostnames in csv, with leading wildcard e.g. images.example.com,*.example.net")
+		tenantPathPrefix = fs.String("tenant-path-prefix", "",
+			"Tenant path prefix e.g. /team-a")
+	)
+	tenant.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	tenant.App = NewImagor(fs, func() (*zap.Logger, bool) {
+		if err = inheritFlags(fs, base); err == nil {
+			err = parseTenantFile(fs, file)
+		}
+		// retry journal inherited from base namespaced by tenant
+		if journal := fs.Lookup("imagor-save-retry-journal"); journal != nil && journal.Value.String() != "" {
+			if inherited := base.Lookup(journal.Name); inherited != nil &&
+				inherited.Value.String() == journal.Value.String() {
+				_ = fs.Set(journal.Name, filepath.Join(journal.Value.String(), tenant.Name))
+			}
+		}
+		// metrics and tracing shared from base
+		_ = fs.Set("prometheus-enable", "false")
+		_ = fs.Set("otel-enable", "false")
+		return logger.With(zap.String("tenant", tenant.Name)), isDebug
+	}, append(funcs, withShared(app))...)
+	if err != nil {
+		return
+	}
+	tenant.Hosts = strings.Split(*tenantHosts, ",")
+	tenant.PathPrefix = *tenantPathPrefix
+	if strings.TrimSpace(*tenantHosts) == "" && strings.Trim(*tenantPathPrefix, "/ ") == "" {
+		err = fmt.Errorf("tenant %s requires tenant-hosts or tenant-path-prefix", tenant.Name)
+	}
+	return
+}
+
+// newService creates Imagor service, routing to tenants of config files if any
+func newService(
+	fs *flag.FlagSet, app *imagor.Imagor, logger *zap.Logger, isDebug bool, funcs ...Func,
+) (server.Service, error) {
+	var files []string
+	if f := fs.Lookup("tenants"); f != nil {
+		files = splitFiles(f.Value.String())
+	}
+	if len(files) == 0 {
+		return app, nil
+	}
+	t := imagor.NewTenants(app)
+	for _, file := range files {
+		tenant, err := NewTenant(fs, file, app, logger, isDebug, funcs...)
+		if err != nil {
+			return nil, err
+		}
+		t.Add(tenant)
+	}
+	return t, nil
+}
+
+func splitFiles(s string) (files []string) {
+	for _, file := range strings.Split(s, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return
+}
+
+// withShared shares metrics and tracing of the base Imagor,
+// such that tracer provider is only shut down by the base Imagor
+func withShared(app *imagor.Imagor) Func {
+	return func(_ *flag.FlagSet, _ func() (*zap.Logger, bool)) imagor.Option {
+		return func(a *imagor.Imagor) {
+			a.Metrics = app.Metrics
+			a.TracerProvider = sharedTracerProvider(app.TracerProvider)
+		}
+	}
+}
+
+// inheritFlags sets flags explicitly set of the base flag set
+func inheritFlags(fs, base *flag.FlagSet) (err error) {
+	base.Visit(func(f *flag.Flag) {
+		if err == nil && fs.Lookup(f.Name) != nil {
+			err = fs.Set(f.Name, f.Value.String())
+		}
+	})
+	return
+}
+
+// parseTenantFile sets flags from .env config file, by either flag name or env var name
+func parseTenantFile(fs *flag.FlagSet, file string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	return ff.EnvParser(r, func(name, value string) error {
+		f := fs.Lookup(name)
+		if f == nil {
+			f = fs.Lookup(strings.ReplaceAll(strings.ToLower(name), "_", "-"))
+		}
+		if f == nil {
+			return fmt.Errorf("%s: %s not configurable per tenant", file, name)
+		}
+		return fs.Set(f.Name, value)
+	})
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"flag"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/cshum/imagor/loader/httploader"
+	"github.com/cshum/imagor/storage/filestorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTenants(t *testing.T) {
+	dir := t.TempDir()
+	teamA := filepath.Join(dir, "team-a.env")
+	teamB := filepath.Join(dir, "team-b.env")
+	require.NoError(t, os.WriteFile(teamA, []byte(`
+# team a
+TENANT_HOSTS=images.team-a.com,*.team-a.net
+IMAGOR_SECRET=secret-a
+HTTP_LOADER_ALLOWED_SOURCES=*.team-a.com
+FILE_STORAGE_PATH_PREFIX=team-a
+IMAGOR_PRESETS=card=fit-in/300x200
+`), 0644))
+	require.NoError(t, os.WriteFile(teamB, []byte(`
+tenant-path-prefix=/team-b
+imagor-unsafe=true
+imagor-save-retry-journal=./retry-b
+`), 0644))
+
+	srv := CreateServer([]string{
+		"-imagor-secret", "secret",
+		"-file-storage-base-dir", "./foo",
+		"-prometheus-enable",
+		"-imagor-save-retry-journal", "./retry",
+		"-tenants", teamA + ", " + teamB,
+	})
+	tenants := srv.App.(*imagor.Tenants)
+	app := tenants.Default
+	require.Len(t, tenants.Tenants, 2)
+	assert.Equal(t, imagorpath.NewDefaultSigner("secret").Sign("foo.jpg"), app.Signer.Sign("foo.jpg"))
+	assert.False(t, app.Unsafe)
+
+	a := tenants.Tenants[0]
+	assert.Equal(t, "team-a", a.Name)
+	assert.Equal(t, []string{"images.team-a.com", "*.team-a.net"}, a.Hosts)
+	assert.Equal(t, "", a.PathPrefix)
+	assert.Equal(t, imagorpath.NewDefaultSigner("secret-a").Sign("foo.jpg"), a.App.Signer.Sign("foo.jpg"))
+	assert.Equal(t, map[string]string{"card": "fit-in/300x200"}, a.App.Presets)
+	assert.Equal(t, []string{"*.team-a.com"}, a.App.Loaders[0].(*httploader.HTTPLoader).AllowedSources)
+	storage := a.App.Storages[0].(*filestorage.FileStorage)
+	assert.Equal(t, "./foo", storage.BaseDir, "inherit base options")
+	assert.Equal(t, "/team-a/", storage.PathPrefix)
+	assert.Same(t, app.Metrics, a.App.Metrics, "metrics shared from base")
+	assert.Equal(t, "./retry", app.SaveRetryJournal)
+	assert.Equal(t, filepath.Join("./retry", "team-a"), a.App.SaveRetryJournal, "journal namespaced by tenant")
+
+	b := tenants.Tenants[1]
+	assert.Equal(t, "team-b", b.Name)
+	assert.Equal(t, "/team-b", b.PathPrefix)
+	assert.True(t, b.App.Unsafe)
+	assert.Equal(t, "./retry-b", b.App.SaveRetryJournal)
+	assert.Equal(t, imagorpath.NewDefaultSigner("secret").Sign("foo.jpg"), b.App.Signer.Sign("foo.jpg"))
+
+	for path, expected := range map[string]int{
+		"https://images.team-a.com/unsafe/foo.jpg":          403,
+		"https://foo.team-a.net:8000/params/p/card/foo.jpg": 200,
+		"https://example.com/team-b/params/unsafe/foo.jpg":  200,
+		"https://example.com/params/p/card/foo.jpg":         200,
+	} {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, expected, w.Code, path)
+	}
+}
+
+func TestNewTenant(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "foo.env")
+	fs := flag.NewFlagSet("imagor", flag.ContinueOnError)
+	app := NewImagor(fs, func() (*zap.Logger, bool) {
+		return zap.NewNop(), false
+	})
+
+	require.NoError(t, os.WriteFile(file, []byte("IMAGOR_UNSAFE=true\n"), 0644))
+	_, err := NewTenant(fs, file, app, zap.NewNop(), false)
+	assert.Error(t, err, "requires hosts or path prefix")
+
+	require.NoError(t, os.WriteFile(file, []byte("TENANT_HOSTS=foo.com\nPORT=8080\n"), 0644))
+	_, err = NewTenant(fs, file, app, zap.NewNop(), false)
+	assert.Error(t, err, "server options not configurable per tenant")
+
+	_, err = NewTenant(fs, filepath.Join(dir, "missing.env"), app, zap.NewNop(), false)
+	assert.Error(t, err)
+}
@@ -16,12 +16,15 @@ var (
 	ErrInvalid               = NewError("invalid", http.StatusBadRequest)
 	ErrMethodNotAllowed      = NewError("method not allowed", http.StatusMethodNotAllowed)
 	ErrSignatureMismatch     = NewError("url signature mismatch", http.StatusForbidden)
+	ErrImageMismatch         = NewError("image mismatch", http.StatusBadRequest)
+	ErrUnauthorized          = NewError("unauthorized", http.StatusUnauthorized)
 	ErrTimeout               = NewError("timeout", http.StatusRequestTimeout)
 	ErrExpired               = NewError("expired", http.StatusGone)
 	ErrUnsupportedFormat     = NewError("unsupported format", http.StatusNotAcceptable)
 	ErrMaxSizeExceeded       = NewError("maximum size exceeded", http.StatusBadRequest)
 	ErrMaxResolutionExceeded = NewError("maximum resolution exceeded", http.StatusUnprocessableEntity)
 	ErrTooManyRequests       = NewError("too many requests", http.StatusTooManyRequests)
+	ErrCircuitOpen           = NewError("circuit open", http.StatusServiceUnavailable)
 	ErrInternal              = NewError("internal error", http.StatusInternalServerError)
 )
 
@@ -0,0 +1,145 @@
+package imagor
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"github.com/cshum/imagor/imagorpath"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// statETag generates strong ETag from result key and Stat attributes
+func statETag(key string, stat *Stat) string {
+	h := sha1.New()
+	_, _ = fmt.Fprintf(h, "%s\n%d\n%d", key, stat.ModifiedTime.UnixNano(), stat.Size)
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// contentETag generates strong ETag from content hash of the blob
+func contentETag(blob *Blob) string {
+	reader, _, err := blob.NewReader()
+	if err != nil || reader == nil {
+		return ""
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	h := sha1.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return ""
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// sourceStat returns Stat of source image from Storages, nil if not available
+func (app *Imagor) sourceStat(ctx context.Context, image string) *Stat {
+	if stat, err := app.storageStat(ctx, image); stat != nil && err == nil {
+		return stat
+	}
+	return nil
+}
+
+// setValidators sets ETag and Last-Modified of result blob from result key and source Stat of Storages,
+// such that they are the same for processed result and result storage hit.
+// If Storages not configured, uses Stat of the result storage hit if available,
+// or content hash ETag without Last-Modified of the result freshly processed in memory,
+// such that result storage hits are not read twice.
+// Not set if source Stat not available, e.g. source just loaded and not yet saved to Storages
+func (app *Imagor) setValidators(blob *Blob, resultKey string, srcStat *Stat, processed bool) {
+	if len(app.Storages) == 0 {
+		if stat := blob.Stat(); stat != nil && !stat.ModifiedTime.IsZero() {
+			blob.etag = statETag(resultKey, stat)
+			blob.modTime = stat.ModifiedTime
+		} else if processed && blob.Size() > 0 {
+			blob.etag = contentETag(blob)
+		}
+	} else if srcStat != nil {
+		blob.etag = statETag(resultKey, srcStat)
+		blob.modTime = srcStat.ModifiedTime
+	}
+}
+
+// checkNotModified returns not modified blob if conditional request matches validators of the result,
+// and the result exists in result storages.
+// Revalidation is then responded by Stat lookups, without loading result nor source image
+func (app *Imagor) checkNotModified(r *http.Request, p imagorpath.Params, resultKey string) *Blob {
+	if len(app.Storages) == 0 || len(app.ResultStorages) == 0 || isRegenerate(r.Context()) ||
+		(r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "") {
+		return nil
+	}
+	ctx := r.Context()
+	srcStat := app.sourceStat(ctx, p.Image)
+	if srcStat == nil {
+		return nil
+	}
+	blob := &Blob{notModified: true}
+	app.setValidators(blob, resultKey, srcStat, false)
+	if !isNotModified(r, blob) {
+		return nil
+	}
+	for i, storage := range app.ResultStorages {
+		breaker := app.breaker("result_storage", i)
+		if !breaker.allow() {
+			continue
+		}
+		stat, err := storage.Stat(ctx, resultKey)
+		breaker.done(err)
+		if stat != nil && err == nil {
+			if app.ModifiedTimeCheck && stat.ModifiedTime.Before(srcStat.ModifiedTime) {
+				return nil
+			}
+			return blob
+		}
+	}
+	return nil
+}
+
+// IsNotModified returns true if blob is a not modified response of conditional request,
+// which has validators but without image content
+func (b *Blob) IsNotModified() bool {
+	return b.notModified
+}
+
+// setConditionalHeaders sets ETag and Last-Modified headers of blob if available
+func setConditionalHeaders(w http.ResponseWriter, blob *Blob) {
+	if blob.etag != "" {
+		w.Header().Set("ETag", blob.etag)
+	}
+	if !blob.modTime.IsZero() {
+		w.Header().Set("Last-Modified", blob.modTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// isNotModified checks If-None-Match and If-Modified-Since request headers
+// against ETag and modified time of the blob, as of RFC 7232
+func isNotModified(r *http.Request, blob *Blob) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return blob.etag != "" && matchETag(inm, blob.etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if blob.modTime.IsZero() {
+			return false
+		}
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !blob.modTime.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// matchETag weak comparison of If-None-Match header value against etag
+func matchETag(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
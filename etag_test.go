@@ -0,0 +1,176 @@
+package imagor
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statResultStore result storage of blobs with Stat
+type statResultStore struct {
+	saverFunc
+	modTime time.Time
+}
+
+func (s statResultStore) Get(r *http.Request, image string) (*Blob, error) {
+	blob := NewBlobFromBytes([]byte("result"))
+	blob.SetStat(&Stat{ModifiedTime: s.modTime, Size: 6})
+	return blob, nil
+}
+
+func TestConditionalRequest(t *testing.T) {
+	srcTime := time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC)
+	store := newMapStore()
+	resultStore := newMapStore()
+	for _, image := range []string{"cached", "abcdef"} {
+		store.Map[image] = NewBlobFromBytes([]byte(image))
+		store.ModTime[image] = srcTime
+	}
+	resultStore.Map["cached"] = NewBlobFromBytes([]byte("cached result"))
+	resultStore.ModTime["cached"] = srcTime.Add(time.Hour)
+
+	var processCnt int32
+	app := New(
+		WithUnsafe(true),
+		WithStorages(store),
+		WithResultStorages(resultStore),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			atomic.AddInt32(&processCnt, 1)
+			buf, _ := blob.ReadAll()
+			return NewBlobFromBytes(append([]byte("processed "), buf[:4]...)), nil
+		})),
+	)
+	serve := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		app.ServeHTTP(w, r)
+		app.Wait()
+		return w
+	}
+
+	t.Run("result storage hit", func(t *testing.T) {
+		w := serve("/unsafe/cached", nil)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "cached result", w.Body.String())
+		etag := w.Header().Get("ETag")
+		assert.Equal(t, statETag("cached", &Stat{ModifiedTime: srcTime}), etag)
+		assert.Equal(t, "Mon, 01 Aug 2022 10:00:00 GMT", w.Header().Get("Last-Modified"),
+			"modified time of source")
+
+		loadCnt := resultStore.LoadCnt["cached"]
+		w = serve("/unsafe/cached", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		assert.NotEmpty(t, w.Header().Get("Cache-Control"))
+		assert.Equal(t, loadCnt, resultStore.LoadCnt["cached"], "result not loaded")
+		assert.Equal(t, 0, store.LoadCnt["cached"], "source not loaded")
+
+		w = serve("/unsafe/cached", map[string]string{"If-None-Match": `"foo", W/` + etag})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+
+		w = serve("/unsafe/cached", map[string]string{"If-None-Match": `"foo"`})
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "cached result", w.Body.String())
+
+		w = serve("/unsafe/cached", map[string]string{"If-Modified-Since": "Mon, 01 Aug 2022 10:00:00 GMT"})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+
+		w = serve("/unsafe/cached", map[string]string{"If-Modified-Since": "Mon, 01 Aug 2022 09:59:59 GMT"})
+		assert.Equal(t, 200, w.Code)
+
+		// If-None-Match takes precedence over If-Modified-Since
+		w = serve("/unsafe/cached", map[string]string{
+			"If-None-Match":     `"foo"`,
+			"If-Modified-Since": "Mon, 01 Aug 2022 10:00:00 GMT",
+		})
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("stable across processed and result hit", func(t *testing.T) {
+		w := serve("/unsafe/abcdef", nil)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "processed abcd", w.Body.String())
+		etag := w.Header().Get("ETag")
+		lastModified := w.Header().Get("Last-Modified")
+		assert.Equal(t, statETag("abcdef", &Stat{ModifiedTime: srcTime}), etag)
+		assert.Equal(t, "Mon, 01 Aug 2022 10:00:00 GMT", lastModified)
+		require.Contains(t, resultStore.Map, "abcdef")
+
+		w = serve("/unsafe/abcdef", nil)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		assert.Equal(t, lastModified, w.Header().Get("Last-Modified"))
+
+		w = serve("/unsafe/abcdef", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&processCnt))
+	})
+
+	t.Run("source not yet saved", func(t *testing.T) {
+		w := serve("/unsafe/ghijkl", nil)
+		assert.Equal(t, 200, w.Code)
+		assert.Empty(t, w.Header().Get("ETag"))
+		assert.Empty(t, w.Header().Get("Last-Modified"))
+	})
+
+	t.Run("content hash without storages", func(t *testing.T) {
+		resultStore := newMapStore()
+		app := New(
+			WithUnsafe(true),
+			WithResultStorages(resultStore),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				return NewBlobFromFile("testdata/demo1.jpg"), nil
+			})),
+			WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				return NewBlobFromBytes([]byte("processed")), nil
+			})),
+		)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/file", nil))
+		app.Wait()
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, fmt.Sprintf(`"%x"`, sha1.Sum([]byte("processed"))), w.Header().Get("ETag"))
+		assert.Empty(t, w.Header().Get("Last-Modified"))
+
+		resultStore.l.Lock()
+		resultStore.Map["file"] = NewBlobFromBytes([]byte("processed"))
+		resultStore.l.Unlock()
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/file", nil))
+		assert.Equal(t, 200, w.Code)
+		assert.Empty(t, w.Header().Get("ETag"), "result storage hit without stat not hashed")
+		assert.Equal(t, 1, resultStore.LoadCnt["file"])
+	})
+
+	t.Run("result stat without storages", func(t *testing.T) {
+		modTime := time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC)
+		app := New(
+			WithUnsafe(true),
+			WithResultStorages(statResultStore{modTime: modTime}),
+			WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+				return nil, ErrNotFound
+			})),
+		)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/file", nil))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "result", w.Body.String())
+		assert.Equal(t, statETag("file", &Stat{ModifiedTime: modTime, Size: 6}), w.Header().Get("ETag"))
+		assert.Equal(t, modTime.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+	})
+}
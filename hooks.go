@@ -0,0 +1,125 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"time"
+)
+
+// EventType type of Imagor lifecycle event
+type EventType string
+
+// Imagor lifecycle event types
+const (
+	EventLoad              EventType = "load"
+	EventResultHit         EventType = "result_hit"
+	EventProcessed         EventType = "processed"
+	EventSaved             EventType = "saved"
+	EventSaveError         EventType = "save_error"
+	EventSignatureMismatch EventType = "signature_mismatch"
+)
+
+// Event lifecycle event of the Imagor pipeline
+type Event struct {
+	Type EventType
+	// Params of the request, empty if not applicable
+	Params imagorpath.Params
+	// Kind of storage or loader, i.e. loader, storage or result_storage
+	Kind string
+	// Key of image or result
+	Key string
+	// Duration of the operation
+	Duration time.Duration
+	// Size of the blob, 0 if unknown
+	Size int64
+	Err  error
+}
+
+// Hook receives lifecycle event.
+// Hooks are called synchronously in the pipeline, use AsyncHook for slow hooks
+type Hook func(ctx context.Context, e Event)
+
+// AsyncHook returns Hook that calls hook in goroutine,
+// with context detached from cancellation of the request
+func AsyncHook(hook Hook) Hook {
+	return func(ctx context.Context, e Event) {
+		go hook(DetachContext(ctx), e)
+	}
+}
+
+// Hooks lifecycle hooks of Imagor by event types
+type Hooks struct {
+	// OnLoad source image loaded from Storages or Loaders, or failed
+	OnLoad []Hook
+	// OnResultHit result served from Result Storages without processing
+	OnResultHit []Hook
+	// OnProcessed image freshly processed, or failed
+	OnProcessed []Hook
+	// OnSaved image saved to a Storage or Result Storage
+	OnSaved []Hook
+	// OnSaveError image failed saving to a Storage or Result Storage
+	OnSaveError []Hook
+	// OnSignatureMismatch request rejected by URL signature mismatch
+	OnSignatureMismatch []Hook
+}
+
+// list returns pointer to hooks of the event type
+func (h *Hooks) list(t EventType) *[]Hook {
+	switch t {
+	case EventLoad:
+		return &h.OnLoad
+	case EventResultHit:
+		return &h.OnResultHit
+	case EventProcessed:
+		return &h.OnProcessed
+	case EventSaved:
+		return &h.OnSaved
+	case EventSaveError:
+		return &h.OnSaveError
+	case EventSignatureMismatch:
+		return &h.OnSignatureMismatch
+	}
+	return nil
+}
+
+var eventParamsCtxKey = &contextKey{"EventParams"}
+
+// withEventParams returns context with params of events emitted
+func withEventParams(ctx context.Context, p imagorpath.Params) context.Context {
+	return context.WithValue(ctx, eventParamsCtxKey, p)
+}
+
+// emit calls hooks of the event type, with params of context if not specified
+func (app *Imagor) emit(ctx context.Context, e Event) {
+	hooks := app.Hooks.list(e.Type)
+	if hooks == nil || len(*hooks) == 0 {
+		return
+	}
+	if e.Params.Path == "" && e.Params.Image == "" {
+		e.Params, _ = ctx.Value(eventParamsCtxKey).(imagorpath.Params)
+	}
+	for _, hook := range *hooks {
+		hook(ctx, e)
+	}
+}
+
+func processedEvent(p imagorpath.Params, key string, start time.Time, blob *Blob, err error) Event {
+	return Event{Type: EventProcessed, Params: p, Key: key,
+		Duration: time.Since(start), Size: blobSize(blob), Err: err}
+}
+
+func saveEvent(kind, key string, start time.Time, blob *Blob, err error) Event {
+	e := Event{Type: EventSaved, Kind: kind, Key: key,
+		Duration: time.Since(start), Size: blobSize(blob), Err: err}
+	if err != nil {
+		e.Type = EventSaveError
+	}
+	return e
+}
+
+func blobSize(blob *Blob) int64 {
+	if isBlobEmpty(blob) {
+		return 0
+	}
+	return blob.Size()
+}
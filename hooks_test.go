@@ -0,0 +1,121 @@
+package imagor
+
+import (
+	"context"
+	"errors"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	var l sync.Mutex
+	var events []Event
+	record := func(ctx context.Context, e Event) {
+		l.Lock()
+		defer l.Unlock()
+		events = append(events, e)
+	}
+	takeEvents := func() []Event {
+		l.Lock()
+		defer l.Unlock()
+		e := events
+		events = nil
+		return e
+	}
+	saveErr := errors.New("save failed")
+	store := newMapStore()
+	resultStore := newMapStore()
+	var hooks []Option
+	for _, eventType := range []EventType{
+		EventLoad, EventResultHit, EventProcessed, EventSaved, EventSaveError, EventSignatureMismatch,
+	} {
+		hooks = append(hooks, WithHook(eventType, record))
+	}
+	app := New(append(hooks,
+		WithSigner(imagorpath.NewDefaultSigner("1234")),
+		WithStorages(store),
+		WithResultStorages(resultStore, saverFunc(func(ctx context.Context, image string, blob *Blob) error {
+			return saveErr
+		})),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			return NewBlobFromBytes([]byte(p.Path)), nil
+		})),
+	)...)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abcdefghijkl/foo.jpg", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	e := takeEvents()
+	require.Len(t, e, 1)
+	assert.Equal(t, EventSignatureMismatch, e[0].Type)
+	assert.Equal(t, "foo.jpg", e[0].Params.Image)
+	assert.Equal(t, ErrSignatureMismatch, e[0].Err)
+
+	path := imagorpath.Generate(imagorpath.Params{Image: "foo.jpg", Width: 100},
+		imagorpath.NewDefaultSigner("1234"))
+	blob, err := app.Serve(context.Background(), imagorpath.Parse(path))
+	require.NoError(t, err)
+	assert.Equal(t, "100x0/foo.jpg", string(blob.Sniff()))
+	app.Wait()
+	byType := map[EventType][]Event{}
+	for _, e := range takeEvents() {
+		byType[e.Type] = append(byType[e.Type], e)
+		assert.Equal(t, "100x0/foo.jpg", e.Params.Path, "params of event %s", e.Type)
+	}
+	require.Len(t, byType[EventLoad], 1)
+	assert.Equal(t, "loader", byType[EventLoad][0].Kind)
+	assert.Equal(t, "foo.jpg", byType[EventLoad][0].Key)
+	assert.Equal(t, int64(7), byType[EventLoad][0].Size)
+	require.Len(t, byType[EventProcessed], 1)
+	assert.Equal(t, "100x0/foo.jpg", byType[EventProcessed][0].Key)
+	assert.Equal(t, int64(13), byType[EventProcessed][0].Size)
+	assert.NoError(t, byType[EventProcessed][0].Err)
+	require.Len(t, byType[EventSaved], 2)
+	assert.ElementsMatch(t, []string{"storage", "result_storage"},
+		[]string{byType[EventSaved][0].Kind, byType[EventSaved][1].Kind})
+	require.Len(t, byType[EventSaveError], 1)
+	assert.Equal(t, "result_storage", byType[EventSaveError][0].Kind)
+	assert.Equal(t, saveErr, byType[EventSaveError][0].Err)
+
+	_, err = app.Serve(context.Background(), imagorpath.Parse(path))
+	require.NoError(t, err)
+	e = takeEvents()
+	require.Len(t, e, 1)
+	assert.Equal(t, EventResultHit, e[0].Type)
+	assert.Equal(t, "100x0/foo.jpg", e[0].Key)
+	assert.Equal(t, int64(13), e[0].Size)
+}
+
+func TestAsyncHook(t *testing.T) {
+	ch := make(chan Event, 1)
+	done := make(chan struct{})
+	app := New(
+		WithUnsafe(true),
+		WithHook(EventProcessed, AsyncHook(func(ctx context.Context, e Event) {
+			<-done
+			assert.NoError(t, ctx.Err(), "detached from request")
+			ch <- e
+		})),
+		WithHook(EventLoad, nil),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+	)
+	assert.Empty(t, app.Hooks.OnLoad)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := app.Serve(ctx, imagorpath.Parse("unsafe/foo.jpg"))
+	require.NoError(t, err)
+	cancel()
+	close(done)
+	e := <-ch
+	assert.Equal(t, EventProcessed, e.Type)
+	assert.Equal(t, "foo.jpg", e.Key)
+}
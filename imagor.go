@@ -6,6 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/cshum/imagor/imagorpath"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"golang.org/x/sync/semaphore"
 	"golang.org/x/sync/singleflight"
@@ -33,6 +36,12 @@ type Storage interface {
 	Delete(ctx context.Context, key string) error
 }
 
+// Promoter optional result Storage interface of a hot tier placed in front of other result storages,
+// which is written back with hits loaded from result storages behind it
+type Promoter interface {
+	Promote(ctx context.Context, key string, blob *Blob) error
+}
+
 // LoadFunc load function for Processor
 type LoadFunc func(string) (*Blob, error)
 
@@ -56,56 +65,99 @@ type ResultKey interface {
 
 // Imagor image resize HTTP handler
 type Imagor struct {
-	Unsafe                bool
-	Signer                imagorpath.Signer
-	BasePathRedirect      string
-	Loaders               []Loader
-	Storages              []Storage
-	ResultStorages        []Storage
-	Processors            []Processor
-	RequestTimeout        time.Duration
-	LoadTimeout           time.Duration
-	SaveTimeout           time.Duration
-	ProcessTimeout        time.Duration
-	CacheHeaderTTL        time.Duration
-	CacheHeaderSWR        time.Duration
-	ProcessConcurrency    int64
-	ProcessQueueSize      int64
-	AutoWebP              bool
-	AutoAVIF              bool
-	ModifiedTimeCheck     bool
-	DisableErrorBody      bool
-	DisableParamsEndpoint bool
-	BaseParams            string
-	Logger                *zap.Logger
-	Debug                 bool
-	ResultKey             ResultKey
+	Unsafe                  bool
+	Signer                  imagorpath.Signer
+	BasePathRedirect        string
+	Loaders                 []Loader
+	Storages                []Storage
+	ResultStorages          []Storage
+	Processors              []Processor
+	RequestTimeout          time.Duration
+	LoadTimeout             time.Duration
+	SaveTimeout             time.Duration
+	SaveRetryAttempts       int
+	SaveRetryBackoff        time.Duration
+	SaveRetryQueueSize      int
+	SaveRetryJournal        string
+	SaveRetryMemorySize     int64
+	ProcessTimeout          time.Duration
+	CacheHeaderTTL          time.Duration
+	CacheHeaderSWR          time.Duration
+	StaleIfError            time.Duration
+	ProcessConcurrency      int64
+	ProcessQueueSize        int64
+	ProcessQueueTimeout     time.Duration
+	ProcessMemoryBudget     int64
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+	CircuitBreakerProbes    int
+	StatusPath              string
+	StatusSecret            string
+	PriorityClasses         []PriorityClass
+	PriorityHeader          string
+	AutoWebP                bool
+	AutoAVIF                bool
+	AcceptBuckets           bool
+	ClientHints             bool
+	ModifiedTimeCheck       bool
+	DisableErrorBody        bool
+	DisableParamsEndpoint   bool
+	EnableUpload            bool
+	UploadMaxSize           int64
+	UploadSecret            string
+	EnableBatch             bool
+	PurgeSecret             string
+	BaseParams              string
+	Presets                 map[string]string
+	PresetsOnly             bool
+	Logger                  *zap.Logger
+	Debug                   bool
+	ResultKey               ResultKey
+	Metrics                 Metrics
+	TracerProvider          trace.TracerProvider
+	Hooks                   Hooks
 
 	g          singleflight.Group
-	sema       *semaphore.Weighted
-	queueSema  *semaphore.Weighted
+	tracer     trace.Tracer
+	scheduler  *scheduler
+	memorySema *semaphore.Weighted
+	breakers   map[string][]*circuitBreaker
 	baseParams imagorpath.Params
+	background tracker
+	retry      *retryQueue
 }
 
 // New create new Imagor
 func New(options ...Option) *Imagor {
 	app := &Imagor{
-		Logger:         zap.NewNop(),
-		RequestTimeout: time.Second * 30,
-		LoadTimeout:    time.Second * 20,
-		SaveTimeout:    time.Second * 20,
-		ProcessTimeout: time.Second * 20,
-		CacheHeaderTTL: time.Hour * 24 * 7,
-		CacheHeaderSWR: time.Hour * 24,
+		Logger:                 zap.NewNop(),
+		RequestTimeout:         time.Second * 30,
+		LoadTimeout:            time.Second * 20,
+		SaveTimeout:            time.Second * 20,
+		ProcessTimeout:         time.Second * 20,
+		CacheHeaderTTL:         time.Hour * 24 * 7,
+		CacheHeaderSWR:         time.Hour * 24,
+		UploadMaxSize:          maxBodySize,
+		CircuitBreakerCooldown: time.Second * 30,
+		CircuitBreakerProbes:   1,
+		SaveRetryBackoff:       time.Second,
+		SaveRetryQueueSize:     1000,
+		SaveRetryMemorySize:    64 << 20,
+		Metrics:                nopMetrics{},
+		TracerProvider:         otel.GetTracerProvider(),
 	}
 	for _, option := range options {
 		option(app)
 	}
-	if app.ProcessConcurrency > 0 {
-		app.sema = semaphore.NewWeighted(app.ProcessConcurrency)
+	app.tracer = app.TracerProvider.Tracer(TracerName, trace.WithInstrumentationVersion(Version))
+	app.scheduler = newScheduler(
+		app.ProcessConcurrency, app.ProcessQueueSize, app.ProcessQueueTimeout, app.PriorityClasses...)
+	app.initBreakers()
+	if app.ProcessMemoryBudget > 0 {
+		app.memorySema = semaphore.NewWeighted(app.ProcessMemoryBudget)
 	}
-	if app.ProcessQueueSize > 0 {
-		app.queueSema = semaphore.NewWeighted(app.ProcessQueueSize + app.ProcessConcurrency)
+	if app.SaveRetryAttempts > 0 {
+		app.retry = newRetryQueue(app)
 	}
 	if app.Debug {
 		app.debugLog()
@@ -127,27 +179,67 @@ func (app *Imagor) Startup(ctx context.Context) (err error) {
 			return
 		}
 	}
+	if app.retry != nil {
+		err = app.retry.start()
+	}
 	return
 }
 
-// Shutdown Imagor shutdown lifecycle
+// Shutdown Imagor shutdown lifecycle.
+// Stops accepting background work, and waits for outstanding background work
+// such as storage saves and deletes until context done, before shutting down processors.
+// Requests served afterwards save within the request instead of in background
 func (app *Imagor) Shutdown(ctx context.Context) (err error) {
+	app.background.close()
+	if abandoned := app.background.wait(ctx); abandoned > 0 {
+		app.Logger.Warn("shutdown-abandoned", zap.Int64("count", abandoned), zap.Error(ctx.Err()))
+	}
+	if app.retry != nil {
+		app.retry.stop()
+	}
 	for _, processor := range app.Processors {
 		if err = processor.Shutdown(ctx); err != nil {
 			return
 		}
 	}
+	// flush pending spans if tracer provider is shutdown-able e.g. OTel SDK
+	if tp, ok := app.TracerProvider.(interface {
+		Shutdown(ctx context.Context) error
+	}); ok {
+		err = tp.Shutdown(ctx)
+	}
 	return
 }
 
+// Pending returns number of outstanding background work,
+// i.e. processing and storage saves that continue after Do returned
+func (app *Imagor) Pending() int64 {
+	return app.background.pending()
+}
+
+// Wait waits for processing and storage saves that continue in background
+// after Do returned, e.g. before exit of a one-off process
+func (app *Imagor) Wait() {
+	app.background.wait(context.Background())
+}
+
 // ServeHTTP implements http.Handler for Imagor operations
 func (app *Imagor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+	isUpload := r.Method == http.MethodPost || r.Method == http.MethodPut
+	isPurge := r.Method == http.MethodDelete
+	path := r.URL.EscapedPath()
+	isBatch := r.Method == http.MethodPost && path == "/batch"
+	if r.Method != http.MethodGet && r.Method != http.MethodHead &&
+		!(isUpload && app.EnableUpload) && !(isBatch && app.EnableBatch) &&
+		!(isPurge && app.PurgeSecret != "") {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	path := r.URL.EscapedPath()
-	if path == "/" || path == "" {
+	if app.StatusPath != "" && path == app.StatusPath && !isUpload && !isPurge {
+		app.handleStatus(w, r)
+		return
+	}
+	if (path == "/" || path == "") && !isPurge {
 		if app.BasePathRedirect == "" {
 			writeJSON(w, r, json.RawMessage(fmt.Sprintf(
 				`{"imagor":{"version":"%s"}}`, Version,
@@ -157,14 +249,35 @@ func (app *Imagor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	p := imagorpath.Parse(path)
-	if p.Params {
-		if !app.DisableParamsEndpoint {
-			writeJSONIndent(w, r, p)
+	var blob *Blob
+	var err error
+	var variant Variant
+	var expires *time.Time
+	if isPurge {
+		blob, err = checkBlob(app.Purge(r, strings.TrimPrefix(path, "/")))
+	} else if isBatch && app.EnableBatch {
+		blob, err = checkBlob(app.Batch(r))
+	} else {
+		p := imagorpath.Parse(path)
+		if p.Params {
+			if !app.DisableParamsEndpoint && !isUpload && !app.writePresetParams(w, r, p) {
+				writeJSONIndent(w, r, p)
+			}
+			return
+		}
+		if isUpload {
+			blob, err = checkBlob(app.Upload(r, p))
+		} else {
+			expires = p.Expires
+			blob, err = checkBlob(app.Do(r.WithContext(withVariant(r.Context(), &variant)), p))
+			setVaryHeader(w, &variant)
+			if err == nil && blob != nil && blob.notModified {
+				app.setImageHeaders(w, blob, &variant, expires)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
 		}
-		return
 	}
-	blob, err := checkBlob(app.Do(r, p))
 	if !isBlobEmpty(blob) {
 		w.Header().Set("Content-Type", blob.ContentType())
 	}
@@ -193,93 +306,164 @@ func (app *Imagor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if isBlobEmpty(blob) {
 		return
 	}
+	if isUpload || isPurge || isBatch {
+		setCacheHeaders(w, 0, 0)
+	} else {
+		app.setImageHeaders(w, blob, &variant, expires)
+		if isNotModified(r, blob) {
+			if reader, _, _ := blob.NewReader(); reader != nil {
+				_ = reader.Close()
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if writeRange(w, r, blob) {
+			return
+		}
+	}
 	reader, size, _ := blob.NewReader()
-	setCacheHeaders(w, app.CacheHeaderTTL, app.CacheHeaderSWR)
 	writeBody(w, r, reader, size)
 	return
 }
 
-// Do executes Imagor operations
+// setImageHeaders sets cache and conditional headers of image response.
+// Cache TTL capped by expiry of the URL if any
+func (app *Imagor) setImageHeaders(w http.ResponseWriter, blob *Blob, v *Variant, expires *time.Time) {
+	setConditionalHeaders(w, blob)
+	if blob.stale {
+		setStaleHeaders(w, app.StaleIfError)
+	} else {
+		ttl := app.CacheHeaderTTL
+		if expires != nil {
+			if until := time.Until(*expires); until < ttl {
+				ttl = until
+			}
+			if ttl < time.Second {
+				ttl = 0
+			}
+		}
+		setCacheHeaders(w, ttl, app.CacheHeaderSWR)
+		if app.StaleIfError > 0 && expires == nil {
+			setStaleIfErrorHeader(w, app.StaleIfError)
+		}
+	}
+	if app.ClientHints {
+		setClientHintsHeaders(w, v)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+}
+
+// Do executes Imagor operations of the HTTP request, on top of Serve.
+// Returns blob of IsNotModified without content if conditional request headers
+// match the result in result storages
 func (app *Imagor) Do(r *http.Request, p imagorpath.Params) (blob *Blob, err error) {
-	var ctx = DeferContext(r.Context())
+	return app.Serve(context.WithValue(r.Context(), requestCtxKey, r), p)
+}
+
+// Serve loads, processes and saves image of the params, without HTTP request.
+// Features depending on request headers are set explicitly by context,
+// i.e. WithAccept, WithDeviceHints, WithForwardHeaders, WithPriority and WithRegenerate
+func (app *Imagor) Serve(ctx context.Context, p imagorpath.Params) (blob *Blob, err error) {
+	r := serveRequest(ctx, p)
+	ctx, span := app.startRequestSpan(r, "imagor.Do",
+		attribute.String("imagor.image", p.Image), attribute.String("imagor.path", p.Path))
+	ctx = DeferContext(withMetricsContext(ctx, app.Metrics))
 	var cancel func()
+	defer func() {
+		if err != nil {
+			app.Metrics.ObserveError(errorCode(err))
+		}
+		endSpan(span, err)
+	}()
 	if app.RequestTimeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, app.RequestTimeout)
 		Defer(ctx, cancel)
-		r = r.WithContext(ctx)
 	}
-	if !(app.Unsafe && p.Unsafe) && app.Signer != nil && app.Signer.Sign(p.Path) != p.Hash {
-		err = ErrSignatureMismatch
-		if app.Debug {
-			app.Logger.Debug("sign-mismatch", zap.Any("params", p), zap.String("expected", app.Signer.Sign(p.Path)))
-		}
+	var req, params = r, p
+	if app.StaleIfError > 0 {
+		ctx = AllowStale(ctx)
+	}
+	r = r.WithContext(ctx)
+	if app.Unsafe && app.isPresetPath(p) {
+		// preset endpoint without hash, e.g. /p/card/image.jpg
+		p.Unsafe = true
+	}
+	if err = app.checkSignature(ctx, p); err != nil {
+		return
+	}
+	if p, err = app.applyPreset(p); err != nil {
 		return
 	}
 	if app.BaseParams != "" {
 		p = imagorpath.Apply(p, app.BaseParams)
 		p.Path = imagorpath.GeneratePath(p)
 	}
-	// auto WebP / AVIF
-	if app.AutoWebP || app.AutoAVIF {
-		var hasFormat bool
-		for _, f := range p.Filters {
-			if f.Name == "format" {
-				hasFormat = true
-			}
-		}
-		if !hasFormat {
-			accept := r.Header.Get("Accept")
-			if app.AutoAVIF && strings.Contains(accept, "image/avif") {
-				p.Filters = append(p.Filters, imagorpath.Filter{
-					Name: "format",
-					Args: "avif",
-				})
-				p.Path = imagorpath.GeneratePath(p)
-			} else if app.AutoWebP && strings.Contains(accept, "image/webp") {
-				p.Filters = append(p.Filters, imagorpath.Filter{
-					Name: "format",
-					Args: "webp",
-				})
-				p.Path = imagorpath.GeneratePath(p)
-			}
-		}
-	}
-	var resultKey string
-	if app.ResultKey != nil {
-		resultKey = app.ResultKey.Generate(p)
-	} else {
-		resultKey = p.Path
+	// auto WebP / AVIF, client hints
+	p, variant := app.negotiate(r, p)
+	var resultKey = app.resultKey(p, variant)
+	ctx = withEventParams(ctx, p)
+	r = r.WithContext(ctx)
+	if blob = app.checkNotModified(r, p, resultKey); blob != nil {
+		return blob, nil
 	}
 	load := func(image string) (*Blob, error) {
 		blob, shouldSave, err := app.loadStorage(r, image)
 		if shouldSave {
-			go app.save(ctx, app.Storages, image, blob)
+			app.saveBackground(ctx, "storage", image, blob, nil)
 		}
 		return blob, err
 	}
-	return app.suppress(ctx, resultKey, func(ctx context.Context, cb func(*Blob, error)) (*Blob, error) {
-		if blob := app.loadResult(r, resultKey, p.Image); blob != nil {
-			return blob, nil
+	var flightKey = resultKey
+	if isRegenerate(ctx) {
+		// not to be joined by lookup of the existing result
+		flightKey = "regenerate:" + resultKey
+	}
+	return app.suppress(ctx, flightKey, func(ctx context.Context, cb func(*Blob, error)) (blob *Blob, err error) {
+		// processing continues in background after cb, e.g. saving result,
+		// or within the request if shutting down
+		var tracked = app.background.add()
+		if tracked {
+			defer app.background.done()
 		}
-		if app.queueSema != nil {
-			if !app.queueSema.TryAcquire(1) {
-				err = ErrTooManyRequests
-				if app.Debug {
-					app.Logger.Debug("queue-acquire", zap.Error(err))
-				}
-				return blob, err
+		var start = time.Now()
+		var stale *Blob
+		if blob, origin, isStale := app.loadResult(r, resultKey, p.Image); blob != nil {
+			if !isStale {
+				app.setValidators(blob, resultKey, app.sourceStat(ctx, p.Image), false)
+				app.promote(ctx, resultKey, blob, origin)
+				app.Metrics.ObserveRequest("result", time.Since(start))
+				app.emit(ctx, Event{Type: EventResultHit, Params: p, Kind: "result_storage", Key: resultKey,
+					Duration: time.Since(start), Size: blob.Size()})
+				return blob, nil
 			}
-			defer app.queueSema.Release(1)
+			stale = blob
 		}
-		if app.sema != nil {
-			if err = app.sema.Acquire(ctx, 1); err != nil {
-				if app.Debug {
-					app.Logger.Debug("acquire", zap.Error(err))
+		defer func() {
+			if err != nil && stale != nil {
+				if blob, err = app.serveStale(req, params, resultKey, stale, blob, err); err == nil {
+					app.Metrics.ObserveRequest("stale", time.Since(start))
+					return
 				}
-				return blob, err
+			} else if stale != nil {
+				// release unused stale result
+				if reader, _, _ := stale.NewReader(); reader != nil {
+					_ = reader.Close()
+				}
+			}
+			if err != nil {
+				app.Metrics.ObserveRequest("error", time.Since(start))
+			} else {
+				app.Metrics.ObserveRequest("processed", time.Since(start))
 			}
-			defer app.sema.Release(1)
+		}()
+		if err = checkProcessLimit(r.Context()); err != nil {
+			return blob, err
 		}
+		var release func()
+		if release, err = app.acquire(ctx, r, p); err != nil {
+			return blob, err
+		}
+		defer release()
 		var shouldSave bool
 		if blob, shouldSave, err = app.loadStorage(r, p.Image); err != nil {
 			if app.Debug {
@@ -290,97 +474,268 @@ func (app *Imagor) Do(r *http.Request, p imagorpath.Params) (blob *Blob, err err
 		var doneSave chan struct{}
 		if shouldSave {
 			doneSave = make(chan struct{}, 1)
-			go func(blob *Blob) {
-				app.save(ctx, app.Storages, p.Image, blob)
-				doneSave <- struct{}{}
-			}(blob)
+			app.saveBackground(ctx, "storage", p.Image, blob, doneSave)
 		}
 		if isBlobEmpty(blob) {
 			return blob, err
 		}
-		var cancel func()
-		if app.ProcessTimeout > 0 {
-			ctx, cancel = context.WithTimeout(ctx, app.ProcessTimeout)
-			Defer(ctx, cancel)
+		var releaseMemory func()
+		if releaseMemory, err = app.acquireMemory(ctx, blob, p); err != nil {
+			return nil, err
 		}
-		for _, processor := range app.Processors {
-			b, e := checkBlob(processor.Process(ctx, blob, p, load))
-			if e == nil {
-				blob = b
-				err = nil
-				if app.Debug {
-					app.Logger.Debug("processed", zap.Any("params", p))
-				}
-				break
-			} else {
-				if e == ErrPass {
-					if !isBlobEmpty(b) {
-						// pass to next processor
-						blob = b
-					}
-					if app.Debug {
-						app.Logger.Debug("process", zap.Any("params", p), zap.Error(e))
-					}
-				} else {
-					err = e
-					app.Logger.Warn("process", zap.Any("params", p), zap.Error(err))
-					if errors.Is(err, context.DeadlineExceeded) {
-						break
-					}
-				}
+		defer releaseMemory()
+		var processStart = time.Now()
+		blob, err = app.process(ctx, blob, p, load)
+		app.emit(ctx, processedEvent(p, resultKey, processStart, blob, err))
+		if err == nil && !isBlobEmpty(blob) {
+			var srcStat *Stat
+			if !shouldSave {
+				// source loaded from Storages
+				srcStat = app.sourceStat(ctx, p.Image)
 			}
+			app.setValidators(blob, resultKey, srcStat, true)
+		}
+		if tracked && (err == nil || stale == nil) {
+			cb(blob, err)
 		}
-		cb(blob, err)
 		if shouldSave {
 			// make sure storage saved before result storage
 			<-doneSave
 		}
 		if err == nil && !isBlobEmpty(blob) && len(app.ResultStorages) > 0 {
-			app.save(ctx, app.ResultStorages, resultKey, blob)
+			app.save(ctx, "result_storage", resultKey, blob)
 		}
 		if err != nil && shouldSave {
-			app.del(ctx, app.Storages, p.Image)
+			app.del(ctx, "storage", p.Image)
 		}
 		return blob, err
 	})
 }
 
+// saveBackground saves blob to storages as background work, notifying done if not nil.
+// Saved within the caller if shutting down, such that shutdown does not miss the save
+func (app *Imagor) saveBackground(ctx context.Context, kind, key string, blob *Blob, done chan struct{}) {
+	save := func() {
+		app.save(ctx, kind, key, blob)
+		if done != nil {
+			done <- struct{}{}
+		}
+	}
+	if !app.background.goFunc(save) {
+		save()
+	}
+}
+
+func (app *Imagor) checkSignature(ctx context.Context, p imagorpath.Params) error {
+	if !(app.Unsafe && p.Unsafe) && app.Signer != nil && app.Signer.Sign(p.Path) != p.Hash {
+		if app.Debug {
+			app.Logger.Debug("sign-mismatch", zap.Any("params", p), zap.String("expected", app.Signer.Sign(p.Path)))
+		}
+		app.emit(ctx, Event{Type: EventSignatureMismatch, Params: p, Key: p.Path, Err: ErrSignatureMismatch})
+		return ErrSignatureMismatch
+	}
+	for _, f := range p.Filters {
+		if f.Name == imagorpath.FilterExpire {
+			if _, err := strconv.ParseInt(f.Args, 10, 64); err != nil {
+				// malformed expiry never expires otherwise
+				return ErrInvalid
+			}
+		}
+	}
+	if p.Expires != nil && !time.Now().Before(*p.Expires) {
+		if app.Debug {
+			app.Logger.Debug("expired", zap.Any("params", p))
+		}
+		return ErrExpired
+	}
+	return nil
+}
+
+func (app *Imagor) resultKey(p imagorpath.Params, v Variant) string {
+	if p.Expires != nil || hasFilter(p.Filters, priorityFilter) {
+		// result shared across expiry and priority of the same params
+		var filters imagorpath.Filters
+		for _, f := range p.Filters {
+			if f.Name != imagorpath.FilterExpire && f.Name != priorityFilter {
+				filters = append(filters, f)
+			}
+		}
+		p.Filters = filters
+		p.Expires = nil
+		p.Path = imagorpath.GeneratePath(p)
+	}
+	if k, ok := app.ResultKey.(VariantResultKey); ok {
+		return k.GenerateVariant(p, v)
+	}
+	if app.ResultKey != nil {
+		return app.ResultKey.Generate(p)
+	}
+	return p.Path
+}
+
+func (app *Imagor) process(
+	ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc,
+) (*Blob, error) {
+	var err error
+	var cancel func()
+	if app.ProcessTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, app.ProcessTimeout)
+		Defer(ctx, cancel)
+	}
+	for _, processor := range app.Processors {
+		start := time.Now()
+		spanCtx, span := app.startSpan(ctx, "imagor.process",
+			attribute.String("imagor.processor", getType(processor)))
+		b, e := checkBlob(processor.Process(spanCtx, blob, p, load))
+		endSpan(span, e)
+		app.Metrics.ObserveProcess(getType(processor), time.Since(start), e)
+		if e == nil {
+			blob = b
+			err = nil
+			if app.Debug {
+				app.Logger.Debug("processed", zap.Any("params", p))
+			}
+			break
+		} else {
+			if e == ErrPass {
+				if !isBlobEmpty(b) {
+					// pass to next processor
+					blob = b
+				}
+				if app.Debug {
+					app.Logger.Debug("process", zap.Any("params", p), zap.Error(e))
+				}
+			} else {
+				err = e
+				app.Logger.Warn("process", zap.Any("params", p), zap.Error(err))
+				if errors.Is(err, context.DeadlineExceeded) {
+					break
+				}
+			}
+		}
+	}
+	return blob, err
+}
+
 func (app *Imagor) loadStorage(r *http.Request, key string) (blob *Blob, shouldSave bool, err error) {
 	var origin Storage
-	blob, origin, err = app.load(r, app.Storages, app.Loaders, key)
+	var start = time.Now()
+	blob, origin, err = app.load(r, "storage", app.Storages, app.Loaders, key)
 	if err == nil && !isBlobEmpty(blob) && origin == nil && len(app.Storages) > 0 {
 		shouldSave = true
 	}
+	var kind = "loader"
+	if origin != nil {
+		kind = "storage"
+	}
+	app.emit(r.Context(), Event{Type: EventLoad, Kind: kind, Key: key,
+		Duration: time.Since(start), Size: blobSize(blob), Err: err})
 	return
 }
 
-func (app *Imagor) loadResult(r *http.Request, resultKey, imageKey string) *Blob {
-	ctx := r.Context()
-	blob, origin, err := app.load(r, app.ResultStorages, nil, resultKey)
+// loadResult loads result from result storages.
+// Returns stale result with isStale true if expired or modified time stale, and stale allowed by context.
+// Skipped if context of WithRegenerate
+func (app *Imagor) loadResult(r *http.Request, resultKey, imageKey string) (blob *Blob, origin Storage, isStale bool) {
+	if isRegenerate(r.Context()) {
+		return
+	}
+	ctx, span := app.startSpan(r.Context(), "imagor.loadResult",
+		attribute.String("imagor.key", resultKey))
+	defer span.End()
+	r = r.WithContext(ctx)
+	blob, origin, err := app.load(r, "result_storage", app.ResultStorages, nil, resultKey)
 	if err == nil && !isBlobEmpty(blob) {
 		if app.ModifiedTimeCheck && origin != nil {
 			if resStat, err1 := origin.Stat(ctx, resultKey); resStat != nil && err1 == nil {
 				if sourceStat, err2 := app.storageStat(ctx, imageKey); sourceStat != nil && err2 == nil {
 					if !resStat.ModifiedTime.Before(sourceStat.ModifiedTime) {
-						return blob
+						span.SetAttributes(attribute.Bool("imagor.hit", true))
+						if blob.Stat() == nil {
+							blob.SetStat(resStat)
+						}
+						return blob, origin, false
+					} else if IsStaleAllowed(ctx) {
+						span.SetAttributes(attribute.Bool("imagor.hit", false))
+						return blob, origin, true
 					}
 				}
 			}
 		} else {
-			return blob
+			span.SetAttributes(attribute.Bool("imagor.hit", true))
+			return blob, origin, false
 		}
+	} else if errors.Is(err, ErrExpired) && !isBlobEmpty(blob) && IsStaleAllowed(ctx) {
+		span.SetAttributes(attribute.Bool("imagor.hit", false))
+		return blob, nil, true
+	}
+	span.SetAttributes(attribute.Bool("imagor.hit", false))
+	return nil, nil, false
+}
+
+// promote writes result hit back into Promoter result storages in front of its origin in background,
+// e.g. Memory Result Storage in front of S3
+func (app *Imagor) promote(ctx context.Context, key string, blob *Blob, origin Storage) {
+	var promoters []int
+	for i, storage := range app.ResultStorages {
+		if reflect.TypeOf(storage).Comparable() && storage == origin {
+			for _, i := range promoters {
+				app.promoteTo(ctx, i, key, blob)
+			}
+			return
+		}
+		if _, ok := storage.(Promoter); ok {
+			promoters = append(promoters, i)
+		}
+	}
+}
+
+func (app *Imagor) promoteTo(ctx context.Context, i int, key string, blob *Blob) {
+	breaker := app.breaker("result_storage", i)
+	if !breaker.allow() {
+		return
+	}
+	promoter := app.ResultStorages[i].(Promoter)
+	if !app.background.goFunc(func() {
+		ctx := DetachContext(ctx)
+		if app.SaveTimeout > 0 {
+			var cancel func()
+			ctx, cancel = context.WithTimeout(ctx, app.SaveTimeout)
+			defer cancel()
+		}
+		err := promoter.Promote(ctx, key, blob)
+		breaker.done(err)
+		if err != nil {
+			app.Logger.Warn("promote", zap.String("key", key), zap.Error(err))
+		} else if app.Debug {
+			app.Logger.Debug("promoted", zap.String("key", key))
+		}
+	}) {
+		// shutting down, promotion skipped
+		breaker.done(context.Canceled)
 	}
-	return nil
 }
 
 func (app *Imagor) load(
-	r *http.Request, storages []Storage, loaders []Loader, key string,
+	r *http.Request, kind string, storages []Storage, loaders []Loader, key string,
 ) (blob *Blob, origin Storage, err error) {
 	if key == "" {
 		err = ErrNotFound
 		return
 	}
-	var ctx = r.Context()
+	ctx, span := app.startSpan(r.Context(), "imagor.load",
+		attribute.String("imagor.kind", kind), attribute.String("imagor.key", key))
+	defer func() {
+		if origin != nil {
+			span.SetAttributes(attribute.String("imagor.origin", getType(origin)))
+		}
+		if kind == "result_storage" && errors.Is(err, ErrNotFound) {
+			// result miss is expected
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
 	var cancel func()
 	if app.LoadTimeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, app.LoadTimeout)
@@ -388,27 +743,45 @@ func (app *Imagor) load(
 		r = r.WithContext(ctx)
 	}
 
-	for _, storage := range storages {
+	for i, storage := range storages {
+		breaker := app.breaker(kind, i)
+		if !breaker.allow() {
+			app.Metrics.ObserveLoad(kind, getType(storage), false)
+			err = ErrCircuitOpen
+			continue
+		}
 		b, e := checkBlob(storage.Get(r, key))
+		breaker.done(e)
 		if !isBlobEmpty(b) {
 			blob = b
 			if e == nil {
+				app.Metrics.ObserveLoad(kind, getType(storage), true)
 				err = nil
 				origin = storage
 				return
 			}
 		}
+		app.Metrics.ObserveLoad(kind, getType(storage), false)
 		err = e
 	}
-	for _, loader := range loaders {
+	for i, loader := range loaders {
+		breaker := app.breaker("loader", i)
+		if !breaker.allow() {
+			app.Metrics.ObserveLoad("loader", getType(loader), false)
+			err = ErrCircuitOpen
+			continue
+		}
 		b, e := checkBlob(loader.Get(r, key))
+		breaker.done(e)
 		if !isBlobEmpty(b) {
 			blob = b
 			if e == nil {
+				app.Metrics.ObserveLoad("loader", getType(loader), true)
 				err = nil
 				return
 			}
 		}
+		app.Metrics.ObserveLoad("loader", getType(loader), false)
 		err = e
 	}
 	if err == nil && isBlobEmpty(blob) {
@@ -426,50 +799,98 @@ func (app *Imagor) storageStat(ctx context.Context, key string) (stat *Stat, err
 	return
 }
 
-func (app *Imagor) save(ctx context.Context, storages []Storage, key string, blob *Blob) {
-	ctx = DetachContext(ctx)
+func (app *Imagor) save(ctx context.Context, kind string, key string, blob *Blob) (err error) {
+	ctx, span := app.startSpan(DetachContext(ctx), "imagor.save",
+		attribute.String("imagor.key", key))
+	defer func() {
+		endSpan(span, err)
+	}()
 	if app.SaveTimeout > 0 {
 		var cancel func()
 		ctx, cancel = context.WithTimeout(ctx, app.SaveTimeout)
 		defer cancel()
 	}
 	var wg sync.WaitGroup
-	for _, storage := range storages {
+	var l sync.Mutex
+	for i, storage := range app.storages(kind) {
+		breaker := app.breaker(kind, i)
+		if !breaker.allow() {
+			app.Logger.Warn("save", zap.String("key", key), zap.Error(ErrCircuitOpen))
+			if app.retry != nil {
+				app.retry.enqueue(kind, i, storage, key, blob)
+			}
+			l.Lock()
+			if err == nil {
+				err = ErrCircuitOpen
+			}
+			l.Unlock()
+			continue
+		}
 		wg.Add(1)
-		go func(storage Storage) {
+		go func(i int, storage Storage) {
 			defer wg.Done()
-			if err := storage.Put(ctx, key, blob); err != nil {
-				app.Logger.Warn("save", zap.String("key", key), zap.Error(err))
+			var start = time.Now()
+			e := storage.Put(ctx, key, blob)
+			breaker.done(e)
+			app.emit(ctx, saveEvent(kind, key, start, blob, e))
+			if e != nil {
+				app.Logger.Warn("save", zap.String("key", key), zap.Error(e))
+				if app.retry != nil {
+					app.retry.enqueue(kind, i, storage, key, blob)
+				}
+				l.Lock()
+				if err == nil {
+					err = e
+				}
+				l.Unlock()
 			} else if app.Debug {
 				app.Logger.Debug("saved", zap.String("key", key))
 			}
-		}(storage)
+		}(i, storage)
 	}
 	wg.Wait()
 	return
 }
 
-func (app *Imagor) del(ctx context.Context, storages []Storage, key string) {
-	ctx = DetachContext(ctx)
+// del deletes key from storages of the kind, returns error of each storage
+func (app *Imagor) del(ctx context.Context, kind string, key string) []error {
+	ctx, span := app.startSpan(DetachContext(ctx), "imagor.delete",
+		attribute.String("imagor.key", key))
+	defer span.End()
 	if app.SaveTimeout > 0 {
 		var cancel func()
 		ctx, cancel = context.WithTimeout(ctx, app.SaveTimeout)
 		defer cancel()
 	}
 	var wg sync.WaitGroup
-	for _, storage := range storages {
+	var storages = app.storages(kind)
+	var errs = make([]error, len(storages))
+	for i, storage := range storages {
+		if app.retry != nil {
+			// deleted image should not be saved by retry
+			app.retry.remove(kind, i, key)
+		}
+		breaker := app.breaker(kind, i)
+		if !breaker.allow() {
+			app.Logger.Warn("delete", zap.String("key", key), zap.Error(ErrCircuitOpen))
+			errs[i] = ErrCircuitOpen
+			continue
+		}
 		wg.Add(1)
-		go func(storage Storage) {
+		go func(i int, storage Storage) {
 			defer wg.Done()
-			if err := storage.Delete(ctx, key); err != nil {
+			err := storage.Delete(ctx, key)
+			breaker.done(err)
+			errs[i] = err
+			if err != nil {
 				app.Logger.Warn("delete", zap.String("key", key), zap.Error(err))
 			} else if app.Debug {
 				app.Logger.Debug("deleted", zap.String("key", key))
 			}
-		}(storage)
+		}(i, storage)
 	}
 	wg.Wait()
-	return
+	return errs
 }
 
 type suppressKey struct {
@@ -492,7 +913,9 @@ func (app *Imagor) suppress(
 		return fn(ctx, cb)
 	}
 	isCanceled := false
+	isExecuted := false
 	ch := app.g.DoChan(key, func() (v interface{}, err error) {
+		isExecuted = true
 		v, err = fn(context.WithValue(ctx, suppressKey{key}, true), cb)
 		if errors.Is(err, context.Canceled) {
 			app.g.Forget(key)
@@ -506,6 +929,9 @@ func (app *Imagor) suppress(
 			// resolve canceled
 			return app.suppress(ctx, key, fn)
 		}
+		if !isExecuted {
+			app.Metrics.ObserveSuppressed()
+		}
 		if res.Val != nil {
 			return res.Val.(*Blob), res.Err
 		}
@@ -517,6 +943,30 @@ func (app *Imagor) suppress(
 	}
 }
 
+// acquire acquires process slot from scheduler by priority class of the request
+func (app *Imagor) acquire(ctx context.Context, r *http.Request, p imagorpath.Params) (func(), error) {
+	class := app.scheduler.class(app.priorityClass(r, p)).Name
+	app.observeQueue(1, 0)
+	release, wait, err := app.scheduler.Acquire(ctx, class)
+	if err != nil {
+		app.observeQueue(-1, 0)
+		if app.Debug {
+			app.Logger.Debug("queue-acquire", zap.String("class", class), zap.Error(err))
+		}
+		return nil, err
+	}
+	app.Metrics.ObserveQueueWait(class, wait)
+	app.observeQueue(-1, 1)
+	return func() {
+		release()
+		app.observeQueue(0, -1)
+	}, nil
+}
+
+func (app *Imagor) observeQueue(waiting, processing int64) {
+	app.Metrics.ObserveQueue(waiting, processing)
+}
+
 func (app *Imagor) debugLog() {
 	if !app.Debug {
 		return
@@ -542,6 +992,7 @@ func (app *Imagor) debugLog() {
 		zap.Duration("process_timeout", app.ProcessTimeout),
 		zap.Duration("save_timeout", app.SaveTimeout),
 		zap.Int64("process_concurrency", app.ProcessConcurrency),
+		zap.Int64("process_memory_budget", app.ProcessMemoryBudget),
 		zap.Duration("cache_header_ttl", app.CacheHeaderTTL),
 		zap.Strings("loaders", loaders),
 		zap.Strings("storages", storages),
@@ -609,6 +1060,22 @@ func writeBody(w http.ResponseWriter, r *http.Request, reader io.ReadCloser, siz
 	}
 }
 
+func hasFilter(filters imagorpath.Filters, name string) bool {
+	for _, f := range filters {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func errorCode(err error) int {
+	if errors.Is(err, context.Canceled) {
+		return 499
+	}
+	return WrapError(err).Code
+}
+
 func getType(v interface{}) string {
 	if t := reflect.TypeOf(v); t.Kind() == reflect.Ptr {
 		return t.Elem().Name()
@@ -15,6 +15,7 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -173,6 +174,67 @@ func TestWithSigner(t *testing.T) {
 	assert.Equal(t, w.Body.String(), jsonStr(ErrSignatureMismatch))
 }
 
+func TestWithSignerExpires(t *testing.T) {
+	resultStore := newMapStore()
+	signer := imagorpath.NewDefaultSigner("1234")
+	app := New(
+		WithDebug(true),
+		WithLogger(zap.NewExample()),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobFromBytes([]byte("foo")), nil
+		})),
+		WithResultStorages(resultStore),
+		WithSigner(signer))
+
+	future := time.Now().Add(time.Hour).Truncate(time.Second)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+imagorpath.Generate(
+		imagorpath.Params{Width: 100, Image: "foo.jpg", Expires: &future}, signer), nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "foo", w.Body.String())
+	ttl, err := strconv.Atoi(strings.TrimSuffix(strings.Split(w.Header().Get("Cache-Control"), "max-age=")[1], ", no-transform"))
+	require.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 3600, "max-age capped by expiry")
+	assert.Contains(t, w.Header().Get("Cache-Control"), fmt.Sprintf("s-maxage=%d,", ttl))
+	assert.NotContains(t, w.Header().Get("Cache-Control"), "stale-if-error")
+	expires, err := http.ParseTime(w.Header().Get("Expires"))
+	require.NoError(t, err)
+	assert.False(t, expires.After(future), "expires capped by expiry")
+	assert.Eventually(t, func() bool {
+		resultStore.l.Lock()
+		defer resultStore.l.Unlock()
+		_, ok := resultStore.Map["100x0/foo.jpg"]
+		return ok
+	}, time.Second, time.Millisecond, "result key should exclude expiry")
+
+	// malformed expiry
+	path := "100x0/filters:expire(tomorrow)/foo.jpg"
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+signer.Sign(path)+"/"+path, nil))
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, jsonStr(ErrInvalid), w.Body.String())
+
+	past := time.Now().Add(-time.Second).Truncate(time.Second)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+imagorpath.Generate(
+		imagorpath.Params{Width: 100, Image: "foo.jpg", Expires: &past}, signer), nil))
+	assert.Equal(t, 410, w.Code)
+	assert.Equal(t, jsonStr(ErrExpired), w.Body.String())
+
+	// expiry tampered
+	uri := imagorpath.Generate(imagorpath.Params{Width: 100, Image: "foo.jpg", Expires: &past}, signer)
+	uri = strings.Replace(uri, strconv.FormatInt(past.Unix(), 10), strconv.FormatInt(future.Unix(), 10), 1)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/"+uri, nil))
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, jsonStr(ErrSignatureMismatch), w.Body.String())
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/params/unsafe/filters:expire(1659348000)/foo.jpg", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"expires": "2022-08-01T10:00:00Z"`)
+}
+
 func TestWithCustomSigner(t *testing.T) {
 	app := New(
 		WithDebug(true),
@@ -76,6 +76,12 @@ func GeneratePath(p Params) string {
 	if p.Smart {
 		parts = append(parts, "smart")
 	}
+	if p.Expires != nil && !hasFilter(p.Filters, FilterExpire) {
+		p.Filters = append(p.Filters[:len(p.Filters):len(p.Filters)], Filter{
+			Name: FilterExpire,
+			Args: strconv.FormatInt(p.Expires.Unix(), 10),
+		})
+	}
 	if len(p.Filters) > 0 {
 		var filters []string
 		for _, f := range p.Filters {
@@ -104,3 +110,12 @@ func Generate(p Params, signer Signer) string {
 		return "unsafe/" + imgPath
 	}
 }
+
+func hasFilter(filters Filters, name string) bool {
+	for _, f := range filters {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,7 @@
 package imagorpath
 
+import "time"
+
 const (
 	TrimByTopLeft     = "top-left"
 	TrimByBottomRight = "bottom-right"
@@ -7,6 +9,9 @@ const (
 	HAlignRight       = "right"
 	VAlignTop         = "top"
 	VAlignBottom      = "bottom"
+
+	// FilterExpire filter name of the URL expiry in unix timestamp
+	FilterExpire = "expire"
 )
 
 type Filters []Filter
@@ -40,6 +45,10 @@ type Params struct {
 	VAlign        string  `json:"v_align,omitempty"`
 	Smart         bool    `json:"smart,omitempty"`
 	Filters       Filters `json:"filters,omitempty"`
+
+	// Expires decoded from expire filter, which is covered by the URL signature.
+	// Generate appends expire filter if set
+	Expires *time.Time `json:"expires,omitempty"`
 }
 
 type Filter struct {
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseGenerate(t *testing.T) {
@@ -284,3 +285,27 @@ func TestHMACSigner(t *testing.T) {
 	signer := NewHMACSigner(sha256.New, 28, "abcd")
 	assert.Equal(t, signer.Sign("assfasf"), "zb6uWXQxwJDOe_zOgxkuj96Etrsz")
 }
+
+func TestExpires(t *testing.T) {
+	expires := time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC)
+	signer := NewDefaultSigner("1234")
+	uri := Generate(Params{
+		Width:   300,
+		Image:   "foo.jpg",
+		Filters: Filters{{Name: "grayscale"}},
+		Expires: &expires,
+	}, signer)
+	assert.Equal(t, signer.Sign("300x0/filters:grayscale():expire(1659348000)/foo.jpg")+
+		"/300x0/filters:grayscale():expire(1659348000)/foo.jpg", uri)
+
+	p := Parse(uri)
+	assert.Equal(t, &expires, p.Expires)
+	assert.Equal(t, signer.Sign(p.Path), p.Hash)
+	assert.Equal(t, Filters{{Name: "grayscale"}, {Name: "expire", Args: "1659348000"}}, p.Filters)
+	assert.Equal(t, uri, Generate(p, signer), "should not duplicate expire filter")
+
+	buf, _ := json.Marshal(p)
+	assert.Contains(t, string(buf), `"expires":"2022-08-01T10:00:00Z"`)
+
+	assert.Nil(t, Parse("unsafe/filters:expire(abc)/foo.jpg").Expires)
+}
@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var pathRegex = regexp.MustCompile(
@@ -138,6 +139,14 @@ func Apply(p Params, path string) Params {
 	index += 1
 	if match[index] != "" {
 		p.Filters = append(p.Filters, parseFilters(match[index+1])...)
+		for _, f := range p.Filters {
+			if f.Name == FilterExpire {
+				if ts, err := strconv.ParseInt(f.Args, 10, 64); err == nil {
+					expires := time.Unix(ts, 0).UTC()
+					p.Expires = &expires
+				}
+			}
+		}
 	}
 	index += 2
 	if str := match[index]; str != "" {
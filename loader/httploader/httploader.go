@@ -153,6 +153,8 @@ func (h *HTTPLoader) newRequest(r *http.Request, method, url string) (*http.Requ
 			req.Header.Set(header, r.Header.Get(header))
 		}
 	}
+	// propagate trace context of the current span
+	imagor.InjectTraceContext(r.Context(), req.Header)
 	for key, value := range h.OverrideHeaders {
 		req.Header.Set(key, value)
 	}
@@ -6,6 +6,8 @@ import (
 	"github.com/cshum/imagor"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
@@ -505,3 +507,42 @@ func TestWithGzip(t *testing.T) {
 		},
 	})
 }
+
+func TestTraceContext(t *testing.T) {
+	var traceparent string
+	exporter := tracetest.NewInMemoryExporter()
+	app := imagor.New(
+		imagor.WithUnsafe(true),
+		imagor.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))),
+		imagor.WithLoaders(New(
+			WithTransport(roundTripFunc(func(r *http.Request) (w *http.Response, err error) {
+				traceparent = r.Header.Get("traceparent")
+				res := &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     map[string][]string{},
+					Body:       ioutil.NopCloser(strings.NewReader("ok")),
+				}
+				res.Header.Set("Content-Type", "image/jpeg")
+				return res, nil
+			})),
+		)),
+	)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo.bar/baz", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	app.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+
+	require.NotEmpty(t, traceparent)
+	assert.True(t, strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+	assert.NotContains(t, traceparent, "00f067aa0ba902b7")
+	var parentSpan string
+	for _, span := range exporter.GetSpans() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+		if strings.Contains(traceparent, span.SpanContext.SpanID().String()) {
+			parentSpan = span.Name
+		}
+	}
+	assert.Equal(t, "imagor.load", parentSpan)
+}
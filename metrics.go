@@ -0,0 +1,61 @@
+package imagor
+
+import (
+	"context"
+	"time"
+)
+
+// Metrics Imagor pipeline metrics collector
+type Metrics interface {
+	// ObserveRequest records outcome and duration of an Imagor operation,
+	// outcome being either "result" for Result Storage hit, "processed", "stale" for stale result served on error, or "error"
+	ObserveRequest(outcome string, duration time.Duration)
+	// ObserveLoad records hit or miss of a Loader, Storage or Result Storage by type name,
+	// kind being either "loader", "storage" or "result_storage"
+	ObserveLoad(kind, name string, hit bool)
+	// ObserveProcess records latency of a Processor by type name
+	ObserveProcess(name string, duration time.Duration, err error)
+	// ObserveFilter records latency of an image filter within a Processor
+	ObserveFilter(name string, duration time.Duration, err error)
+	// ObserveSuppressed records a request suppressed by an identical in-flight request
+	ObserveSuppressed()
+	// ObserveQueue records changes of number of processes waiting in queue and in progress
+	ObserveQueue(waiting, processing int64)
+	// ObserveQueueWait records time waited in process queue by priority class
+	ObserveQueueWait(class string, duration time.Duration)
+	// ObserveError records Imagor error by status code
+	ObserveError(code int)
+	// ObserveSaveRetryQueue records changes of number of failed saves queued for retry
+	ObserveSaveRetryQueue(queued int64)
+	// ObserveSaveRetryFailure records save permanently failed after retries,
+	// or dropped by full retry queue, kind being either "storage" or "result_storage"
+	ObserveSaveRetryFailure(kind string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveRequest(string, time.Duration)        {}
+func (nopMetrics) ObserveLoad(string, string, bool)            {}
+func (nopMetrics) ObserveProcess(string, time.Duration, error) {}
+func (nopMetrics) ObserveFilter(string, time.Duration, error)  {}
+func (nopMetrics) ObserveSuppressed()                          {}
+func (nopMetrics) ObserveQueue(int64, int64)                   {}
+func (nopMetrics) ObserveQueueWait(string, time.Duration)      {}
+func (nopMetrics) ObserveError(int)                            {}
+func (nopMetrics) ObserveSaveRetryQueue(int64)                 {}
+func (nopMetrics) ObserveSaveRetryFailure(string)              {}
+
+var metricsCtxKey = &contextKey{"Metrics"}
+
+func withMetricsContext(ctx context.Context, metrics Metrics) context.Context {
+	return context.WithValue(ctx, metricsCtxKey, metrics)
+}
+
+// ContextMetrics returns Metrics of the Imagor operation from context,
+// which allows Processor to record metrics within its own scope
+func ContextMetrics(ctx context.Context) Metrics {
+	if metrics, ok := ctx.Value(metricsCtxKey).(Metrics); ok && metrics != nil {
+		return metrics
+	}
+	return nopMetrics{}
+}
@@ -0,0 +1,19 @@
+package prometheusmetrics
+
+type Option func(m *PrometheusMetrics)
+
+func WithPath(path string) Option {
+	return func(m *PrometheusMetrics) {
+		if path != "" {
+			m.Path = path
+		}
+	}
+}
+
+func WithNamespace(namespace string) Option {
+	return func(m *PrometheusMetrics) {
+		if namespace != "" {
+			m.Namespace = namespace
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package prometheusmetrics
+
+import (
+	"errors"
+	"github.com/cshum/imagor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PrometheusMetrics implements imagor.Metrics with Prometheus collectors,
+// served as http.Handler in Prometheus exposition format
+type PrometheusMetrics struct {
+	Path      string
+	Namespace string
+
+	registry        *prometheus.Registry
+	handler         http.Handler
+	requestDuration *prometheus.HistogramVec
+	loadTotal       *prometheus.CounterVec
+	processDuration *prometheus.HistogramVec
+	filterDuration  *prometheus.HistogramVec
+	suppressedTotal prometheus.Counter
+	queueWaiting    prometheus.Gauge
+	queueProcessing prometheus.Gauge
+	queueWait       *prometheus.HistogramVec
+	errorTotal      *prometheus.CounterVec
+	saveRetryQueue  prometheus.Gauge
+	saveRetryFailed *prometheus.CounterVec
+}
+
+// New create new PrometheusMetrics
+func New(options ...Option) *PrometheusMetrics {
+	m := &PrometheusMetrics{
+		Path:      "/metrics",
+		Namespace: "imagor",
+	}
+	for _, option := range options {
+		option(m)
+	}
+	m.requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.Namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Imagor operation duration by outcome: result, processed, stale or error",
+	}, []string{"outcome"})
+	m.loadTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.Namespace,
+		Name:      "load_total",
+		Help:      "Loader, Storage and Result Storage lookups by kind, type and result: hit or miss",
+	}, []string{"kind", "type", "result"})
+	m.processDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.Namespace,
+		Name:      "process_duration_seconds",
+		Help:      "Processor latency by type and status",
+	}, []string{"type", "status"})
+	m.filterDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.Namespace,
+		Name:      "filter_duration_seconds",
+		Help:      "Image filter latency by name and status",
+	}, []string{"filter", "status"})
+	m.suppressedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: m.Namespace,
+		Name:      "suppressed_total",
+		Help:      "Requests suppressed by identical in-flight requests",
+	})
+	m.queueWaiting = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: m.Namespace,
+		Name:      "queue_waiting",
+		Help:      "Number of processes waiting in queue",
+	})
+	m.queueProcessing = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: m.Namespace,
+		Name:      "queue_processing",
+		Help:      "Number of processes in progress",
+	})
+	m.queueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.Namespace,
+		Name:      "queue_wait_seconds",
+		Help:      "Time waited in process queue by priority class",
+	}, []string{"class"})
+	m.errorTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.Namespace,
+		Name:      "error_total",
+		Help:      "Imagor errors by status code",
+	}, []string{"code"})
+	m.saveRetryQueue = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: m.Namespace,
+		Name:      "save_retry_queue",
+		Help:      "Number of failed storage saves queued for retry",
+	})
+	m.saveRetryFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.Namespace,
+		Name:      "save_retry_failed_total",
+		Help:      "Storage saves permanently failed after retries or dropped by full retry queue, by kind",
+	}, []string{"kind"})
+
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requestDuration,
+		m.loadTotal,
+		m.processDuration,
+		m.filterDuration,
+		m.suppressedTotal,
+		m.queueWaiting,
+		m.queueProcessing,
+		m.queueWait,
+		m.errorTotal,
+		m.saveRetryQueue,
+		m.saveRetryFailed,
+	)
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// ServeHTTP implements http.Handler for Prometheus scraping
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
+
+func (m *PrometheusMetrics) ObserveRequest(outcome string, duration time.Duration) {
+	m.requestDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) ObserveLoad(kind, name string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.loadTotal.WithLabelValues(kind, name, result).Inc()
+}
+
+func (m *PrometheusMetrics) ObserveProcess(name string, duration time.Duration, err error) {
+	m.processDuration.WithLabelValues(name, status(err)).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) ObserveFilter(name string, duration time.Duration, err error) {
+	m.filterDuration.WithLabelValues(name, status(err)).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) ObserveSuppressed() {
+	m.suppressedTotal.Inc()
+}
+
+func (m *PrometheusMetrics) ObserveQueue(waiting, processing int64) {
+	m.queueWaiting.Add(float64(waiting))
+	m.queueProcessing.Add(float64(processing))
+}
+
+func (m *PrometheusMetrics) ObserveQueueWait(class string, duration time.Duration) {
+	m.queueWait.WithLabelValues(class).Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) ObserveError(code int) {
+	m.errorTotal.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+func (m *PrometheusMetrics) ObserveSaveRetryQueue(queued int64) {
+	m.saveRetryQueue.Add(float64(queued))
+}
+
+func (m *PrometheusMetrics) ObserveSaveRetryFailure(kind string) {
+	m.saveRetryFailed.WithLabelValues(kind).Inc()
+}
+
+func status(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if errors.Is(err, imagor.ErrPass) {
+		return "pass"
+	}
+	return "error"
+}
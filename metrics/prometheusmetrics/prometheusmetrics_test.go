@@ -0,0 +1,68 @@
+package prometheusmetrics
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type loaderFunc func(r *http.Request, image string) (blob *imagor.Blob, err error)
+
+func (f loaderFunc) Get(r *http.Request, image string) (*imagor.Blob, error) {
+	return f(r, image)
+}
+
+type processorFunc func(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error)
+
+func (f processorFunc) Process(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error) {
+	return f(ctx, blob, p, load)
+}
+func (f processorFunc) Startup(_ context.Context) error {
+	return nil
+}
+func (f processorFunc) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := New(WithNamespace("test"), WithPath("/foo"))
+	assert.Equal(t, "/foo", m.Path)
+	app := imagor.New(
+		imagor.WithUnsafe(true),
+		imagor.WithMetrics(m),
+		imagor.WithLoaders(loaderFunc(func(r *http.Request, image string) (*imagor.Blob, error) {
+			if image == "boom" {
+				return nil, imagor.ErrNotFound
+			}
+			return imagor.NewBlobFromBytes([]byte(image)), nil
+		})),
+		imagor.WithProcessors(processorFunc(func(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error) {
+			imagor.ContextMetrics(ctx).ObserveFilter("fill", time.Millisecond, nil)
+			return blob, nil
+		})),
+	)
+	for _, path := range []string{"/unsafe/foo", "/unsafe/boom"} {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil))
+	}
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/foo", nil))
+	assert.Equal(t, 200, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `test_load_total{kind="loader",result="hit",type="loaderFunc"} 1`)
+	assert.Contains(t, body, `test_load_total{kind="loader",result="miss",type="loaderFunc"} 1`)
+	assert.Contains(t, body, `test_request_duration_seconds_count{outcome="processed"} 1`)
+	assert.Contains(t, body, `test_request_duration_seconds_count{outcome="error"} 1`)
+	assert.Contains(t, body, `test_process_duration_seconds_count{status="ok",type="processorFunc"} 1`)
+	assert.Contains(t, body, `test_filter_duration_seconds_count{filter="fill",status="ok"} 1`)
+	assert.Contains(t, body, `test_error_total{code="404"} 1`)
+	assert.Contains(t, body, `test_queue_waiting 0`)
+	assert.Contains(t, body, `test_queue_processing 0`)
+	assert.Contains(t, body, `test_queue_wait_seconds_count{class="default"} 2`)
+	assert.Contains(t, body, `test_suppressed_total 0`)
+}
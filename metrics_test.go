@@ -0,0 +1,154 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	l          sync.Mutex
+	Requests   map[string]int
+	Loads      map[string]int
+	Processes  map[string]int
+	Filters    map[string]int
+	Errors     map[int]int
+	Suppressed int
+	Waiting    int64
+	Processing int64
+	QueueWaits map[string]int
+	Retrying   int64
+	Failures   map[string]int
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{
+		Requests: map[string]int{}, Loads: map[string]int{}, Processes: map[string]int{},
+		Filters: map[string]int{}, Errors: map[int]int{}, QueueWaits: map[string]int{},
+		Failures: map[string]int{},
+	}
+}
+
+func (m *testMetrics) ObserveRequest(outcome string, _ time.Duration) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.Requests[outcome]++
+}
+
+func (m *testMetrics) ObserveLoad(kind, name string, hit bool) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if hit {
+		m.Loads[kind+":"+name+":hit"]++
+	} else {
+		m.Loads[kind+":"+name+":miss"]++
+	}
+}
+
+func (m *testMetrics) ObserveProcess(name string, _ time.Duration, _ error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.Processes[name]++
+}
+
+func (m *testMetrics) ObserveFilter(name string, _ time.Duration, _ error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.Filters[name]++
+}
+
+func (m *testMetrics) ObserveSuppressed() {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.Suppressed++
+}
+
+func (m *testMetrics) ObserveQueue(waiting, processing int64) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.Waiting += waiting
+	m.Processing += processing
+}
+
+func (m *testMetrics) ObserveQueueWait(class string, _ time.Duration) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.QueueWaits[class]++
+}
+
+func (m *testMetrics) ObserveError(code int) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.Errors[code]++
+}
+
+func (m *testMetrics) ObserveSaveRetryQueue(queued int64) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.Retrying += queued
+}
+
+func (m *testMetrics) ObserveSaveRetryFailure(kind string) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.Failures[kind]++
+}
+
+func TestWithMetrics(t *testing.T) {
+	metrics := newTestMetrics()
+	resultStore := newMapStore()
+	app := New(
+		WithUnsafe(true),
+		WithMetrics(metrics),
+		WithResultStorages(resultStore),
+		WithProcessConcurrency(1),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			if image == "boom" {
+				return nil, ErrNotFound
+			}
+			time.Sleep(time.Millisecond * 10)
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			ContextMetrics(ctx).ObserveFilter("foo", time.Millisecond, nil)
+			return blob, nil
+		})),
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+			assert.Equal(t, 200, w.Code)
+		}()
+	}
+	wg.Wait()
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+	assert.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/boom", nil))
+	assert.Equal(t, 404, w.Code)
+
+	assert.Equal(t, 4, metrics.Suppressed)
+	assert.Equal(t, 1, metrics.Requests["processed"])
+	assert.Equal(t, 1, metrics.Requests["result"])
+	assert.Equal(t, 1, metrics.Requests["error"])
+	assert.Equal(t, 1, metrics.Loads["loader:loaderFunc:hit"])
+	assert.Equal(t, 1, metrics.Loads["loader:loaderFunc:miss"])
+	assert.Equal(t, 1, metrics.Loads["result_storage:mapStore:hit"])
+	assert.Equal(t, 2, metrics.Loads["result_storage:mapStore:miss"])
+	assert.Equal(t, 1, metrics.Processes["processorFunc"])
+	assert.Equal(t, 1, metrics.Filters["foo"])
+	assert.Equal(t, 1, metrics.Errors[404])
+	assert.Equal(t, int64(0), metrics.Waiting)
+	assert.Equal(t, int64(0), metrics.Processing)
+	assert.Equal(t, 2, metrics.QueueWaits["default"])
+}
@@ -2,7 +2,9 @@ package imagor
 
 import (
 	"github.com/cshum/imagor/imagorpath"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 )
 
@@ -64,6 +66,14 @@ func WithCacheHeaderSWR(swr time.Duration) Option {
 	}
 }
 
+func WithStaleIfError(staleIfError time.Duration) Option {
+	return func(app *Imagor) {
+		if staleIfError > 0 {
+			app.StaleIfError = staleIfError
+		}
+	}
+}
+
 func WithCacheHeaderNoCache(nocache bool) Option {
 	return func(app *Imagor) {
 		if nocache {
@@ -88,6 +98,50 @@ func WithSaveTimeout(timeout time.Duration) Option {
 	}
 }
 
+func WithSaveRetryAttempts(attempts int) Option {
+	return func(app *Imagor) {
+		if attempts > 0 {
+			app.SaveRetryAttempts = attempts
+		}
+	}
+}
+
+func WithSaveRetryBackoff(backoff time.Duration) Option {
+	return func(app *Imagor) {
+		if backoff > 0 {
+			app.SaveRetryBackoff = backoff
+		}
+	}
+}
+
+func WithSaveRetryQueueSize(size int) Option {
+	return func(app *Imagor) {
+		if size > 0 {
+			app.SaveRetryQueueSize = size
+		}
+	}
+}
+
+// WithSaveRetryMemorySize maximum total bytes of failed saves queued for retry in memory,
+// i.e. not spilled to journal
+func WithSaveRetryMemorySize(size int64) Option {
+	return func(app *Imagor) {
+		if size > 0 {
+			app.SaveRetryMemorySize = size
+		}
+	}
+}
+
+// WithSaveRetryJournal directory of journal that spills failed saves queued for retry,
+// such that they survive restarts
+func WithSaveRetryJournal(dir string) Option {
+	return func(app *Imagor) {
+		if dir != "" {
+			app.SaveRetryJournal = dir
+		}
+	}
+}
+
 func WithProcessTimeout(timeout time.Duration) Option {
 	return func(app *Imagor) {
 		if timeout > 0 {
@@ -104,6 +158,72 @@ func WithProcessConcurrency(concurrency int64) Option {
 	}
 }
 
+func WithProcessQueueTimeout(timeout time.Duration) Option {
+	return func(app *Imagor) {
+		if timeout > 0 {
+			app.ProcessQueueTimeout = timeout
+		}
+	}
+}
+
+func WithProcessMemoryBudget(budget int64) Option {
+	return func(app *Imagor) {
+		if budget > 0 {
+			app.ProcessMemoryBudget = budget
+		}
+	}
+}
+
+func WithCircuitBreakerThreshold(threshold int) Option {
+	return func(app *Imagor) {
+		if threshold > 0 {
+			app.CircuitBreakerThreshold = threshold
+		}
+	}
+}
+
+func WithCircuitBreakerCooldown(cooldown time.Duration) Option {
+	return func(app *Imagor) {
+		if cooldown > 0 {
+			app.CircuitBreakerCooldown = cooldown
+		}
+	}
+}
+
+func WithCircuitBreakerProbes(probes int) Option {
+	return func(app *Imagor) {
+		if probes > 0 {
+			app.CircuitBreakerProbes = probes
+		}
+	}
+}
+
+func WithStatusPath(path string) Option {
+	return func(app *Imagor) {
+		if path != "" {
+			app.StatusPath = "/" + strings.TrimPrefix(path, "/")
+		}
+	}
+}
+
+func WithStatusSecret(secret string) Option {
+	return func(app *Imagor) {
+		app.StatusSecret = secret
+	}
+}
+
+func WithPriorityClasses(classes ...PriorityClass) Option {
+	return func(app *Imagor) {
+		app.PriorityClasses = append(app.PriorityClasses, classes...)
+	}
+}
+
+func WithPriorityHeader(header string) Option {
+	return func(app *Imagor) {
+		app.PriorityHeader = header
+	}
+}
+
 func WithProcessQueueSize(size int64) Option {
 	return func(app *Imagor) {
 		if size > 0 {
@@ -142,6 +262,38 @@ func WithBaseParams(params string) Option {
 	}
 }
 
+// WithPresets with named presets of endpoint params by name,
+// e.g. "card" => "fit-in/300x200/filters:quality(80)"
+func WithPresets(presets map[string]string) Option {
+	return func(app *Imagor) {
+		for name, preset := range presets {
+			if app.Presets == nil {
+				app.Presets = map[string]string{}
+			}
+			app.Presets[name] = strings.Trim(strings.TrimSpace(preset), "/")
+		}
+	}
+}
+
+// WithPresetsOnly restricts unsafe requests to presets only
+func WithPresetsOnly(enabled bool) Option {
+	return func(app *Imagor) {
+		app.PresetsOnly = enabled
+	}
+}
+
+func WithAcceptBuckets(enabled bool) Option {
+	return func(app *Imagor) {
+		app.AcceptBuckets = enabled
+	}
+}
+
+func WithClientHints(enabled bool) Option {
+	return func(app *Imagor) {
+		app.ClientHints = enabled
+	}
+}
+
 func WithModifiedTimeCheck(enabled bool) Option {
 	return func(app *Imagor) {
 		app.ModifiedTimeCheck = enabled
@@ -179,3 +331,65 @@ func WithSigner(signer imagorpath.Signer) Option {
 		}
 	}
 }
+
+func WithEnableUpload(enabled bool) Option {
+	return func(app *Imagor) {
+		app.EnableUpload = enabled
+	}
+}
+
+func WithUploadSecret(secret string) Option {
+	return func(app *Imagor) {
+		app.UploadSecret = secret
+	}
+}
+
+func WithUploadMaxSize(size int64) Option {
+	return func(app *Imagor) {
+		if size > 0 {
+			app.UploadMaxSize = size
+		}
+	}
+}
+
+func WithEnableBatch(enabled bool) Option {
+	return func(app *Imagor) {
+		app.EnableBatch = enabled
+	}
+}
+
+func WithMetrics(metrics Metrics) Option {
+	return func(app *Imagor) {
+		if metrics != nil {
+			app.Metrics = metrics
+		}
+	}
+}
+
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(app *Imagor) {
+		if provider != nil {
+			app.TracerProvider = provider
+		}
+	}
+}
+
+func WithPurgeSecret(secret string) Option {
+	return func(app *Imagor) {
+		app.PurgeSecret = secret
+	}
+}
+
+// WithHook adds hooks called upon lifecycle events of the event type,
+// e.g. WithHook(EventProcessed, AsyncHook(fn))
+func WithHook(t EventType, hooks ...Hook) Option {
+	return func(app *Imagor) {
+		if list := app.Hooks.list(t); list != nil {
+			for _, hook := range hooks {
+				if hook != nil {
+					*list = append(*list, hook)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package imagor
+
+import (
+	"github.com/cshum/imagor/imagorpath"
+	"net/http"
+	"strings"
+)
+
+// presetPrefix path prefix of preset endpoint, e.g. /p/card/image.jpg
+const presetPrefix = "p/"
+
+// presetSegment path segment of preset, e.g. /unsafe/preset:card/image.jpg
+const presetSegment = "preset:"
+
+// presetsPath params endpoint path listing presets
+const presetsPath = "presets"
+
+// splitPreset splits image of params into preset name and remaining path,
+// returns false if params not using preset
+func (app *Imagor) splitPreset(p imagorpath.Params) (name, rest string, ok bool) {
+	if len(app.Presets) == 0 || p.Path != p.Image {
+		// preset must lead the path
+		return
+	}
+	if strings.HasPrefix(p.Image, presetSegment) {
+		name, rest, _ = strings.Cut(strings.TrimPrefix(p.Image, presetSegment), "/")
+		return name, rest, true
+	}
+	if strings.HasPrefix(p.Image, presetPrefix) {
+		name, rest, _ = strings.Cut(strings.TrimPrefix(p.Image, presetPrefix), "/")
+		if _, exists := app.Presets[name]; exists {
+			return name, rest, true
+		}
+	}
+	return
+}
+
+// isPresetPath returns true if path is of preset endpoint without hash, e.g. p/card/image.jpg
+func (app *Imagor) isPresetPath(p imagorpath.Params) bool {
+	if p.Unsafe || p.Hash != "" || !strings.HasPrefix(p.Path, presetPrefix) {
+		return false
+	}
+	_, _, ok := app.splitPreset(p)
+	return ok
+}
+
+// applyPreset expands named preset of params into the preset params,
+// with remaining path applied on top, e.g. preset:card/filters:blur(2)/image.jpg.
+// Unsigned params are restricted to presets only if PresetsOnly enabled
+func (app *Imagor) applyPreset(p imagorpath.Params) (imagorpath.Params, error) {
+	name, rest, ok := app.splitPreset(p)
+	if !ok {
+		if app.PresetsOnly && p.Unsafe {
+			return p, ErrSignatureMismatch
+		}
+		return p, nil
+	}
+	preset, exists := app.Presets[name]
+	if !exists || rest == "" {
+		return p, ErrInvalid
+	}
+	if app.PresetsOnly && p.Unsafe {
+		if r := parsePreset(rest); r.Path != r.Image {
+			// transformations other than preset not allowed
+			return p, ErrSignatureMismatch
+		}
+	}
+	// unsafe prefix so that path segment not being taken as hash
+	np := imagorpath.Apply(parsePreset(preset+"/"), "unsafe/"+rest)
+	np.Unsafe = p.Unsafe
+	np.Hash = p.Hash
+	np.Path = imagorpath.GeneratePath(np)
+	return np, nil
+}
+
+// parsePreset parses params of path without hash,
+// trailing slash to parse preset params without image
+func parsePreset(path string) imagorpath.Params {
+	p := imagorpath.Parse("unsafe/" + path)
+	p.Unsafe = false
+	return p
+}
+
+// presetParams returns params of presets by name
+func (app *Imagor) presetParams() map[string]imagorpath.Params {
+	var params = map[string]imagorpath.Params{}
+	for name, preset := range app.Presets {
+		p := parsePreset(preset + "/")
+		p.Path = preset
+		params[name] = p
+	}
+	return params
+}
+
+// writePresetParams writes params endpoint of presets, returns false if not applicable
+func (app *Imagor) writePresetParams(w http.ResponseWriter, r *http.Request, p imagorpath.Params) bool {
+	if len(app.Presets) == 0 {
+		return false
+	}
+	if p.Path == presetsPath && p.Image == presetsPath {
+		writeJSONIndent(w, r, app.presetParams())
+		return true
+	}
+	if np, err := app.applyPreset(p); err == nil && np.Path != p.Path {
+		writeJSONIndent(w, r, np)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package imagor
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWithPresets(t *testing.T) {
+	withPresets := WithPresets(map[string]string{
+		"card":  "/fit-in/300x200/filters:quality(80)/",
+		"thumb": "100x100",
+	})
+	loader := WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+		return NewBlobFromBytes([]byte(image)), nil
+	}))
+	processor := WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+		return NewBlobFromBytes([]byte(p.Path)), nil
+	}))
+	signer := imagorpath.NewDefaultSigner("1234")
+	withSigner := WithSigner(signer)
+	serve := func(app *Imagor, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(method, "https://example.com"+path, strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("signed", func(t *testing.T) {
+		app := New(withSigner, withPresets, loader, processor)
+		assert.Equal(t, "fit-in/300x200/filters:quality(80)", app.Presets["card"])
+
+		w := serve(app, http.MethodGet, "/p/card/foo.jpg", "")
+		assert.Equal(t, ErrSignatureMismatch.Code, w.Code)
+
+		w = serve(app, http.MethodGet, "/"+signer.Sign("p/card/foo.jpg")+"/p/card/foo.jpg", "")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "fit-in/300x200/filters:quality(80)/foo.jpg", w.Body.String())
+
+		w = serve(app, http.MethodGet, "/"+signer.Sign("preset:thumb/filters:blur(2)/foo.jpg")+"/preset:thumb/filters:blur(2)/foo.jpg", "")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "100x100/filters:blur(2)/foo.jpg", w.Body.String())
+
+		w = serve(app, http.MethodGet, "/"+signer.Sign("fit-in/p/card/foo.jpg")+"/fit-in/p/card/foo.jpg", "")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "fit-in/p/card/foo.jpg", w.Body.String(), "preset must lead the path")
+	})
+
+	t.Run("unsafe", func(t *testing.T) {
+		app := New(WithUnsafe(true), withSigner, withPresets, loader, processor)
+		for path, expected := range map[string]string{
+			"/p/card/foo.jpg":                               "fit-in/300x200/filters:quality(80)/foo.jpg",
+			"/p/card/abcdefghijk/foo.jpg":                   "fit-in/300x200/filters:quality(80)/abcdefghijk/foo.jpg",
+			"/unsafe/p/thumb/foo.jpg":                       "100x100/foo.jpg",
+			"/unsafe/preset:thumb/200x0/foo.jpg":            "200x0/foo.jpg",
+			"/unsafe/preset:card/filters:fill(red)/foo.jpg": "fit-in/300x200/filters:quality(80):fill(red)/foo.jpg",
+			"/unsafe/p/notfound/foo.jpg":                    "p/notfound/foo.jpg",
+			"/unsafe/100x100/foo.jpg":                       "100x100/foo.jpg",
+		} {
+			w := serve(app, http.MethodGet, path, "")
+			assert.Equal(t, 200, w.Code, path)
+			assert.Equal(t, expected, w.Body.String(), path)
+		}
+		w := serve(app, http.MethodGet, "/unsafe/preset:notfound/foo.jpg", "")
+		assert.Equal(t, ErrInvalid.Code, w.Code)
+		w = serve(app, http.MethodGet, "/unsafe/preset:card", "")
+		assert.Equal(t, ErrInvalid.Code, w.Code)
+	})
+
+	t.Run("presets only", func(t *testing.T) {
+		app := New(WithUnsafe(true), WithPresetsOnly(true), withSigner, withPresets, loader, processor)
+		for _, path := range []string{"/p/card/foo.jpg", "/unsafe/preset:thumb/foo.jpg"} {
+			w := serve(app, http.MethodGet, path, "")
+			assert.Equal(t, 200, w.Code, path)
+		}
+		for _, path := range []string{
+			"/unsafe/foo.jpg",
+			"/unsafe/100x100/foo.jpg",
+			"/unsafe/preset:thumb/200x0/foo.jpg",
+			"/p/card/filters:blur(2)/foo.jpg",
+		} {
+			w := serve(app, http.MethodGet, path, "")
+			assert.Equal(t, ErrSignatureMismatch.Code, w.Code, path)
+		}
+		w := serve(app, http.MethodGet, "/"+signer.Sign("100x100/foo.jpg")+"/100x100/foo.jpg", "")
+		assert.Equal(t, 200, w.Code, "signed request not restricted")
+		assert.Equal(t, "100x100/foo.jpg", w.Body.String())
+	})
+
+	t.Run("params endpoint", func(t *testing.T) {
+		app := New(WithUnsafe(true), withSigner, withPresets, loader, processor)
+		w := serve(app, http.MethodGet, "/params/presets", "")
+		assert.Equal(t, 200, w.Code)
+		var presets map[string]imagorpath.Params
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &presets))
+		assert.Equal(t, map[string]imagorpath.Params{
+			"card": {
+				Path: "fit-in/300x200/filters:quality(80)", FitIn: true, Width: 300, Height: 200,
+				Filters: imagorpath.Filters{{Name: "quality", Args: "80"}},
+			},
+			"thumb": {Path: "100x100", Width: 100, Height: 100},
+		}, presets)
+
+		w = serve(app, http.MethodGet, "/params/unsafe/p/card/foo.jpg", "")
+		assert.Equal(t, 200, w.Code)
+		var p imagorpath.Params
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		assert.Equal(t, "fit-in/300x200/filters:quality(80)/foo.jpg", p.Path)
+		assert.Equal(t, "foo.jpg", p.Image)
+		assert.True(t, p.FitIn)
+		assert.Equal(t, 300, p.Width)
+
+		w = serve(New(WithDisableParamsEndpoint(true), withSigner, withPresets, loader, processor), http.MethodGet, "/params/presets", "")
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		app := New(WithUnsafe(true), WithEnableBatch(true), withSigner, withPresets, loader, processor)
+		w := serve(app, http.MethodPost, "/batch", `["p/card/foo.jpg","unsafe/preset:thumb/foo.jpg","unsafe/200x0/foo.jpg","p/card/bar.jpg"]`)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, jsonStr(BatchResult{
+			Image: "foo.jpg",
+			Variants: []BatchVariant{
+				{Path: "p/card/foo.jpg", Key: "fit-in/300x200/filters:quality(80)/foo.jpg", Size: 42, Format: "text/plain; charset=utf-8"},
+				{Path: "preset:thumb/foo.jpg", Key: "100x100/foo.jpg", Size: 15, Format: "text/plain; charset=utf-8"},
+				{Path: "200x0/foo.jpg", Key: "200x0/foo.jpg", Size: 13, Format: "text/plain; charset=utf-8"},
+				{Path: "p/card/bar.jpg", Error: batchError(ErrImageMismatch)},
+			},
+		}), w.Body.String())
+	})
+	t.Run("upload", func(t *testing.T) {
+		store := newMapStore()
+		app := New(WithUnsafe(true), WithPresetsOnly(true), WithEnableUpload(true), WithStorages(store),
+			withSigner, withPresets, loader, processor)
+		buf, err := os.ReadFile("testdata/gopher.png")
+		require.NoError(t, err)
+		for _, path := range []string{
+			"/unsafe/5000x5000/filters:blur(50)/foo.jpg",
+			"/p/card/filters:blur(2)/foo.jpg",
+		} {
+			w := serve(app, http.MethodPost, path, string(buf))
+			assert.Equal(t, ErrSignatureMismatch.Code, w.Code, path)
+		}
+		assert.Empty(t, store.Map, "rejected upload not saved")
+
+		w := serve(app, http.MethodPost, "/p/card/foo.jpg", string(buf))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "fit-in/300x200/filters:quality(80)/foo.jpg", w.Body.String())
+		assert.Contains(t, store.Map, "foo.jpg")
+
+		w = serve(app, http.MethodPost, "/unsafe/bar.jpg", string(buf))
+		assert.Equal(t, 200, w.Code, "upload without processing not restricted")
+		assert.Contains(t, store.Map, "bar.jpg")
+	})
+}
@@ -0,0 +1,150 @@
+package imagor
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/cshum/imagor/imagorpath"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ResultKeyPrefixer ResultKey that lays out result keys under a key prefix per source image,
+// such that results derived from an image can be enumerated by prefix
+type ResultKeyPrefixer interface {
+	ResultKey
+	Prefix(image string) string
+}
+
+// PrefixDeleter optional Storage interface that deletes all keys under prefix
+type PrefixDeleter interface {
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// SourcePrefixResultKey ResultKeyPrefixer that lays out result keys
+// as sha1(image)/sha1(path), grouping results by source image
+type SourcePrefixResultKey struct{}
+
+func (SourcePrefixResultKey) Prefix(image string) string {
+	return fmt.Sprintf("%x/", sha1.Sum([]byte(image)))
+}
+
+func (k SourcePrefixResultKey) Generate(p imagorpath.Params) string {
+	return k.Prefix(p.Image) + fmt.Sprintf("%x", sha1.Sum([]byte(p.Path)))
+}
+
+// PurgeResult result of purging an image
+type PurgeResult struct {
+	Image          string        `json:"image"`
+	Storages       []PurgeStatus `json:"storages"`
+	ResultStorages []PurgeStatus `json:"result_storages"`
+}
+
+// PurgeStatus purge status per storage
+type PurgeStatus struct {
+	Type   string `json:"type"`
+	Purged bool   `json:"purged"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Purge deletes source image from Storages and all results derived from it in ResultStorages.
+// Results are enumerated by key prefix, which requires ResultKey being ResultKeyPrefixer
+// and result storage being PrefixDeleter
+func (app *Imagor) Purge(r *http.Request, image string) (blob *Blob, err error) {
+	ctx, span := app.startRequestSpan(r, "imagor.Purge", attribute.String("imagor.image", image))
+	defer func() {
+		endSpan(span, err)
+	}()
+	ctx = DeferContext(withMetricsContext(ctx, app.Metrics))
+	var cancel func()
+	if app.RequestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, app.RequestTimeout)
+		Defer(ctx, cancel)
+	}
+	if app.PurgeSecret == "" {
+		err = ErrMethodNotAllowed
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(app.PurgeSecret)) != 1 {
+		err = ErrUnauthorized
+		return
+	}
+	if u, e := url.PathUnescape(image); e == nil {
+		image = u
+	}
+	if image == "" {
+		err = ErrInvalid
+		return
+	}
+	var result = PurgeResult{Image: image}
+	var l sync.Mutex
+	var wg sync.WaitGroup
+	var done = func(status *PurgeStatus, e error) {
+		l.Lock()
+		defer l.Unlock()
+		if e != nil && !isNotExist(e) {
+			app.Logger.Warn("purge", zap.String("image", image), zap.Error(e))
+			status.Error = e.Error()
+			if err == nil {
+				err = e
+			}
+			return
+		}
+		status.Purged = true
+	}
+	result.Storages = make([]PurgeStatus, len(app.Storages))
+	for i, storage := range app.Storages {
+		result.Storages[i].Type = getType(storage)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, e := range app.del(ctx, "storage", image) {
+			done(&result.Storages[i], e)
+		}
+	}()
+	prefixer, isPrefixer := app.ResultKey.(ResultKeyPrefixer)
+	result.ResultStorages = make([]PurgeStatus, len(app.ResultStorages))
+	for i, storage := range app.ResultStorages {
+		result.ResultStorages[i].Type = getType(storage)
+		deleter, isDeleter := storage.(PrefixDeleter)
+		if !isPrefixer || !isDeleter {
+			result.ResultStorages[i].Error = "purge by prefix not supported"
+			continue
+		}
+		if app.retry != nil {
+			// queued retries of the results purged not saved back
+			app.retry.removePrefix("result_storage", i, prefixer.Prefix(image))
+		}
+		breaker := app.breaker("result_storage", i)
+		if !breaker.allow() {
+			done(&result.ResultStorages[i], ErrCircuitOpen)
+			continue
+		}
+		wg.Add(1)
+		go func(status *PurgeStatus) {
+			defer wg.Done()
+			e := deleter.DeletePrefix(ctx, prefixer.Prefix(image))
+			breaker.done(e)
+			done(status, e)
+		}(&result.ResultStorages[i])
+	}
+	wg.Wait()
+	if app.Debug {
+		app.Logger.Debug("purged", zap.Any("result", result))
+	}
+	blob = NewBlobFromJsonMarshal(result)
+	return
+}
+
+func isNotExist(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, os.ErrNotExist)
+}
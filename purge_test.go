@@ -0,0 +1,137 @@
+package imagor
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type prefixMapStore struct {
+	*mapStore
+}
+
+func (s prefixMapStore) DeletePrefix(_ context.Context, prefix string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for key := range s.Map {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.Map, key)
+			delete(s.ModTime, key)
+		}
+	}
+	return nil
+}
+
+func TestSourcePrefixResultKey(t *testing.T) {
+	var k SourcePrefixResultKey
+	p1 := imagorpath.Parse("/fit-in/100x100/foo.jpg")
+	p2 := imagorpath.Parse("/200x0/foo.jpg")
+	p3 := imagorpath.Parse("/200x0/foo.jpg/bar.jpg")
+	assert.True(t, strings.HasPrefix(k.Generate(p1), k.Prefix("foo.jpg")))
+	assert.True(t, strings.HasPrefix(k.Generate(p2), k.Prefix("foo.jpg")))
+	assert.NotEqual(t, k.Generate(p1), k.Generate(p2))
+	assert.False(t, strings.HasPrefix(k.Generate(p3), k.Prefix("foo.jpg")))
+}
+
+func TestPurge(t *testing.T) {
+	store := newMapStore()
+	resultStore := prefixMapStore{newMapStore()}
+	otherResultStore := newMapStore()
+	purge := func(app *Imagor, path, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodDelete, "https://example.com"+path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		w := purge(New(WithUnsafe(true), WithStorages(store)), "/foo.jpg", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	app := New(
+		WithUnsafe(true),
+		WithPurgeSecret("s3cret"),
+		WithStorages(store),
+		WithResultStorages(resultStore, otherResultStore),
+		WithResultKey(SourcePrefixResultKey{}),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			return NewBlobFromBytes([]byte(p.Path)), nil
+		})),
+	)
+	for _, path := range []string{"/unsafe/fit-in/100x100/foo.jpg", "/unsafe/200x0/foo.jpg", "/unsafe/200x0/bar.jpg"} {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil))
+		require.Equal(t, 200, w.Code)
+	}
+	require.Eventually(t, func() bool {
+		store.l.Lock()
+		resultStore.l.Lock()
+		defer store.l.Unlock()
+		defer resultStore.l.Unlock()
+		return len(store.Map) == 2 && len(resultStore.Map) == 3
+	}, time.Second, time.Millisecond)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		w := purge(app, "/foo.jpg", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		w = purge(app, "/foo.jpg", "s3cre")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, 3, len(resultStore.Map))
+	})
+
+	t.Run("purged", func(t *testing.T) {
+		w := purge(app, "/foo.jpg", "s3cret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var res PurgeResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, PurgeResult{
+			Image:    "foo.jpg",
+			Storages: []PurgeStatus{{Type: "mapStore", Purged: true}},
+			ResultStorages: []PurgeStatus{
+				{Type: "prefixMapStore", Purged: true},
+				{Type: "mapStore", Error: "purge by prefix not supported"},
+			},
+		}, res)
+
+		_, ok := store.Map["foo.jpg"]
+		assert.False(t, ok)
+		_, ok = store.Map["bar.jpg"]
+		assert.True(t, ok)
+		assert.Equal(t, 1, len(resultStore.Map))
+		_, ok = resultStore.Map[SourcePrefixResultKey{}.Generate(imagorpath.Parse("200x0/bar.jpg"))]
+		assert.True(t, ok)
+	})
+}
+
+func TestPurgePathUnescape(t *testing.T) {
+	store := newMapStore()
+	store.Map["a+b.jpg"] = NewBlobFromBytes([]byte("a+b"))
+	store.Map["a b.jpg"] = NewBlobFromBytes([]byte("a b"))
+	store.Map["c d.jpg"] = NewBlobFromBytes([]byte("c d"))
+	app := New(WithStorages(store), WithPurgeSecret("s3cret"))
+	for _, path := range []string{"/a+b.jpg", "/c%20d.jpg"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodDelete, "https://example.com"+path, nil)
+		r.Header.Set("Authorization", "Bearer s3cret")
+		app.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.NotContains(t, store.Map, "a+b.jpg")
+	assert.Contains(t, store.Map, "a b.jpg", "plus sign is not space in path")
+	assert.NotContains(t, store.Map, "c d.jpg")
+}
@@ -0,0 +1,119 @@
+package imagor
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkIfRange checks If-Range request header against ETag and modified time of the blob.
+// Returns true if Range request header should be honored
+func checkIfRange(r *http.Request, blob *Blob) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		// strong comparison, weak ETag never matches
+		return blob.etag != "" && !strings.HasPrefix(ir, "W/") && ir == blob.etag
+	}
+	if blob.modTime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && t.Equal(blob.modTime.UTC().Truncate(time.Second))
+}
+
+// parseRange parses single byte range of Range header against size,
+// returns ok false if header is invalid or not a single byte range
+func parseRange(header string, size int64) (start, end int64, ok, satisfiable bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, prefix))
+	if spec == "" || strings.Contains(spec, ",") {
+		// multiple ranges not supported, serve full content
+		return
+	}
+	idx := strings.Index(spec, "-")
+	if idx < 0 {
+		return
+	}
+	first, last := strings.TrimSpace(spec[:idx]), strings.TrimSpace(spec[idx+1:])
+	var err error
+	if first == "" {
+		// suffix range bytes=-N
+		var n int64
+		if n, err = strconv.ParseInt(last, 10, 64); err != nil || n < 0 {
+			return
+		}
+		ok = true
+		if n == 0 || size == 0 {
+			return
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, true
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+		return 0, 0, false, false
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	ok = true
+	satisfiable = start < size
+	return
+}
+
+// writeRange writes 206 Partial Content of the blob if Range request header applicable.
+// Returns false if range not applicable, such that full content should be written
+func writeRange(w http.ResponseWriter, r *http.Request, blob *Blob) bool {
+	header := r.Header.Get("Range")
+	if header == "" || !checkIfRange(r, blob) {
+		return false
+	}
+	if _, _, ok, _ := parseRange(header, math.MaxInt64); !ok {
+		// check before reading blob
+		return false
+	}
+	rs, size, err := blob.NewReadSeeker()
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = rs.Close()
+	}()
+	start, end, ok, satisfiable := parseRange(header, size)
+	if !ok {
+		return false
+	}
+	if !satisfiable {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	if _, err = rs.Seek(start, io.SeekStart); err != nil {
+		return false
+	}
+	length := end - start + 1
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method != http.MethodHead {
+		_, _ = io.CopyN(w, rs, length)
+	}
+	return true
+}
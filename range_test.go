@@ -0,0 +1,134 @@
+package imagor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header      string
+		start, end  int64
+		ok          bool
+		satisfiable bool
+	}{
+		{"bytes=0-9", 0, 9, true, true},
+		{"bytes=10-", 10, 99, true, true},
+		{"bytes=90-200", 90, 99, true, true},
+		{"bytes=-5", 95, 99, true, true},
+		{"bytes=-500", 0, 99, true, true},
+		{"bytes=100-", 0, 0, true, false},
+		{"bytes=-0", 0, 0, true, false},
+		{"bytes=5-1", 0, 0, false, false},
+		{"bytes=0-1,5-6", 0, 0, false, false},
+		{"bytes=a-b", 0, 0, false, false},
+		{"items=0-1", 0, 0, false, false},
+		{"bytes=", 0, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			start, end, ok, satisfiable := parseRange(tt.header, 100)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.satisfiable, satisfiable)
+			if satisfiable {
+				assert.Equal(t, tt.start, start)
+				assert.Equal(t, tt.end, end)
+			}
+		})
+	}
+}
+
+func TestRangeRequest(t *testing.T) {
+	file, err := os.ReadFile("testdata/demo1.jpg")
+	assert.NoError(t, err)
+	size := strconv.Itoa(len(file))
+	store := newMapStore()
+	store.Map["file"] = NewBlobFromFile("testdata/demo1.jpg")
+	store.ModTime["file"] = time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC)
+	app := New(
+		WithUnsafe(true),
+		WithStorages(store),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			if image == "file" {
+				return NewBlobFromFile("testdata/demo1.jpg"), nil
+			}
+			return NewBlobFromBytes([]byte("0123456789")), nil
+		})),
+	)
+	serve := func(method, path string, header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "https://example.com"+path, nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("file seek", func(t *testing.T) {
+		w := serve(http.MethodGet, "/unsafe/file", map[string]string{"Range": "bytes=100-199"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "bytes 100-199/"+size, w.Header().Get("Content-Range"))
+		assert.Equal(t, "100", w.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+		assert.Equal(t, file[100:200], w.Body.Bytes())
+
+		w = serve(http.MethodGet, "/unsafe/file", map[string]string{"Range": "bytes=-10"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, file[len(file)-10:], w.Body.Bytes())
+
+		w = serve(http.MethodHead, "/unsafe/file", map[string]string{"Range": "bytes=0-9"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "10", w.Header().Get("Content-Length"))
+		assert.Empty(t, w.Body.Bytes())
+	})
+
+	t.Run("buffered", func(t *testing.T) {
+		w := serve(http.MethodGet, "/unsafe/bytes", map[string]string{"Range": "bytes=2-4"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "bytes 2-4/10", w.Header().Get("Content-Range"))
+		assert.Equal(t, "234", w.Body.String())
+
+		w = serve(http.MethodGet, "/unsafe/bytes", map[string]string{"Range": "bytes=20-"})
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+		assert.Equal(t, "bytes */10", w.Header().Get("Content-Range"))
+		assert.Empty(t, w.Body.String())
+
+		w = serve(http.MethodGet, "/unsafe/bytes", map[string]string{"Range": "bytes=0-1,3-4"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "0123456789", w.Body.String())
+
+		w = serve(http.MethodGet, "/unsafe/bytes", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "0123456789", w.Body.String())
+	})
+
+	t.Run("if range", func(t *testing.T) {
+		w := serve(http.MethodGet, "/unsafe/file", nil)
+		etag := w.Header().Get("ETag")
+		lastModified := w.Header().Get("Last-Modified")
+		assert.NotEmpty(t, etag)
+		assert.NotEmpty(t, lastModified)
+
+		w = serve(http.MethodGet, "/unsafe/file", map[string]string{"Range": "bytes=0-9", "If-Range": etag})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, file[:10], w.Body.Bytes())
+
+		w = serve(http.MethodGet, "/unsafe/file", map[string]string{"Range": "bytes=0-9", "If-Range": lastModified})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+
+		w = serve(http.MethodGet, "/unsafe/file", map[string]string{"Range": "bytes=0-9", "If-Range": `"foo"`})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, file, w.Body.Bytes())
+
+		w = serve(http.MethodGet, "/unsafe/file", map[string]string{"Range": "bytes=0-9", "If-Range": "W/" + etag})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
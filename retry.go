@@ -0,0 +1,595 @@
+package imagor
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSaveRetryBackoff maximum backoff between save retry attempts
+const maxSaveRetryBackoff = time.Minute * 10
+
+// retryItem failed storage save queued for retry.
+// Storages types of the storages of the kind identifies the storages queued for
+type retryItem struct {
+	Kind     string    `json:"kind"`
+	Index    int       `json:"index"`
+	Type     string    `json:"type"`
+	Storages string    `json:"storages"`
+	Key      string    `json:"key"`
+	Attempts int       `json:"attempts"`
+	NextAt   time.Time `json:"next_at"`
+
+	id  string
+	buf []byte
+}
+
+// retryQueue bounded queue retrying failed storage saves with exponential backoff.
+// Queued saves are spilled to journal directory if configured, such that they survive restarts
+type retryQueue struct {
+	app    *Imagor
+	mu     sync.Mutex
+	items  map[string]*retryItem
+	size   int64
+	dir    string
+	wake   chan struct{}
+	cancel func()
+	done   chan struct{}
+
+	// locks journal files striped by item id, such that journal is written outside mu
+	locks [32]sync.Mutex
+}
+
+// journals owners of journal directories in process, such that Imagor instances
+// swapped by reload never retry the same journal concurrently.
+// Queue waiting for the directory keeps saves in memory until handed over by the owner
+var journals = struct {
+	sync.Mutex
+	owners  map[string]*retryQueue
+	waiting map[string][]*retryQueue
+}{
+	owners:  map[string]*retryQueue{},
+	waiting: map[string][]*retryQueue{},
+}
+
+func newRetryQueue(app *Imagor) *retryQueue {
+	return &retryQueue{
+		app:   app,
+		items: map[string]*retryItem{},
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// storagesType returns types of the storages of the kind
+func (q *retryQueue) storagesType(kind string) string {
+	var types []string
+	for _, storage := range q.app.storages(kind) {
+		types = append(types, getType(storage))
+	}
+	return strings.Join(types, ",")
+}
+
+// journalLock returns lock of journal files of the item id
+func (q *retryQueue) journalLock(id string) *sync.Mutex {
+	return &q.locks[int(id[0])%len(q.locks)]
+}
+
+func retryID(kind string, index int, key string) string {
+	sum := sha1.Sum([]byte(kind + "\n" + strconv.Itoa(index) + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// backoff returns backoff after number of attempts, doubled each attempt
+func (q *retryQueue) backoff(attempts int) time.Duration {
+	d := q.app.SaveRetryBackoff
+	for i := 0; i < attempts && d < maxSaveRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxSaveRetryBackoff {
+		d = maxSaveRetryBackoff
+	}
+	return d
+}
+
+// enqueue queues failed save of the storage for retry,
+// replacing queued save of the same storage and key if any
+func (q *retryQueue) enqueue(kind string, index int, storage Storage, key string, blob *Blob) {
+	buf, err := blob.ReadAll()
+	if err != nil {
+		q.fail(kind, key, "read", err)
+		return
+	}
+	item := &retryItem{
+		Kind:     kind,
+		Index:    index,
+		Type:     getType(storage),
+		Storages: q.storagesType(kind),
+		Key:      key,
+		NextAt:   time.Now().Add(q.backoff(0)),
+		id:       retryID(kind, index, key),
+		buf:      buf,
+	}
+	lock := q.journalLock(item.id)
+	lock.Lock()
+	defer lock.Unlock()
+	q.mu.Lock()
+	dir := q.dir
+	q.mu.Unlock()
+	if dir != "" {
+		// written outside mu, serialized with the same id by journal lock
+		if err = writeJournal(dir, item, true); err != nil {
+			q.fail(kind, key, "journal", err)
+			return
+		}
+		item.buf = nil
+	}
+	q.mu.Lock()
+	prev, exists := q.items[item.id]
+	var size = q.size + int64(len(item.buf))
+	if exists {
+		size -= int64(len(prev.buf))
+	}
+	if !exists && len(q.items) >= q.app.SaveRetryQueueSize {
+		q.mu.Unlock()
+		q.discard(dir, item)
+		q.fail(kind, key, "queue-full", ErrTooManyRequests)
+		return
+	}
+	if item.buf != nil && size > q.app.SaveRetryMemorySize {
+		// bounded in bytes if queued in memory
+		q.mu.Unlock()
+		q.fail(kind, key, "memory-full", ErrTooManyRequests)
+		return
+	}
+	defer q.mu.Unlock()
+	q.size = size
+	q.items[item.id] = item
+	if !exists {
+		q.app.Metrics.ObserveSaveRetryQueue(1)
+	}
+	if q.app.Debug {
+		q.app.Logger.Debug("save-retry-queued", zap.String("kind", kind), zap.String("key", key))
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove removes queued save of the storage and key if any, e.g. image deleted
+func (q *retryQueue) remove(kind string, index int, key string) {
+	q.mu.Lock()
+	item, ok := q.items[retryID(kind, index, key)]
+	if ok {
+		q.delete(item)
+	}
+	q.mu.Unlock()
+	if ok {
+		q.removeJournal(item)
+	}
+	if owner := q.journalOwner(); owner != nil {
+		owner.remove(kind, index, key)
+	}
+}
+
+// removePrefix removes queued saves of the storage with key prefix, e.g. results of image purged
+func (q *retryQueue) removePrefix(kind string, index int, prefix string) {
+	var removed []*retryItem
+	q.mu.Lock()
+	for _, item := range q.items {
+		if item.Kind == kind && item.Index == index && strings.HasPrefix(item.Key, prefix) {
+			q.delete(item)
+			removed = append(removed, item)
+		}
+	}
+	q.mu.Unlock()
+	for _, item := range removed {
+		q.removeJournal(item)
+	}
+	if owner := q.journalOwner(); owner != nil {
+		owner.removePrefix(kind, index, prefix)
+	}
+}
+
+// delete deletes item from queue, requires lock.
+// Journal files removed by removeJournal after unlock
+func (q *retryQueue) delete(item *retryItem) {
+	delete(q.items, item.id)
+	q.size -= int64(len(item.buf))
+	q.app.Metrics.ObserveSaveRetryQueue(-1)
+}
+
+// removeJournal removes journal files of item deleted, unless the same id queued again since
+func (q *retryQueue) removeJournal(item *retryItem) {
+	lock := q.journalLock(item.id)
+	lock.Lock()
+	defer lock.Unlock()
+	q.mu.Lock()
+	_, queued := q.items[item.id]
+	dir, spilled := q.dir, item.buf == nil
+	q.mu.Unlock()
+	if !queued && spilled {
+		q.discard(dir, item)
+	}
+}
+
+// discard removes journal files of item if any
+func (q *retryQueue) discard(dir string, item *retryItem) {
+	if dir != "" && item.buf == nil {
+		_ = os.Remove(journalPath(dir, item.id, ".json"))
+		_ = os.Remove(journalPath(dir, item.id, ".data"))
+	}
+}
+
+// fail records save permanently failed
+func (q *retryQueue) fail(kind, key, reason string, err error) {
+	q.app.Metrics.ObserveSaveRetryFailure(kind)
+	q.app.Logger.Warn("save-retry-failed", zap.String("kind", kind), zap.String("key", key),
+		zap.String("reason", reason), zap.Error(err))
+}
+
+// len returns number of queued saves
+func (q *retryQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// start loads journal and starts retrying queued saves until stop
+func (q *retryQueue) start() error {
+	if err := q.claimJournal(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	q.done = make(chan struct{})
+	go q.run(ctx)
+	return nil
+}
+
+// stop stops retrying, queued saves remain in journal if configured
+func (q *retryQueue) stop() {
+	if q.cancel == nil {
+		return
+	}
+	q.cancel()
+	<-q.done
+	q.cancel = nil
+	q.releaseJournal()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var abandoned int
+	for _, item := range q.items {
+		if item.buf != nil {
+			abandoned++
+		}
+	}
+	if abandoned > 0 {
+		q.app.Logger.Warn("save-retry-abandoned", zap.Int("count", abandoned))
+	}
+	// queued saves of journal retried by the next owner
+	q.app.Metrics.ObserveSaveRetryQueue(-int64(len(q.items)))
+	q.items = map[string]*retryItem{}
+	q.size = 0
+}
+
+func (q *retryQueue) run(ctx context.Context) {
+	defer close(q.done)
+	timer := time.NewTimer(q.app.SaveRetryBackoff)
+	defer timer.Stop()
+	for {
+		next := time.Now().Add(maxSaveRetryBackoff)
+		for _, item := range q.due() {
+			if ctx.Err() != nil {
+				return
+			}
+			q.attempt(ctx, item)
+		}
+		q.mu.Lock()
+		for _, item := range q.items {
+			if item.NextAt.Before(next) {
+				next = item.NextAt
+			}
+		}
+		q.mu.Unlock()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(next))
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// due returns queued saves due for retry
+func (q *retryQueue) due() (items []*retryItem) {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, item := range q.items {
+		if !item.NextAt.After(now) {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// attempt retries the queued save
+func (q *retryQueue) attempt(ctx context.Context, item *retryItem) {
+	app := q.app
+	storages := app.storages(item.Kind)
+	if item.Index >= len(storages) || getType(storages[item.Index]) != item.Type {
+		// storages changed since queued
+		q.mu.Lock()
+		current := q.items[item.id] == item
+		if current {
+			q.delete(item)
+		}
+		q.mu.Unlock()
+		if current {
+			q.removeJournal(item)
+		}
+		q.fail(item.Kind, item.Key, "storage-changed", ErrNotFound)
+		return
+	}
+	storage := storages[item.Index]
+	breaker := app.breaker(item.Kind, item.Index)
+	if !breaker.allow() {
+		q.mu.Lock()
+		item.NextAt = time.Now().Add(q.backoff(item.Attempts))
+		q.mu.Unlock()
+		return
+	}
+	var blob *Blob
+	q.mu.Lock()
+	if item.buf != nil {
+		blob = NewBlobFromBytes(item.buf)
+	} else {
+		blob = NewBlobFromFile(journalPath(q.dir, item.id, ".data"))
+	}
+	q.mu.Unlock()
+	if app.SaveTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, app.SaveTimeout)
+		defer cancel()
+	}
+	var start = time.Now()
+	err := storage.Put(ctx, item.Key, blob)
+	breaker.done(err)
+	app.emit(ctx, saveEvent(item.Kind, item.Key, start, blob, err))
+
+	q.mu.Lock()
+	if q.items[item.id] != item {
+		// replaced by newer save
+		q.mu.Unlock()
+		return
+	}
+	if err == nil {
+		q.delete(item)
+		q.mu.Unlock()
+		q.removeJournal(item)
+		if app.Debug {
+			app.Logger.Debug("save-retried", zap.String("kind", item.Kind), zap.String("key", item.Key),
+				zap.Int("attempts", item.Attempts+1))
+		}
+		return
+	}
+	item.Attempts++
+	if item.Attempts >= app.SaveRetryAttempts {
+		q.delete(item)
+		q.mu.Unlock()
+		q.removeJournal(item)
+		q.fail(item.Kind, item.Key, "max-attempts", err)
+		return
+	}
+	item.NextAt = time.Now().Add(q.backoff(item.Attempts))
+	q.mu.Unlock()
+	q.updateJournal(item)
+}
+
+// updateJournal writes metadata of item to journal, unless deleted or replaced since
+func (q *retryQueue) updateJournal(item *retryItem) {
+	lock := q.journalLock(item.id)
+	lock.Lock()
+	defer lock.Unlock()
+	q.mu.Lock()
+	dir := q.dir
+	if q.items[item.id] != item || item.buf != nil || dir == "" {
+		q.mu.Unlock()
+		return
+	}
+	meta := *item
+	q.mu.Unlock()
+	_ = writeJournal(dir, &meta, false)
+}
+
+func journalPath(dir, id, ext string) string {
+	return filepath.Join(dir, id+ext)
+}
+
+// claimJournal loads journal directory if not owned by another queue in process,
+// otherwise waits for the directory being handed over upon stop of the owner
+func (q *retryQueue) claimJournal() error {
+	dir := q.app.SaveRetryJournal
+	if dir == "" {
+		return nil
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	journals.Lock()
+	defer journals.Unlock()
+	if owner, ok := journals.owners[dir]; ok && owner != q {
+		journals.waiting[dir] = append(journals.waiting[dir], q)
+		return nil
+	}
+	journals.owners[dir] = q
+	return q.loadJournal(dir)
+}
+
+// journalOwner returns queue owning the journal directory the queue is waiting for, if any
+func (q *retryQueue) journalOwner() *retryQueue {
+	journals.Lock()
+	defer journals.Unlock()
+	for dir, waiting := range journals.waiting {
+		for _, w := range waiting {
+			if w == q {
+				return journals.owners[dir]
+			}
+		}
+	}
+	return nil
+}
+
+// releaseJournal hands over journal directory to the next queue waiting if any
+func (q *retryQueue) releaseJournal() {
+	journals.Lock()
+	defer journals.Unlock()
+	for dir, owner := range journals.owners {
+		if owner != q {
+			continue
+		}
+		delete(journals.owners, dir)
+		for len(journals.waiting[dir]) > 0 {
+			next := journals.waiting[dir][0]
+			journals.waiting[dir] = journals.waiting[dir][1:]
+			journals.owners[dir] = next
+			if err := next.loadJournal(dir); err != nil {
+				next.app.Logger.Warn("save-retry-journal", zap.String("dir", dir), zap.Error(err))
+				delete(journals.owners, dir)
+				continue
+			}
+			break
+		}
+		if len(journals.waiting[dir]) == 0 {
+			delete(journals.waiting, dir)
+		}
+		return
+	}
+	for dir, waiting := range journals.waiting {
+		for i, w := range waiting {
+			if w == q {
+				journals.waiting[dir] = append(waiting[:i:i], waiting[i+1:]...)
+				break
+			}
+		}
+		if len(journals.waiting[dir]) == 0 {
+			delete(journals.waiting, dir)
+		}
+	}
+}
+
+// writeJournal writes item metadata, and data if withData, to journal directory atomically
+func writeJournal(dir string, item *retryItem, withData bool) error {
+	if withData {
+		if err := writeFileAtomic(journalPath(dir, item.id, ".data"), item.buf); err != nil {
+			return err
+		}
+	}
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(journalPath(dir, item.id, ".json"), buf)
+}
+
+// loadJournal loads queued saves from journal directory and spills saves queued in memory to it.
+// Saves queued for storages other than of the queue are ignored, and orphaned data files removed
+func (q *retryQueue) loadJournal(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dir = dir
+	for _, item := range q.items {
+		if item.buf != nil && writeJournal(dir, item, true) == nil {
+			q.size -= int64(len(item.buf))
+			item.buf = nil
+		}
+	}
+	var metadata = map[string]bool{}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".json" {
+			metadata[strings.TrimSuffix(entry.Name(), ".json")] = true
+		}
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) == ".tmp" {
+			// partially written
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if entry.IsDir() {
+			// journals of tenants
+			continue
+		}
+		if filepath.Ext(name) == ".data" && !metadata[strings.TrimSuffix(name, ".data")] {
+			if _, ok := q.items[strings.TrimSuffix(name, ".data")]; !ok {
+				// orphaned data of metadata not written or removed
+				_ = os.Remove(filepath.Join(dir, name))
+			}
+			continue
+		}
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+		if _, ok := q.items[id]; ok {
+			// queued in memory, newer than journal
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(dir, name))
+		var item = &retryItem{id: id}
+		if err == nil {
+			err = json.Unmarshal(buf, item)
+		}
+		if err == nil {
+			_, err = os.Stat(journalPath(dir, id, ".data"))
+		}
+		if err != nil {
+			q.app.Logger.Warn("save-retry-journal", zap.String("file", name), zap.Error(err))
+			_ = os.Remove(journalPath(dir, id, ".json"))
+			_ = os.Remove(journalPath(dir, id, ".data"))
+			continue
+		}
+		if item.Storages != q.storagesType(item.Kind) {
+			// queued for other storages, e.g. of other Imagor instance
+			q.app.Logger.Warn("save-retry-journal-ignored", zap.String("file", name),
+				zap.String("storages", item.Storages))
+			continue
+		}
+		q.app.Metrics.ObserveSaveRetryQueue(1)
+		q.items[id] = item
+	}
+	return nil
+}
+
+// writeFileAtomic writes file by renaming temp file, such that partial file is never read
+func writeFileAtomic(file string, buf []byte) error {
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,249 @@
+package imagor
+
+import (
+	"context"
+	"errors"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newRetryApp(t *testing.T, put func(image string) error, options ...Option) (*Imagor, *testMetrics) {
+	metrics := newTestMetrics()
+	app := New(append([]Option{
+		WithUnsafe(true),
+		WithMetrics(metrics),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithResultStorages(saverFunc(func(ctx context.Context, image string, blob *Blob) error {
+			return put(image)
+		})),
+	}, options...)...)
+	require.NoError(t, app.Startup(context.Background()))
+	return app, metrics
+}
+
+func retrying(metrics *testMetrics) int64 {
+	metrics.l.Lock()
+	defer metrics.l.Unlock()
+	return metrics.Retrying
+}
+
+func failures(metrics *testMetrics) int {
+	metrics.l.Lock()
+	defer metrics.l.Unlock()
+	return metrics.Failures["result_storage"]
+}
+
+func TestSaveRetry(t *testing.T) {
+	var calls int32
+	app, metrics := newRetryApp(t, func(image string) error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, WithSaveRetryAttempts(3), WithSaveRetryBackoff(time.Millisecond))
+	defer func() {
+		require.NoError(t, app.Shutdown(context.Background()))
+	}()
+
+	_, err := app.Serve(context.Background(), imagorpath.Parse("unsafe/foo.jpg"))
+	require.NoError(t, err)
+	app.Wait()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 3 && app.retry.len() == 0
+	}, time.Second, time.Millisecond, "saved by the second retry")
+	assert.Equal(t, int64(0), retrying(metrics))
+	assert.Equal(t, 0, failures(metrics))
+}
+
+func TestSaveRetryFailed(t *testing.T) {
+	var calls int32
+	app, metrics := newRetryApp(t, func(image string) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("unavailable")
+	}, WithSaveRetryAttempts(2), WithSaveRetryBackoff(time.Millisecond))
+	defer func() {
+		require.NoError(t, app.Shutdown(context.Background()))
+	}()
+
+	_, err := app.Serve(context.Background(), imagorpath.Parse("unsafe/foo.jpg"))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return failures(metrics) == 1
+	}, time.Second, time.Millisecond, "permanently failed after attempts")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, int64(0), retrying(metrics))
+}
+
+func TestSaveRetryQueue(t *testing.T) {
+	app, metrics := newRetryApp(t, func(image string) error {
+		return errors.New("unavailable")
+	}, WithSaveRetryAttempts(2), WithSaveRetryBackoff(time.Hour), WithSaveRetryQueueSize(1))
+	defer func() {
+		require.NoError(t, app.Shutdown(context.Background()))
+	}()
+
+	for _, path := range []string{"unsafe/foo.jpg", "unsafe/foo.jpg", "unsafe/bar.jpg"} {
+		app.save(context.Background(), "result_storage", imagorpath.Parse(path).Image, NewBlobFromBytes([]byte(path)))
+	}
+	assert.Equal(t, 1, app.retry.len(), "same key replaced")
+	assert.Equal(t, int64(1), retrying(metrics))
+	assert.Equal(t, 1, failures(metrics), "dropped by full queue")
+
+	app.del(context.Background(), "result_storage", "foo.jpg")
+	assert.Equal(t, 0, app.retry.len(), "deleted image not retried")
+	assert.Equal(t, int64(0), retrying(metrics))
+}
+
+func TestSaveRetryMemorySize(t *testing.T) {
+	app, metrics := newRetryApp(t, func(image string) error {
+		return errors.New("unavailable")
+	}, WithSaveRetryAttempts(2), WithSaveRetryBackoff(time.Hour), WithSaveRetryMemorySize(10))
+	defer func() {
+		require.NoError(t, app.Shutdown(context.Background()))
+	}()
+
+	app.save(context.Background(), "result_storage", "foo.jpg", NewBlobFromBytes([]byte("foo")))
+	app.save(context.Background(), "result_storage", "foo.jpg", NewBlobFromBytes([]byte("foobar")))
+	assert.Equal(t, 1, app.retry.len(), "same key replaced")
+	app.save(context.Background(), "result_storage", "bar.jpg", NewBlobFromBytes([]byte("barbaz")))
+	assert.Equal(t, 1, app.retry.len())
+	assert.Equal(t, 1, failures(metrics), "dropped by memory size exceeded")
+	app.save(context.Background(), "result_storage", "bar.jpg", NewBlobFromBytes([]byte("bar")))
+	assert.Equal(t, 2, app.retry.len())
+
+	app.del(context.Background(), "result_storage", "foo.jpg")
+	app.save(context.Background(), "result_storage", "baz.jpg", NewBlobFromBytes([]byte("bazbaz")))
+	assert.Equal(t, 2, app.retry.len(), "memory released by deleted")
+	assert.Equal(t, 1, failures(metrics))
+}
+
+func TestSaveRetryJournal(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "journal")
+	app, metrics := newRetryApp(t, func(image string) error {
+		return errors.New("unavailable")
+	}, WithSaveRetryAttempts(10), WithSaveRetryBackoff(time.Millisecond), WithSaveRetryJournal(dir))
+	_, err := app.Serve(context.Background(), imagorpath.Parse("unsafe/foo.jpg"))
+	require.NoError(t, err)
+	app.Wait()
+	require.NoError(t, app.Shutdown(context.Background()))
+	assert.Equal(t, int64(0), retrying(metrics), "left to journal")
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "metadata and data spilled to journal")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "partial.data.tmp"), []byte("foo"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "orphan.data"), []byte("foo"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foreign.data"), []byte("bar"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foreign.json"), []byte(
+		`{"kind":"result_storage","index":0,"type":"fileStorage","storages":"fileStorage","key":"bar.jpg"}`), 0644))
+
+	saved := make(chan string, 1)
+	app, metrics = newRetryApp(t, func(image string) error {
+		saved <- image
+		return nil
+	}, WithSaveRetryAttempts(10), WithSaveRetryBackoff(time.Millisecond), WithSaveRetryJournal(dir))
+	defer func() {
+		require.NoError(t, app.Shutdown(context.Background()))
+	}()
+	select {
+	case image := <-saved:
+		assert.Equal(t, "foo.jpg", image, "retried after restart")
+	case <-time.After(time.Second):
+		t.Fatal("queued save not retried after restart")
+	}
+	assert.Eventually(t, func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		return len(files) == 2
+	}, time.Second, time.Millisecond, "journal cleared")
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "foreign.data"), filepath.Join(dir, "foreign.json"),
+	}, files, "queued for other storages ignored")
+	assert.Equal(t, int64(0), retrying(metrics))
+}
+
+func TestSaveRetryJournalReload(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "journal")
+	prev, _ := newRetryApp(t, func(image string) error {
+		return errors.New("unavailable")
+	}, WithSaveRetryAttempts(10), WithSaveRetryBackoff(time.Hour), WithSaveRetryJournal(dir))
+	prev.save(context.Background(), "result_storage", "foo.jpg", NewBlobFromBytes([]byte("foo")))
+	require.Equal(t, 1, prev.retry.len())
+
+	var calls int32
+	saved := make(chan string, 2)
+	app, metrics := newRetryApp(t, func(image string) error {
+		if image == "bar.jpg" && atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("unavailable")
+		}
+		saved <- image
+		return nil
+	}, WithSaveRetryAttempts(10), WithSaveRetryBackoff(time.Hour), WithSaveRetryJournal(dir))
+	defer func() {
+		require.NoError(t, app.Shutdown(context.Background()))
+	}()
+	assert.Equal(t, 0, app.retry.len(), "journal owned by previous instance")
+	app.save(context.Background(), "result_storage", "bar.jpg", NewBlobFromBytes([]byte("bar")))
+	assert.Equal(t, 1, app.retry.len(), "queued in memory")
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "journal of previous instance only")
+
+	require.NoError(t, prev.Shutdown(context.Background()))
+	assert.Equal(t, 2, app.retry.len(), "journal handed over")
+	assert.Equal(t, int64(2), retrying(metrics))
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Len(t, files, 4, "queued in memory spilled to journal")
+
+	app.retry.remove("result_storage", 0, "bar.jpg")
+	assert.Equal(t, 1, app.retry.len())
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestSaveRetryPurge(t *testing.T) {
+	resultStore := retryPrefixStore{saverFunc(func(ctx context.Context, image string, blob *Blob) error {
+		return errors.New("unavailable")
+	})}
+	app := New(
+		WithUnsafe(true),
+		WithPurgeSecret("s3cret"),
+		WithResultStorages(resultStore),
+		WithResultKey(SourcePrefixResultKey{}),
+		WithSaveRetryAttempts(2), WithSaveRetryBackoff(time.Hour))
+	require.NoError(t, app.Startup(context.Background()))
+	defer func() {
+		require.NoError(t, app.Shutdown(context.Background()))
+	}()
+	for _, path := range []string{"fit-in/100x100/foo.jpg", "200x0/foo.jpg", "200x0/bar.jpg"} {
+		key := SourcePrefixResultKey{}.Generate(imagorpath.Parse(path))
+		app.save(context.Background(), "result_storage", key, NewBlobFromBytes([]byte(path)))
+	}
+	require.Equal(t, 3, app.retry.len())
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "https://example.com/foo.jpg", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	app.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, app.retry.len(), "queued results of purged image removed")
+}
+
+type retryPrefixStore struct {
+	saverFunc
+}
+
+func (s retryPrefixStore) DeletePrefix(_ context.Context, _ string) error {
+	return nil
+}
@@ -0,0 +1,253 @@
+package imagor
+
+import (
+	"container/list"
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPriorityClass name of the priority class if none configured
+const DefaultPriorityClass = "default"
+
+// priorityFilter signed claim of priority class in endpoint filters, e.g. priority(batch)
+const priorityFilter = "priority"
+
+// PriorityClass process queue priority class
+type PriorityClass struct {
+	// Name of the class, matched by priority header value or signed priority filter
+	Name string
+	// Weight relative share of process slots among classes under contention, default 1
+	Weight int
+	// PathPrefix image path prefix that matches the class
+	PathPrefix string
+}
+
+// QueueStats process queue stats of a priority class
+type QueueStats struct {
+	Class      string
+	Waiting    int64
+	Processing int64
+	// OldestWait wait time of the oldest process waiting in queue
+	OldestWait time.Duration
+	// AvgWait average wait time of processes dequeued
+	AvgWait time.Duration
+}
+
+type queueWaiter struct {
+	ready    chan struct{}
+	start    time.Time
+	elem     *list.Element
+	acquired bool
+}
+
+type queueClass struct {
+	PriorityClass
+	stride     float64
+	pass       float64
+	queue      list.List
+	processing int64
+	dequeued   int64
+	totalWait  time.Duration
+}
+
+// scheduler priority-aware process scheduler,
+// with stride scheduling for weighted fair queuing between classes
+type scheduler struct {
+	concurrency  int64
+	queueSize    int64
+	queueTimeout time.Duration
+
+	mu      sync.Mutex
+	running int64
+	waiting int64
+	vtime   float64
+	classes map[string]*queueClass
+	order   []*queueClass
+}
+
+func newScheduler(
+	concurrency, queueSize int64, queueTimeout time.Duration, classes ...PriorityClass,
+) *scheduler {
+	s := &scheduler{
+		concurrency:  concurrency,
+		queueSize:    queueSize,
+		queueTimeout: queueTimeout,
+		classes:      map[string]*queueClass{},
+	}
+	if len(classes) == 0 {
+		classes = []PriorityClass{{Name: DefaultPriorityClass}}
+	}
+	for _, class := range classes {
+		if _, ok := s.classes[class.Name]; ok {
+			continue
+		}
+		if class.Weight <= 0 {
+			class.Weight = 1
+		}
+		c := &queueClass{PriorityClass: class, stride: 1 / float64(class.Weight)}
+		s.classes[class.Name] = c
+		s.order = append(s.order, c)
+	}
+	return s
+}
+
+// class returns queue class by name, default to the first class
+func (s *scheduler) class(name string) *queueClass {
+	if c, ok := s.classes[name]; ok {
+		return c
+	}
+	return s.order[0]
+}
+
+// Acquire acquires a process slot for the priority class,
+// blocks until slot available, queue timeout or context done.
+// Returns release func and the time waited in queue
+func (s *scheduler) Acquire(
+	ctx context.Context, class string,
+) (release func(), wait time.Duration, err error) {
+	var start = time.Now()
+	s.mu.Lock()
+	c := s.class(class)
+	release = func() {
+		s.release(c)
+	}
+	if s.queueSize > 0 && s.running+s.waiting >= s.concurrency+s.queueSize {
+		s.mu.Unlock()
+		return nil, 0, ErrTooManyRequests
+	}
+	if s.concurrency <= 0 {
+		// unlimited concurrency, still bounded by queue size if any
+		s.running++
+		c.processing++
+		s.mu.Unlock()
+		return
+	}
+	if s.running < s.concurrency && s.waiting == 0 {
+		s.dispatch(c)
+		s.mu.Unlock()
+		return
+	}
+	if c.queue.Len() == 0 && c.pass < s.vtime {
+		// class idle, catch up virtual time without accumulating credits
+		c.pass = s.vtime
+	}
+	w := &queueWaiter{ready: make(chan struct{}), start: start}
+	w.elem = c.queue.PushBack(w)
+	s.waiting++
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if s.queueTimeout > 0 {
+		timer := time.NewTimer(s.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-w.ready:
+		return release, time.Since(start), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrTooManyRequests
+	}
+	s.mu.Lock()
+	if w.acquired {
+		// acquired concurrently with cancel, hand over the slot
+		s.mu.Unlock()
+		s.release(c)
+		return nil, time.Since(start), err
+	}
+	c.queue.Remove(w.elem)
+	s.waiting--
+	s.mu.Unlock()
+	return nil, time.Since(start), err
+}
+
+// dispatch accounts a process slot for class, requires lock
+func (s *scheduler) dispatch(c *queueClass) {
+	if c.pass < s.vtime {
+		c.pass = s.vtime
+	}
+	s.vtime = c.pass
+	c.pass += c.stride
+	s.running++
+	c.processing++
+}
+
+func (s *scheduler) release(c *queueClass) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	c.processing--
+	for s.waiting > 0 && (s.concurrency <= 0 || s.running < s.concurrency) {
+		// pick class of minimum pass among classes waiting
+		var next *queueClass
+		for _, q := range s.order {
+			if q.queue.Len() > 0 && (next == nil || q.pass < next.pass) {
+				next = q
+			}
+		}
+		w := next.queue.Remove(next.queue.Front()).(*queueWaiter)
+		s.waiting--
+		s.dispatch(next)
+		next.dequeued++
+		next.totalWait += time.Since(w.start)
+		w.acquired = true
+		close(w.ready)
+	}
+}
+
+// Stats returns queue stats of priority classes
+func (s *scheduler) Stats() (stats []QueueStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, c := range s.order {
+		st := QueueStats{
+			Class:      c.Name,
+			Waiting:    int64(c.queue.Len()),
+			Processing: c.processing,
+		}
+		if front := c.queue.Front(); front != nil {
+			st.OldestWait = now.Sub(front.Value.(*queueWaiter).start)
+		}
+		if c.dequeued > 0 {
+			st.AvgWait = c.totalWait / time.Duration(c.dequeued)
+		}
+		stats = append(stats, st)
+	}
+	return
+}
+
+// QueueStats returns process queue stats by priority class, for monitoring
+func (app *Imagor) QueueStats() []QueueStats {
+	return app.scheduler.Stats()
+}
+
+// priorityClass resolves priority class of the request,
+// by signed priority filter, then WithPriority of context, then priority header, then image path prefix
+func (app *Imagor) priorityClass(r *http.Request, p imagorpath.Params) string {
+	for _, f := range p.Filters {
+		if f.Name == priorityFilter && f.Args != "" {
+			return f.Args
+		}
+	}
+	if o := getServeOptions(r.Context()); o != nil && o.Priority != "" {
+		return o.Priority
+	}
+	if app.PriorityHeader != "" {
+		if v := r.Header.Get(app.PriorityHeader); v != "" {
+			return v
+		}
+	}
+	for _, class := range app.PriorityClasses {
+		if class.PathPrefix != "" && strings.HasPrefix(p.Image, class.PathPrefix) {
+			return class.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,199 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitQueue(t *testing.T, s *scheduler, waiting int64) {
+	require.Eventually(t, func() bool {
+		var n int64
+		for _, st := range s.Stats() {
+			n += st.Waiting
+		}
+		return n == waiting
+	}, time.Second, time.Millisecond)
+}
+
+func TestSchedulerFairQueuing(t *testing.T) {
+	s := newScheduler(1, 0, 0,
+		PriorityClass{Name: "interactive", Weight: 3},
+		PriorityClass{Name: "batch", Weight: 1},
+	)
+	hold, _, err := s.Acquire(context.Background(), "interactive")
+	require.NoError(t, err)
+
+	var l sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	enqueue := func(class string, n int) {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, _, err := s.Acquire(context.Background(), class)
+				require.NoError(t, err)
+				l.Lock()
+				order = append(order, class)
+				l.Unlock()
+				release()
+			}()
+		}
+	}
+	enqueue("batch", 8)
+	waitQueue(t, s, 8)
+	enqueue("interactive", 8)
+	waitQueue(t, s, 16)
+
+	stats := s.Stats()
+	assert.Equal(t, "interactive", stats[0].Class)
+	assert.Equal(t, int64(8), stats[0].Waiting)
+	assert.Equal(t, int64(1), stats[0].Processing)
+	assert.Equal(t, "batch", stats[1].Class)
+	assert.Equal(t, int64(8), stats[1].Waiting)
+	assert.True(t, stats[1].OldestWait > 0)
+
+	hold()
+	wg.Wait()
+	require.Len(t, order, 16)
+	var interactive int
+	for _, class := range order[:8] {
+		if class == "interactive" {
+			interactive++
+		}
+	}
+	assert.Equal(t, 6, interactive, "interactive should take 3 of every 4 slots: %v", order)
+
+	for _, st := range s.Stats() {
+		assert.Equal(t, int64(0), st.Waiting)
+		assert.Equal(t, int64(0), st.Processing)
+		assert.True(t, st.AvgWait > 0)
+	}
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	t.Run("queue size", func(t *testing.T) {
+		s := newScheduler(1, 1, 0)
+		hold, _, err := s.Acquire(context.Background(), "")
+		require.NoError(t, err)
+		go func() {
+			release, _, _ := s.Acquire(context.Background(), "")
+			release()
+		}()
+		waitQueue(t, s, 1)
+		_, _, err = s.Acquire(context.Background(), "")
+		assert.Equal(t, ErrTooManyRequests, err)
+		hold()
+	})
+
+	t.Run("queue timeout", func(t *testing.T) {
+		s := newScheduler(1, 0, time.Millisecond*5)
+		hold, _, err := s.Acquire(context.Background(), "")
+		require.NoError(t, err)
+		_, wait, err := s.Acquire(context.Background(), "")
+		assert.Equal(t, ErrTooManyRequests, err)
+		assert.True(t, wait >= time.Millisecond*5)
+		waitQueue(t, s, 0)
+		hold()
+		release, _, err := s.Acquire(context.Background(), "")
+		assert.NoError(t, err)
+		release()
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		s := newScheduler(1, 0, 0)
+		hold, _, err := s.Acquire(context.Background(), "")
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		_, _, err = s.Acquire(ctx, "")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		hold()
+		assert.Equal(t, []QueueStats{{Class: DefaultPriorityClass}}, s.Stats())
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		s := newScheduler(0, 0, 0)
+		var releases []func()
+		for i := 0; i < 10; i++ {
+			release, _, err := s.Acquire(context.Background(), "")
+			require.NoError(t, err)
+			releases = append(releases, release)
+		}
+		assert.Equal(t, int64(10), s.Stats()[0].Processing)
+		for _, release := range releases {
+			release()
+		}
+		assert.Equal(t, int64(0), s.Stats()[0].Processing)
+	})
+
+	t.Run("unlimited concurrency queue size", func(t *testing.T) {
+		s := newScheduler(0, 2, 0)
+		hold1, _, err := s.Acquire(context.Background(), "")
+		require.NoError(t, err)
+		hold2, _, err := s.Acquire(context.Background(), "")
+		require.NoError(t, err)
+		_, _, err = s.Acquire(context.Background(), "")
+		assert.Equal(t, ErrTooManyRequests, err)
+		hold1()
+		release, _, err := s.Acquire(context.Background(), "")
+		assert.NoError(t, err)
+		release()
+		hold2()
+	})
+}
+
+func TestPriorityClass(t *testing.T) {
+	resultStore := newMapStore()
+	app := New(
+		WithUnsafe(true),
+		WithProcessConcurrency(2),
+		WithPriorityHeader("X-Priority"),
+		WithPriorityClasses(
+			PriorityClass{Name: "interactive", Weight: 4},
+			PriorityClass{Name: "batch", PathPrefix: "renditions/"},
+		),
+		WithResultStorages(resultStore),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			return NewBlobFromBytes([]byte(p.Path)), nil
+		})),
+	)
+	assert.Len(t, app.QueueStats(), 2)
+	for _, tt := range []struct {
+		path, header, class string
+	}{
+		{"/unsafe/foo.jpg", "", "interactive"},
+		{"/unsafe/renditions/foo.jpg", "", "batch"},
+		{"/unsafe/renditions/foo.jpg", "interactive", "interactive"},
+		{"/unsafe/bar.jpg", "batch", "batch"},
+		{"/unsafe/bar.jpg", "unknown", "interactive"},
+		{"/unsafe/filters:priority(batch)/baz.jpg", "interactive", "batch"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com"+tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set("X-Priority", tt.header)
+		}
+		p := imagorpath.Parse(r.URL.Path)
+		assert.Equal(t, tt.class, app.scheduler.class(app.priorityClass(r, p)).Name, tt.path)
+	}
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/fit-in/filters:priority(batch)/baz.jpg", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Eventually(t, func() bool {
+		resultStore.l.Lock()
+		defer resultStore.l.Unlock()
+		_, ok := resultStore.Map["fit-in/baz.jpg"]
+		return ok
+	}, time.Second, time.Millisecond, "result key should exclude priority")
+}
@@ -0,0 +1,112 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+var requestCtxKey = &contextKey{"Request"}
+
+var serveCtxKey = &contextKey{"Serve"}
+
+// serveOptions options of Serve set by context, in place of request headers
+type serveOptions struct {
+	Header     http.Header
+	Priority   string
+	Regenerate bool
+}
+
+func withServeOptions(ctx context.Context, fn func(o *serveOptions)) context.Context {
+	o := &serveOptions{Header: http.Header{}}
+	if prev := getServeOptions(ctx); prev != nil {
+		o.Header = prev.Header.Clone()
+		o.Priority = prev.Priority
+		o.Regenerate = prev.Regenerate
+	}
+	fn(o)
+	return context.WithValue(ctx, serveCtxKey, o)
+}
+
+func getServeOptions(ctx context.Context) *serveOptions {
+	o, _ := ctx.Value(serveCtxKey).(*serveOptions)
+	return o
+}
+
+// WithAccept returns context with Accept of Serve,
+// for output format negotiation if AutoWebP or AutoAVIF enabled
+func WithAccept(ctx context.Context, accept string) context.Context {
+	return withServeOptions(ctx, func(o *serveOptions) {
+		o.Header.Set("Accept", accept)
+	})
+}
+
+// WithDeviceHints returns context with device pixel ratio, width and viewport width of Serve,
+// for resizing by client hints if ClientHints enabled. Zero values are ignored
+func WithDeviceHints(ctx context.Context, dpr, width, viewportWidth float64) context.Context {
+	return withServeOptions(ctx, func(o *serveOptions) {
+		for i, v := range []float64{dpr, width, viewportWidth} {
+			if v > 0 {
+				o.Header.Set(clientHints[i].Name, strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+	})
+}
+
+// WithForwardHeaders returns context with headers of Serve forwarded to Loaders,
+// e.g. HTTP Loader forward headers
+func WithForwardHeaders(ctx context.Context, header http.Header) context.Context {
+	return withServeOptions(ctx, func(o *serveOptions) {
+		for key, values := range header {
+			o.Header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+		}
+	})
+}
+
+// WithPriority returns context with process queue priority class of Serve,
+// taking precedence over priority header and image path prefix
+func WithPriority(ctx context.Context, class string) context.Context {
+	return withServeOptions(ctx, func(o *serveOptions) {
+		o.Priority = class
+	})
+}
+
+// WithRegenerate returns context of Serve skipping lookup of Result Storages,
+// such that the result is processed and saved again even if it exists
+func WithRegenerate(ctx context.Context) context.Context {
+	return withServeOptions(ctx, func(o *serveOptions) {
+		o.Regenerate = true
+	})
+}
+
+func isRegenerate(ctx context.Context) bool {
+	o := getServeOptions(ctx)
+	return o != nil && o.Regenerate
+}
+
+// serveRequest returns HTTP request of Do if any, otherwise request of the params.
+// Headers of serve options of context are applied to the request,
+// such that Loaders and features depending on request headers behave the same
+func serveRequest(ctx context.Context, p imagorpath.Params) *http.Request {
+	r, ok := ctx.Value(requestCtxKey).(*http.Request)
+	if !ok {
+		r = &http.Request{
+			Method:     http.MethodGet,
+			URL:        &url.URL{Path: "/" + p.Path},
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+		}
+	}
+	if o := getServeOptions(ctx); o != nil && len(o.Header) > 0 {
+		r = r.Clone(ctx)
+		for key, values := range o.Header {
+			r.Header[key] = values
+		}
+		return r
+	}
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,87 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	var header http.Header
+	resultStore := newMapStore()
+	app := New(
+		WithUnsafe(true),
+		WithAutoWebP(true),
+		WithClientHints(true),
+		WithPriorityHeader("X-Priority"),
+		WithPriorityClasses(PriorityClass{Name: "interactive"}, PriorityClass{Name: "batch"}),
+		WithResultStorages(resultStore),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			header = r.Header
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+			return NewBlobFromBytes([]byte(p.Path)), nil
+		})),
+	)
+
+	blob, err := app.Serve(context.Background(), imagorpath.Parse("unsafe/foo.jpg"))
+	require.NoError(t, err)
+	buf, _ := blob.ReadAll()
+	assert.Equal(t, "foo.jpg", string(buf))
+
+	ctx := WithAccept(context.Background(), "image/webp,*/*")
+	ctx = WithDeviceHints(ctx, 2, 0, 0)
+	ctx = WithForwardHeaders(ctx, http.Header{"x-api-key": {"abcd"}})
+	blob, err = app.Serve(ctx, imagorpath.Parse("unsafe/100x100/bar.jpg"))
+	require.NoError(t, err)
+	buf, _ = blob.ReadAll()
+	assert.Equal(t, "200x200/filters:format(webp)/bar.jpg", string(buf), "negotiated by accept and device hints")
+	assert.Equal(t, "abcd", header.Get("X-Api-Key"))
+	assert.Equal(t, "2", header.Get("Sec-CH-DPR"))
+
+	app.Wait()
+	assert.Contains(t, resultStore.Map, "200x200/filters:format(webp)/bar.jpg", "saved after Serve returned")
+
+	require.NoError(t, resultStore.Put(context.Background(), "foo.jpg", NewBlobFromBytes([]byte("cached"))))
+	blob, err = app.Serve(context.Background(), imagorpath.Parse("unsafe/foo.jpg"))
+	require.NoError(t, err)
+	buf, _ = blob.ReadAll()
+	assert.Equal(t, "cached", string(buf))
+	blob, err = app.Serve(WithRegenerate(context.Background()), imagorpath.Parse("unsafe/foo.jpg"))
+	require.NoError(t, err)
+	buf, _ = blob.ReadAll()
+	assert.Equal(t, "foo.jpg", string(buf), "regenerated without result lookup")
+	app.Wait()
+	resultStore.l.Lock()
+	defer resultStore.l.Unlock()
+	assert.Equal(t, 3, resultStore.SaveCnt["foo.jpg"], "regenerated result saved")
+}
+
+func TestServeOptions(t *testing.T) {
+	app := New(
+		WithPriorityHeader("X-Priority"),
+		WithPriorityClasses(PriorityClass{Name: "interactive"}, PriorityClass{Name: "batch"}),
+	)
+	p := imagorpath.Parse("unsafe/foo.jpg")
+	ctx := WithPriority(context.Background(), "batch")
+	r := serveRequest(ctx, p)
+	assert.Equal(t, "/foo.jpg", r.URL.Path)
+	assert.Equal(t, "batch", app.priorityClass(r, p))
+
+	r = httptest.NewRequest(http.MethodGet, "/unsafe/foo.jpg", nil)
+	r.Header.Set("X-Priority", "interactive")
+	r.Header.Set("Accept", "image/avif")
+	assert.Equal(t, "interactive", app.priorityClass(serveRequest(context.WithValue(r.Context(), requestCtxKey, r), p), p))
+
+	ctx = WithAccept(WithPriority(context.WithValue(r.Context(), requestCtxKey, r), "batch"), "image/webp")
+	sr := serveRequest(ctx, p)
+	assert.Equal(t, "batch", app.priorityClass(sr, p), "context takes precedence over header")
+	assert.Equal(t, "image/webp", sr.Header.Get("Accept"))
+	assert.Equal(t, "interactive", sr.Header.Get("X-Priority"))
+	assert.Equal(t, "image/avif", r.Header.Get("Accept"), "request of Do not mutated")
+}
@@ -94,3 +94,56 @@ func WithAccessLog(enabled bool) Option {
 		}
 	}
 }
+
+func WithMetrics(path string, handler http.Handler) Option {
+	return func(s *Server) {
+		if path != "" && handler != nil {
+			s.Handler = pathHandler(http.MethodGet, map[string]http.HandlerFunc{
+				path: handler.ServeHTTP,
+			})(s.Handler)
+		}
+	}
+}
+
+// WithRateLimit limits requests per client including cache hits,
+// in requests per second with burst
+func WithRateLimit(rate float64, burst int) Option {
+	return func(s *Server) {
+		if rate > 0 {
+			s.RateLimit = rate
+			s.RateLimitBurst = burst
+		}
+	}
+}
+
+// WithProcessRateLimit limits image processes per client,
+// in processes per second with burst. Cache hits are not counted
+func WithProcessRateLimit(rate float64, burst int) Option {
+	return func(s *Server) {
+		if rate > 0 {
+			s.ProcessRateLimit = rate
+			s.ProcessRateLimitBurst = burst
+		}
+	}
+}
+
+// WithRateLimitKey with func keying rate limit per client, e.g. client IP, API key or signing key ID.
+// Requests of empty key are not limited
+func WithRateLimitKey(key func(r *http.Request) string) Option {
+	return func(s *Server) {
+		if key != nil {
+			s.RateLimitKey = key
+		}
+	}
+}
+
+// WithReload enables reloading App rebuilt by reloader upon changes of watchers,
+// e.g. SignalWatcher or FileWatcher
+func WithReload(reloader Reloader, watchers ...Watcher) Option {
+	return func(s *Server) {
+		if reloader != nil {
+			s.Reloader = reloader
+			s.Watchers = append(s.Watchers, watchers...)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"crypto/subtle"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
+	"go.uber.org/zap"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval interval of removing idle token buckets
+const sweepInterval = time.Minute
+
+// maxBuckets maximum number of token buckets tracked,
+// evicting the least recently used bucket if exceeded
+const maxBuckets = 100000
+
+// RateLimitByIP keys rate limit by IP of the remote address.
+// Forwarded headers are not trusted, see RateLimitByRealIP if behind proxies
+func RateLimitByIP(r *http.Request) string {
+	return remoteIP(r)
+}
+
+// RateLimitByRealIP keys rate limit by client real IP from X-Forwarded-For and X-Real-Ip headers,
+// only if the remote address is of trusted proxies in IP or CIDR, e.g. 10.0.0.0/8.
+// Otherwise keyed by IP of the remote address
+func RateLimitByRealIP(trustedProxies ...string) func(r *http.Request) string {
+	var nets []*net.IPNet
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		if _, n, err := net.ParseCIDR(proxy); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return func(r *http.Request) string {
+		ip := remoteIP(r)
+		if addr := net.ParseIP(ip); addr != nil {
+			for _, n := range nets {
+				if n.Contains(addr) {
+					if realIP := RealIP(r); realIP != "" {
+						return realIP
+					}
+					break
+				}
+			}
+		}
+		return ip
+	}
+}
+
+// RateLimitByHeader keys rate limit by ID of the request header value e.g. API key,
+// of the keys by ID allowed. Falls back to key of fallback func if header absent or not allowed,
+// such that clients cannot bypass rate limit by arbitrary header values
+func RateLimitByHeader(name string, keys map[string]string, fallback func(r *http.Request) string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if value := r.Header.Get(name); value != "" {
+			for id, key := range keys {
+				if subtle.ConstantTimeCompare([]byte(value), []byte(key)) == 1 {
+					return name + ":" + id
+				}
+			}
+		}
+		return fallback(r)
+	}
+}
+
+// RateLimitBySigner keys rate limit by ID of the signing key that signed the URL,
+// e.g. secrets issued per client. Falls back to key of fallback func if URL not signed by any of the keys
+func RateLimitBySigner(signers map[string]imagorpath.Signer, fallback func(r *http.Request) string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if p := imagorpath.Parse(r.URL.EscapedPath()); p.Hash != "" {
+			for id, signer := range signers {
+				if subtle.ConstantTimeCompare([]byte(signer.Sign(p.Path)), []byte(p.Hash)) == 1 {
+					return "signer:" + id
+				}
+			}
+		}
+		return fallback(r)
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// rateLimit result of taking a token
+type rateLimit struct {
+	ok         bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// rateLimiter token buckets per client key,
+// refilled at rate tokens per second up to burst
+type rateLimiter struct {
+	rate  float64
+	burst int
+	max   int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		max:     maxBuckets,
+		buckets: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// take takes a token from bucket of the key
+func (l *rateLimiter) take(key string, now time.Time) (res rateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var b *bucket
+	if e, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*bucket)
+	} else {
+		if l.lru.Len() >= l.max {
+			e := l.lru.Back()
+			l.lru.Remove(e)
+			delete(l.buckets, e.Value.(*bucket).key)
+		}
+		b = &bucket{key: key, tokens: float64(l.burst), last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		res.ok = true
+	} else {
+		res.retryAfter = l.duration(1 - b.tokens)
+	}
+	res.limit = l.burst
+	res.remaining = int(b.tokens)
+	res.reset = l.duration(float64(l.burst) - b.tokens)
+	return
+}
+
+// sweep removes buckets refilled to full, which are equivalent to absent
+func (l *rateLimiter) sweep(now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for e := l.lru.Back(); e != nil; {
+		prev := e.Prev()
+		if b := e.Value.(*bucket); b.tokens+now.Sub(b.last).Seconds()*l.rate >= float64(l.burst) {
+			l.lru.Remove(e)
+			delete(l.buckets, b.key)
+		}
+		e = prev
+	}
+}
+
+// sweepRateLimits removes idle token buckets on interval until context done
+func (s *Server) sweepRateLimits(ctx context.Context) {
+	if s.requestLimiter == nil && s.processLimiter == nil {
+		return
+	}
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.requestLimiter.sweep(now)
+			s.processLimiter.sweep(now)
+		}
+	}
+}
+
+func (l *rateLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// setRateLimitHeaders sets RateLimit-* headers and Retry-After if limited
+func setRateLimitHeaders(h http.Header, res rateLimit) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.remaining))
+	h.Set("RateLimit-Reset", ceilSeconds(res.reset))
+	if !res.ok {
+		h.Set("Retry-After", ceilSeconds(res.retryAfter))
+	}
+}
+
+// rateLimitWriter sets headers of process rate limit upon response,
+// as process limit being checked while the response might be written concurrently
+type rateLimitWriter struct {
+	http.ResponseWriter
+	mu      sync.Mutex
+	res     *rateLimit
+	applied bool
+}
+
+func (w *rateLimitWriter) set(res rateLimit) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.applied {
+		w.res = &res
+	}
+}
+
+func (w *rateLimitWriter) apply() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.applied {
+		w.applied = true
+		if w.res != nil {
+			setRateLimitHeaders(w.ResponseWriter.Header(), *w.res)
+		}
+	}
+}
+
+func (w *rateLimitWriter) WriteHeader(status int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *rateLimitWriter) Write(b []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(b)
+}
+
+// rateLimitHandler limits requests per client key, with separate limit for image processing
+// such that cache hits are only counted against the request limit
+func (s *Server) rateLimitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.requestLimiter == nil && s.processLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := s.RateLimitKey(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.requestLimiter != nil {
+			res := s.requestLimiter.take(key, time.Now())
+			setRateLimitHeaders(w.Header(), res)
+			if !res.ok {
+				if s.Debug {
+					s.Logger.Debug("rate-limit", zap.String("key", key))
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(imagor.ErrTooManyRequests.Code)
+				writeJSON(w, r, errResp{
+					Message: imagor.ErrTooManyRequests.Message,
+					Code:    imagor.ErrTooManyRequests.Code,
+				})
+				return
+			}
+		}
+		if s.processLimiter != nil {
+			wr := &rateLimitWriter{ResponseWriter: w}
+			w = wr
+			r = r.WithContext(imagor.WithProcessLimit(r.Context(), func() error {
+				res := s.processLimiter.take(key, time.Now())
+				wr.set(res)
+				if !res.ok {
+					if s.Debug {
+						s.Logger.Debug("process-rate-limit", zap.String("key", key))
+					}
+					return imagor.ErrTooManyRequests
+				}
+				return nil
+			}))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
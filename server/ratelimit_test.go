@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type processorFunc func(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error)
+
+func (f processorFunc) Process(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error) {
+	return f(ctx, blob, p, load)
+}
+
+func (f processorFunc) Startup(_ context.Context) error {
+	return nil
+}
+
+func (f processorFunc) Shutdown(_ context.Context) error {
+	return nil
+}
+
+type resultStore struct {
+	blobs map[string]*imagor.Blob
+}
+
+func (s *resultStore) Get(r *http.Request, image string) (*imagor.Blob, error) {
+	if blob, ok := s.blobs[image]; ok {
+		return blob, nil
+	}
+	return nil, imagor.ErrNotFound
+}
+
+func (s *resultStore) Put(_ context.Context, image string, blob *imagor.Blob) error {
+	return nil
+}
+
+func (s *resultStore) Delete(_ context.Context, image string) error {
+	return nil
+}
+
+func (s *resultStore) Stat(_ context.Context, image string) (*imagor.Stat, error) {
+	return nil, imagor.ErrNotFound
+}
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0, 10))
+	l := newRateLimiter(2, 3)
+	now := time.Now()
+	for i := 2; i >= 0; i-- {
+		res := l.take("a", now)
+		assert.True(t, res.ok)
+		assert.Equal(t, 3, res.limit)
+		assert.Equal(t, i, res.remaining)
+	}
+	res := l.take("a", now)
+	assert.False(t, res.ok)
+	assert.Equal(t, time.Millisecond*500, res.retryAfter)
+	assert.Equal(t, time.Millisecond*1500, res.reset)
+	assert.True(t, l.take("b", now).ok, "bucket per key")
+
+	now = now.Add(time.Millisecond * 500)
+	assert.True(t, l.take("a", now).ok)
+	assert.False(t, l.take("a", now).ok)
+
+	assert.Len(t, l.buckets, 2)
+	now = now.Add(time.Minute)
+	l.take("c", now)
+	l.sweep(now)
+	assert.Len(t, l.buckets, 1, "should sweep refilled buckets")
+
+	l.max = 2
+	for i := 0; i < 3; i++ {
+		l.take("a", now)
+	}
+	l.take("d", now)
+	assert.Len(t, l.buckets, 2, "should cap buckets")
+	assert.NotContains(t, l.buckets, "c", "least recently used evicted")
+	assert.False(t, l.take("a", now).ok, "recently used kept")
+
+	assert.Equal(t, 1, newRateLimiter(0.5, 0).burst)
+	assert.Equal(t, 3, newRateLimiter(2.5, 0).burst)
+}
+
+func TestRateLimitKey(t *testing.T) {
+	newRequest := func(path, remoteAddr, forwardedFor string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return r
+	}
+	assert.Equal(t, "1.1.1.1", RateLimitByIP(newRequest("/", "1.1.1.1:1234", "8.8.8.8")), "forwarded header not trusted")
+
+	byRealIP := RateLimitByRealIP("10.0.0.0/8", " 192.168.1.1", "::1", "invalid")
+	assert.Equal(t, "8.8.8.8", byRealIP(newRequest("/", "10.1.2.3:1234", "8.8.8.8")))
+	assert.Equal(t, "8.8.8.8", byRealIP(newRequest("/", "192.168.1.1:1234", "8.8.8.8")))
+	assert.Equal(t, "8.8.8.8", byRealIP(newRequest("/", "[::1]:1234", "8.8.8.8")))
+	assert.Equal(t, "192.168.1.2", byRealIP(newRequest("/", "192.168.1.2:1234", "8.8.8.8")))
+	assert.Equal(t, "1.1.1.1", byRealIP(newRequest("/", "1.1.1.1:1234", "8.8.8.8")))
+	assert.Equal(t, "10.1.2.3", byRealIP(newRequest("/", "10.1.2.3:1234", "10.0.0.1")), "no public forwarded IP")
+
+	bySigner := RateLimitBySigner(map[string]imagorpath.Signer{
+		"foo": imagorpath.NewDefaultSigner("1234"),
+		"bar": imagorpath.NewDefaultSigner("5678"),
+	}, RateLimitByIP)
+	path := "100x100/foo.jpg"
+	assert.Equal(t, "signer:foo", bySigner(newRequest("/"+imagorpath.NewDefaultSigner("1234").Sign(path)+"/"+path, "1.1.1.1:1234", "")))
+	assert.Equal(t, "signer:bar", bySigner(newRequest("/"+imagorpath.NewDefaultSigner("5678").Sign(path)+"/"+path, "1.1.1.1:1234", "")))
+	assert.Equal(t, "1.1.1.1", bySigner(newRequest("/"+imagorpath.NewDefaultSigner("abcd").Sign(path)+"/"+path, "1.1.1.1:1234", "")))
+	assert.Equal(t, "1.1.1.1", bySigner(newRequest("/unsafe/"+path, "1.1.1.1:1234", "")))
+
+	byHeader := RateLimitByHeader("X-API-Key", map[string]string{"foo": "abc"}, RateLimitByIP)
+	r := newRequest("/", "1.1.1.1:1234", "")
+	assert.Equal(t, "1.1.1.1", byHeader(r))
+	r.Header.Set("X-API-Key", "abc")
+	assert.Equal(t, "X-API-Key:foo", byHeader(r))
+	r.Header.Set("X-API-Key", "xyz")
+	assert.Equal(t, "1.1.1.1", byHeader(r), "key not allowed")
+}
+
+func TestRateLimit(t *testing.T) {
+	var processed int32
+	s := New(
+		imagor.New(
+			imagor.WithUnsafe(true),
+			imagor.WithResultStorages(&resultStore{blobs: map[string]*imagor.Blob{
+				"cached.jpg": imagor.NewBlobFromBytes([]byte("cached")),
+			}}),
+			imagor.WithLoaders(loaderFunc(func(r *http.Request, image string) (*imagor.Blob, error) {
+				return imagor.NewBlobFromBytes([]byte("foo")), nil
+			})),
+			imagor.WithProcessors(processorFunc(func(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error) {
+				atomic.AddInt32(&processed, 1)
+				return imagor.NewBlobFromBytes([]byte("processed")), nil
+			})),
+		),
+		WithRateLimit(1, 4),
+		WithProcessRateLimit(0.1, 1),
+		WithRateLimitKey(RateLimitByHeader("X-API-Key", map[string]string{"foo": "abc"}, RateLimitByIP)),
+	)
+	serve := func(path, apiKey string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		s.Handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/unsafe/foo.jpg", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "processed", w.Body.String())
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "10", w.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	w = serve("/unsafe/bar.jpg", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "process limit exceeded")
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&processed))
+
+	for _, remaining := range []string{"1", "0"} {
+		w = serve("/unsafe/cached.jpg", "")
+		assert.Equal(t, 200, w.Code, "cache hits not limited by process limit")
+		assert.Equal(t, "cached", w.Body.String())
+		assert.Equal(t, "4", w.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, remaining, w.Header().Get("RateLimit-Remaining"))
+	}
+
+	w = serve("/unsafe/cached.jpg", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "request limit exceeded")
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"message":"too many requests","status":429}`, w.Body.String())
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	w = serve("/unsafe/foo.jpg", "xyz")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "api key not allowed keyed by ip")
+
+	w = serve("/unsafe/foo.jpg", "abc")
+	assert.Equal(t, 200, w.Code, "keyed by api key")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&processed))
+
+	w = serve("/healthcheck", "")
+	assert.Equal(t, 200, w.Code, "healthcheck not limited")
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+)
+
+// drainInterval interval of checking in-flight requests of App being swapped
+const drainInterval = time.Millisecond * 10
+
+// Reloader rebuilds App for reload
+type Reloader func() (Service, error)
+
+// Watcher watches for changes until context done, calling reload upon change
+type Watcher func(ctx context.Context, reload func())
+
+// SignalWatcher watches for signals e.g. SIGHUP
+func SignalWatcher(signals ...os.Signal) Watcher {
+	return func(ctx context.Context, reload func()) {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, signals...)
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+				reload()
+			}
+		}
+	}
+}
+
+// FileWatcher watches for modified time changes of files by polling at interval
+func FileWatcher(interval time.Duration, files ...string) Watcher {
+	modTimes := func() (times []time.Time) {
+		for _, file := range files {
+			var t time.Time
+			if stat, err := os.Stat(file); err == nil {
+				t = stat.ModTime()
+			}
+			times = append(times, t)
+		}
+		return
+	}
+	return func(ctx context.Context, reload func()) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := modTimes()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if times := modTimes(); !equalTimes(times, last) {
+					last = times
+					reload()
+				}
+			}
+		}
+	}
+}
+
+func equalTimes(a, b []time.Time) bool {
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// appRef App of the server with in-flight requests counted
+type appRef struct {
+	app      Service
+	inflight int64
+}
+
+// serveApp serves the current App, counting in-flight requests such that
+// App being swapped out by reload is shutdown only after in-flight requests finished
+func (s *Server) serveApp(w http.ResponseWriter, r *http.Request) {
+	var ref *appRef
+	for {
+		ref = s.app.Load().(*appRef)
+		atomic.AddInt64(&ref.inflight, 1)
+		if s.app.Load().(*appRef) == ref {
+			break
+		}
+		// swapped in between, retry with the new App
+		atomic.AddInt64(&ref.inflight, -1)
+	}
+	defer atomic.AddInt64(&ref.inflight, -1)
+	ref.app.ServeHTTP(w, r)
+}
+
+// Reload rebuilds App by Reloader and swaps it in atomically.
+// The new App is started up before serving, and the old App is shutdown
+// after its in-flight requests finished
+func (s *Server) Reload(ctx context.Context) error {
+	if s.Reloader == nil {
+		return errors.New("reloader not configured")
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	app, err := s.Reloader()
+	if err != nil {
+		return err
+	}
+	startupCtx, cancel := context.WithTimeout(ctx, s.StartupTimeout)
+	defer cancel()
+	if err = app.Startup(startupCtx); err != nil {
+		// release what the new App started up, e.g. processors and retry queue
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		defer closeCancel()
+		if e := app.Shutdown(closeCtx); e != nil {
+			s.Logger.Warn("reload-startup-shutdown", zap.Error(e))
+		}
+		return err
+	}
+	old := s.app.Swap(&appRef{app: app}).(*appRef)
+	s.App = app
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, s.ShutdownTimeout)
+	defer cancel()
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&old.inflight) > 0 {
+		select {
+		case <-shutdownCtx.Done():
+			s.Logger.Warn("reload-drain", zap.Int64("inflight", atomic.LoadInt64(&old.inflight)),
+				zap.Error(shutdownCtx.Err()))
+			// bounded, such that stuck App does not block later reloads and shutdown
+			forceCtx, forceCancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+			defer forceCancel()
+			return old.app.Shutdown(forceCtx)
+		case <-ticker.C:
+		}
+	}
+	return old.app.Shutdown(shutdownCtx)
+}
+
+// watch runs reload watchers until context done
+func (s *Server) watch(ctx context.Context) {
+	if s.Reloader == nil {
+		return
+	}
+	for _, watcher := range s.Watchers {
+		go watcher(ctx, func() {
+			s.Logger.Info("reload")
+			if err := s.Reload(ctx); err != nil {
+				s.Logger.Error("reload", zap.Error(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type reloadApp struct {
+	name       string
+	started    int32
+	shutdown   int32
+	block      chan struct{}
+	startupErr error
+	stuck      bool
+}
+
+func (app *reloadApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if app.block != nil {
+		<-app.block
+	}
+	if atomic.LoadInt32(&app.shutdown) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte(app.name))
+}
+
+func (app *reloadApp) Startup(_ context.Context) error {
+	atomic.AddInt32(&app.started, 1)
+	return app.startupErr
+}
+
+func (app *reloadApp) Shutdown(ctx context.Context) error {
+	atomic.AddInt32(&app.shutdown, 1)
+	if app.stuck {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func TestReload(t *testing.T) {
+	var next *reloadApp
+	var reloadErr error
+	old := &reloadApp{name: "old", block: make(chan struct{})}
+	s := New(old, WithReload(func() (Service, error) {
+		return next, reloadErr
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo.jpg", nil))
+		return w
+	}
+	assert.Error(t, New(old).Reload(context.Background()), "reloader not configured")
+
+	reloadErr = errors.New("invalid config")
+	assert.Equal(t, reloadErr, s.Reload(context.Background()))
+	assert.Same(t, old, s.App)
+	reloadErr = nil
+
+	next = &reloadApp{name: "failed", startupErr: errors.New("startup failed")}
+	assert.Equal(t, next.startupErr, s.Reload(context.Background()))
+	assert.Same(t, old, s.App)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&next.shutdown), "failed app shutdown")
+
+	inflight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		inflight <- serve()
+	}()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&s.app.Load().(*appRef).inflight) == 1
+	}, time.Second, time.Millisecond)
+
+	next = &reloadApp{name: "new"}
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- s.Reload(context.Background())
+	}()
+	assert.Eventually(t, func() bool {
+		return serve().Body.String() == "new"
+	}, time.Second, time.Millisecond, "should swap to new app")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&next.started))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&old.shutdown), "old app not shutdown until in-flight finished")
+
+	close(old.block)
+	w := <-inflight
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "old", w.Body.String(), "in-flight request finished on old app")
+	assert.NoError(t, <-reloaded)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&old.shutdown))
+	assert.Same(t, next, s.App)
+
+	s.shutdown(context.Background())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&next.shutdown), "shutdown current app")
+}
+
+func TestReloadDrainTimeout(t *testing.T) {
+	old := &reloadApp{name: "old", block: make(chan struct{}), stuck: true}
+	defer close(old.block)
+	next := &reloadApp{name: "new"}
+	s := New(old, WithShutdownTimeout(time.Millisecond*50), WithReload(func() (Service, error) {
+		return next, nil
+	}))
+	go s.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo.jpg", nil))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&s.app.Load().(*appRef).inflight) == 1
+	}, time.Second, time.Millisecond)
+
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- s.Reload(context.Background())
+	}()
+	select {
+	case err := <-reloaded:
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "stuck app shutdown bounded by timeout")
+	case <-time.After(time.Second):
+		t.Fatal("reload blocked by stuck app")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&old.shutdown))
+	assert.Same(t, next, s.App)
+}
+
+func TestWatchers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.env")
+	require.NoError(t, os.WriteFile(file, []byte("FOO=1"), 0644))
+
+	var reloads int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go FileWatcher(time.Millisecond*5, file, filepath.Join(t.TempDir(), "missing.env"))(ctx, func() {
+		atomic.AddInt32(&reloads, 1)
+	})
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&reloads))
+
+	require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Second)))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&reloads) == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, os.Remove(file))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&reloads) == 2
+	}, time.Second, time.Millisecond, "should reload on removal")
+}
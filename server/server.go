@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os/signal"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -24,36 +26,52 @@ type Service interface {
 // Server wraps the Service with additional http and app lifecycle handling
 type Server struct {
 	http.Server
-	App             Service
-	Address         string
-	Port            int
-	CertFile        string
-	KeyFile         string
-	PathPrefix      string
-	StartupTimeout  time.Duration
-	ShutdownTimeout time.Duration
-	Logger          *zap.Logger
-	Debug           bool
+	App                   Service
+	Address               string
+	Port                  int
+	CertFile              string
+	KeyFile               string
+	PathPrefix            string
+	StartupTimeout        time.Duration
+	ShutdownTimeout       time.Duration
+	RateLimit             float64
+	RateLimitBurst        int
+	ProcessRateLimit      float64
+	ProcessRateLimitBurst int
+	RateLimitKey          func(r *http.Request) string
+	Reloader              Reloader
+	Watchers              []Watcher
+	Logger                *zap.Logger
+	Debug                 bool
+
+	requestLimiter *rateLimiter
+	processLimiter *rateLimiter
+	app            atomic.Value
+	reloadMu       sync.Mutex
 }
 
 // New create new Server
 func New(app Service, options ...Option) *Server {
 	s := &Server{}
 	s.App = app
+	s.app.Store(&appRef{app: app})
 	s.Port = 8000
 	s.MaxHeaderBytes = 1 << 20
 	s.StartupTimeout = time.Second * 10
 	s.ShutdownTimeout = time.Second * 10
 	s.Logger = zap.NewNop()
+	s.RateLimitKey = RateLimitByIP
 
 	s.Handler = pathHandler(http.MethodGet, map[string]http.HandlerFunc{
 		"/favicon.ico": handleOk,
 		"/healthcheck": handleOk,
-	})(s.App)
+	})(s.rateLimitHandler(http.HandlerFunc(s.serveApp)))
 
 	for _, option := range options {
 		option(s)
 	}
+	s.requestLimiter = newRateLimiter(s.RateLimit, s.RateLimitBurst)
+	s.processLimiter = newRateLimiter(s.ProcessRateLimit, s.ProcessRateLimitBurst)
 	if s.PathPrefix != "" {
 		s.Handler = http.StripPrefix(s.PathPrefix, s.Handler)
 	}
@@ -73,6 +91,8 @@ func (s *Server) Run() {
 
 func (s *Server) RunContext(ctx context.Context) {
 	s.startup(ctx)
+	s.watch(ctx)
+	go s.sweepRateLimits(ctx)
 
 	go func() {
 		if err := s.listenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -88,7 +108,7 @@ func (s *Server) RunContext(ctx context.Context) {
 func (s *Server) startup(ctx context.Context) {
 	ctx, cancel := context.WithTimeout(ctx, s.StartupTimeout)
 	defer cancel()
-	if err := s.App.Startup(ctx); err != nil {
+	if err := s.app.Load().(*appRef).app.Startup(ctx); err != nil {
 		s.Logger.Fatal("app-startup", zap.Error(err))
 	}
 }
@@ -100,7 +120,10 @@ func (s *Server) shutdown(ctx context.Context) {
 	if err := s.Shutdown(ctx); err != nil {
 		s.Logger.Error("server-shutdown", zap.Error(err))
 	}
-	if err := s.App.Shutdown(ctx); err != nil {
+	// wait for reload in progress
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	if err := s.app.Load().(*appRef).app.Shutdown(ctx); err != nil {
 		s.Logger.Error("app-shutdown", zap.Error(err))
 	}
 }
@@ -151,3 +151,19 @@ func TestWithPathPrefix(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	fmt.Println(w.Body.String())
 }
+
+func TestWithMetrics(t *testing.T) {
+	s := New(imagor.New(),
+		WithMetrics("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("foo 1"))
+		})),
+		WithPathPrefix("/imagor"))
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/imagor/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "foo 1", w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/imagor/metrics/foo", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,107 @@
+package imagor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cshum/imagor/imagorpath"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// staleWarning Warning header of stale response
+const staleWarning = `110 - "Response is Stale"`
+
+type staleKey struct{}
+
+type refreshKey struct{}
+
+// AllowStale returns context that allows Storage to return expired image,
+// as readable blob along with ErrExpired
+func AllowStale(ctx context.Context) context.Context {
+	return context.WithValue(ctx, staleKey{}, true)
+}
+
+// IsStaleAllowed returns if context allows Storage to return expired image
+func IsStaleAllowed(ctx context.Context) bool {
+	v, _ := ctx.Value(staleKey{}).(bool)
+	return v
+}
+
+func isRefresh(ctx context.Context) bool {
+	v, _ := ctx.Value(refreshKey{}).(bool)
+	return v
+}
+
+// shouldServeStale returns true if error of fresh result is eligible for serving stale result,
+// i.e. timeout, overload or server error
+func shouldServeStale(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	e := WrapError(err)
+	return e.Timeout() || e.Code == http.StatusTooManyRequests || e.Code >= http.StatusInternalServerError
+}
+
+// serveStale returns stale result in place of failed fresh result if eligible,
+// and refreshes the result in background
+func (app *Imagor) serveStale(
+	r *http.Request, p imagorpath.Params, resultKey string, stale, blob *Blob, err error,
+) (*Blob, error) {
+	if !shouldServeStale(err) {
+		if reader, _, _ := stale.NewReader(); reader != nil {
+			_ = reader.Close()
+		}
+		return blob, err
+	}
+	// stale blob carries ErrExpired if expired
+	buf, _ := stale.ReadAll()
+	if len(buf) == 0 {
+		return blob, err
+	}
+	b := NewBlobFromBytes(buf)
+	b.SetContentType(stale.ContentType())
+	b.stale = true
+	app.Logger.Warn("stale", zap.String("key", resultKey), zap.Error(err))
+	if !isRefresh(r.Context()) {
+		app.background.goFunc(func() {
+			app.refresh(r, p, resultKey)
+		})
+	}
+	return b, nil
+}
+
+// refresh reprocesses result in background, detached from the request
+func (app *Imagor) refresh(r *http.Request, p imagorpath.Params, resultKey string) {
+	_, _, _ = app.g.Do("refresh:"+resultKey, func() (interface{}, error) {
+		ctx := DetachContext(r.Context())
+		ctx = context.WithValue(ctx, refreshKey{}, true)
+		// skip filling variant of the request already served
+		ctx = withVariant(ctx, nil)
+		blob, err := checkBlob(app.Do(r.Clone(ctx), p))
+		if err != nil || (blob != nil && blob.stale) {
+			app.Logger.Warn("refresh", zap.String("key", resultKey), zap.Error(err))
+		} else if app.Debug {
+			app.Logger.Debug("refreshed", zap.String("key", resultKey))
+		}
+		return nil, err
+	})
+}
+
+// setStaleHeaders sets response headers of stale result
+func setStaleHeaders(w http.ResponseWriter, staleIfError time.Duration) {
+	w.Header().Set("Expires", strings.Replace(time.Now().Format(time.RFC1123), "UTC", "GMT", -1))
+	w.Header().Set("Cache-Control", fmt.Sprintf(
+		"public, max-age=0, stale-if-error=%d", int64(staleIfError.Seconds())))
+	w.Header().Set("Warning", staleWarning)
+}
+
+// setStaleIfErrorHeader adds stale-if-error directive to public Cache-Control header
+func setStaleIfErrorHeader(w http.ResponseWriter, staleIfError time.Duration) {
+	if cc := w.Header().Get("Cache-Control"); strings.HasPrefix(cc, "public") {
+		w.Header().Set("Cache-Control", fmt.Sprintf(
+			"%s, stale-if-error=%d", cc, int64(staleIfError.Seconds())))
+	}
+}
@@ -0,0 +1,151 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// expiredStore result storage with all images expired
+type expiredStore struct {
+	*mapStore
+}
+
+func (s expiredStore) Get(r *http.Request, image string) (*Blob, error) {
+	blob, err := s.mapStore.Get(r, image)
+	if err != nil {
+		return nil, err
+	}
+	if IsStaleAllowed(r.Context()) {
+		return blob, ErrExpired
+	}
+	return nil, ErrExpired
+}
+
+func TestWithStaleIfError(t *testing.T) {
+	var failing int32
+	var calls int32
+	loader := WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+		if image == "missing.jpg" {
+			return nil, ErrNotFound
+		}
+		return NewBlobFromBytes([]byte("foo")), nil
+	}))
+	processor := WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			return nil, ErrTimeout
+		}
+		return NewBlobFromBytes([]byte("fresh")), nil
+	}))
+	serve := func(app *Imagor, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil))
+		return w
+	}
+
+	t.Run("expired result", func(t *testing.T) {
+		atomic.StoreInt32(&failing, 0)
+		resultStore := newMapStore()
+		app := New(WithUnsafe(true), WithStaleIfError(time.Hour),
+			WithResultStorages(expiredStore{resultStore}), loader, processor)
+		assert.NoError(t, resultStore.Put(context.Background(), "foo.jpg", NewBlobFromBytes([]byte("stale"))))
+		assert.NoError(t, resultStore.Put(context.Background(), "missing.jpg", NewBlobFromBytes([]byte("stale"))))
+
+		w := serve(app, "/unsafe/foo.jpg")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "fresh", w.Body.String(), "fresh result preferred over stale")
+		assert.Empty(t, w.Header().Get("Warning"))
+		assert.Equal(t, "public, s-maxage=604800, max-age=604800, no-transform, stale-while-revalidate=86400, stale-if-error=3600", w.Header().Get("Cache-Control"))
+		assert.Eventually(t, func() bool {
+			resultStore.l.Lock()
+			defer resultStore.l.Unlock()
+			return resultStore.SaveCnt["foo.jpg"] == 2
+		}, time.Second, time.Millisecond)
+
+		atomic.StoreInt32(&failing, 1)
+		w = serve(app, "/unsafe/foo.jpg")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "fresh", w.Body.String(), "serves stale result")
+		assert.Equal(t, staleWarning, w.Header().Get("Warning"))
+		assert.Equal(t, "public, max-age=0, stale-if-error=3600", w.Header().Get("Cache-Control"))
+		assert.Empty(t, w.Header().Get("ETag"))
+
+		w = serve(app, "/unsafe/missing.jpg")
+		assert.Equal(t, 404, w.Code, "stale not served on not found")
+		assert.Eventually(t, func() bool {
+			return atomic.LoadInt32(&calls) == 3
+		}, time.Second, time.Millisecond, "should attempt refresh")
+	})
+
+	t.Run("modified time check with background refresh", func(t *testing.T) {
+		atomic.StoreInt32(&failing, 1)
+		atomic.StoreInt32(&calls, 0)
+		store := newMapStore()
+		resultStore := newMapStore()
+		app := New(WithUnsafe(true), WithStaleIfError(time.Hour), WithModifiedTimeCheck(true),
+			WithStorages(store), WithResultStorages(resultStore), loader, processor)
+		assert.NoError(t, resultStore.Put(context.Background(), "foo.jpg", NewBlobFromBytes([]byte("stale"))))
+		assert.NoError(t, store.Put(context.Background(), "foo.jpg", NewBlobFromBytes([]byte("foo"))))
+
+		w := serve(app, "/unsafe/foo.jpg")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "stale", w.Body.String())
+		assert.Equal(t, staleWarning, w.Header().Get("Warning"))
+		assert.Eventually(t, func() bool {
+			return atomic.LoadInt32(&calls) == 2
+		}, time.Second, time.Millisecond, "should attempt refresh")
+
+		atomic.StoreInt32(&failing, 0)
+		w = serve(app, "/unsafe/foo.jpg")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "fresh", w.Body.String())
+		assert.Empty(t, w.Header().Get("Warning"))
+		assert.Eventually(t, func() bool {
+			resultStore.l.Lock()
+			defer resultStore.l.Unlock()
+			return resultStore.SaveCnt["foo.jpg"] == 2
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("refresh in background", func(t *testing.T) {
+		var processed int32
+		store := newMapStore()
+		resultStore := newMapStore()
+		var slow int32 = 1
+		app := New(
+			WithUnsafe(true),
+			WithStaleIfError(time.Hour),
+			WithModifiedTimeCheck(true),
+			WithProcessTimeout(time.Millisecond*10),
+			WithStorages(store),
+			WithResultStorages(resultStore),
+			WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				if atomic.CompareAndSwapInt32(&slow, 1, 0) {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				atomic.AddInt32(&processed, 1)
+				return NewBlobFromBytes([]byte("fresh")), nil
+			})),
+		)
+		assert.NoError(t, resultStore.Put(context.Background(), "foo.jpg", NewBlobFromBytes([]byte("stale"))))
+		assert.NoError(t, store.Put(context.Background(), "foo.jpg", NewBlobFromBytes([]byte("foo"))))
+
+		w := serve(app, "/unsafe/foo.jpg")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "stale", w.Body.String(), "serves stale on process timeout")
+		assert.Eventually(t, func() bool {
+			return atomic.LoadInt32(&processed) == 1
+		}, time.Second, time.Millisecond, "should refresh in background")
+		assert.Eventually(t, func() bool {
+			return serve(app, "/unsafe/foo.jpg").Body.String() == "fresh"
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&processed), "refreshed result should be saved")
+	})
+}
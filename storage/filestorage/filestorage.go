@@ -56,17 +56,27 @@ func (s *FileStorage) Path(image string) (string, bool) {
 	return filepath.Join(s.BaseDir, strings.TrimPrefix(image, s.PathPrefix)), true
 }
 
-func (s *FileStorage) Get(_ *http.Request, image string) (*imagor.Blob, error) {
+func (s *FileStorage) Get(r *http.Request, image string) (*imagor.Blob, error) {
 	image, ok := s.Path(image)
 	if !ok {
 		return nil, imagor.ErrInvalid
 	}
-	return imagor.NewBlobFromFile(image, func(stats os.FileInfo) error {
+	var expired bool
+	blob := imagor.NewBlobFromFile(image, func(stats os.FileInfo) error {
 		if s.Expiration > 0 && time.Now().Sub(stats.ModTime()) > s.Expiration {
+			if r != nil && imagor.IsStaleAllowed(r.Context()) {
+				// stale image readable along with expired error
+				expired = true
+				return nil
+			}
 			return imagor.ErrExpired
 		}
 		return nil
-	}), nil
+	})
+	if expired {
+		return blob, imagor.ErrExpired
+	}
+	return blob, nil
 }
 
 func (s *FileStorage) Put(_ context.Context, image string, blob *imagor.Blob) (err error) {
@@ -126,3 +136,15 @@ func (s *FileStorage) Stat(_ context.Context, image string) (stat *imagor.Stat,
 		ModifiedTime: stats.ModTime(),
 	}, nil
 }
+
+// DeletePrefix deletes all images under the directory of prefix
+func (s *FileStorage) DeletePrefix(_ context.Context, prefix string) error {
+	if strings.Trim(prefix, "/") == "" {
+		return imagor.ErrInvalid
+	}
+	dir, ok := s.Path(prefix)
+	if !ok || dir == filepath.Clean(s.BaseDir) {
+		return imagor.ErrInvalid
+	}
+	return os.RemoveAll(dir)
+}
@@ -183,6 +183,23 @@ func TestFileStorage_Load_Save(t *testing.T) {
 		assert.Equal(t, "bar", string(buf))
 	})
 
+	t.Run("delete prefix", func(t *testing.T) {
+		s := New(dir)
+		require.NoError(t, s.Put(ctx, "/abc/def/1", imagor.NewBlobFromBytes([]byte("1"))))
+		require.NoError(t, s.Put(ctx, "/abc/def/2", imagor.NewBlobFromBytes([]byte("2"))))
+		require.NoError(t, s.Put(ctx, "/abc/defg/3", imagor.NewBlobFromBytes([]byte("3"))))
+		assert.Equal(t, imagor.ErrInvalid, s.DeletePrefix(ctx, "/"))
+		assert.Equal(t, imagor.ErrInvalid, s.DeletePrefix(ctx, "/.git/"))
+		require.NoError(t, s.DeletePrefix(ctx, "abc/def/"))
+		_, err = s.Stat(ctx, "/abc/def/1")
+		assert.Equal(t, imagor.ErrNotFound, err)
+		_, err = s.Stat(ctx, "/abc/def/2")
+		assert.Equal(t, imagor.ErrNotFound, err)
+		_, err = s.Stat(ctx, "/abc/defg/3")
+		assert.NoError(t, err)
+		require.NoError(t, s.DeletePrefix(ctx, "abc/def/"))
+	})
+
 	t.Run("expiration", func(t *testing.T) {
 		s := New(dir, WithExpiration(time.Millisecond*10))
 		var err error
@@ -200,6 +217,13 @@ func TestFileStorage_Load_Save(t *testing.T) {
 		time.Sleep(time.Second)
 		_, err = checkBlob(s.Get(&http.Request{}, "/foo/bar/asdf"))
 		require.ErrorIs(t, err, imagor.ErrExpired)
+
+		r := (&http.Request{}).WithContext(imagor.AllowStale(context.Background()))
+		b, err = checkBlob(s.Get(r, "/foo/bar/asdf"))
+		require.ErrorIs(t, err, imagor.ErrExpired)
+		buf, err = b.ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, "bar", string(buf), "stale image readable")
 	})
 }
 
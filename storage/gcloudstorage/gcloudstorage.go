@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/cshum/imagor"
 	"github.com/cshum/imagor/imagorpath"
+	"google.golang.org/api/iterator"
 	"io"
 	"net/http"
 	"path/filepath"
@@ -49,16 +50,27 @@ func (s *GCloudStorage) Get(r *http.Request, image string) (imageData *imagor.Bl
 	}
 	if s.Expiration > 0 {
 		if attrs != nil && time.Now().Sub(attrs.Updated) > s.Expiration {
-			return nil, imagor.ErrExpired
+			if !imagor.IsStaleAllowed(r.Context()) {
+				return nil, imagor.ErrExpired
+			}
+			// stale image readable along with expired error
+			err = imagor.ErrExpired
 		}
 	}
-	return imagor.NewBlob(func() (reader io.ReadCloser, size int64, err error) {
+	blob := imagor.NewBlob(func() (reader io.ReadCloser, size int64, err error) {
 		if attrs != nil {
 			size = attrs.Size
 		}
 		reader, err = object.NewReader(r.Context())
 		return
-	}), err
+	})
+	if attrs != nil {
+		blob.SetStat(&imagor.Stat{
+			Size:         attrs.Size,
+			ModifiedTime: attrs.Updated,
+		})
+	}
+	return blob, err
 }
 
 func (s *GCloudStorage) Put(ctx context.Context, image string, blob *imagor.Blob) (err error) {
@@ -131,3 +143,29 @@ func (s *GCloudStorage) Stat(ctx context.Context, image string) (stat *imagor.St
 		ModifiedTime: attrs.Updated,
 	}, nil
 }
+
+// DeletePrefix deletes all objects under the directory of prefix
+func (s *GCloudStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	if strings.Trim(prefix, "/") == "" {
+		return imagor.ErrInvalid
+	}
+	dir, ok := s.Path(prefix)
+	if !ok {
+		return imagor.ErrInvalid
+	}
+	bucket := s.client.Bucket(s.Bucket)
+	it := bucket.Objects(ctx, &storage.Query{Prefix: dir + "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = bucket.Object(attrs.Name).Delete(ctx); err != nil &&
+			!errors.Is(err, storage.ErrObjectNotExist) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package memorystorage
+
+import (
+	"container/list"
+	"context"
+	"github.com/cshum/imagor"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage in-memory imagor.Storage bounded by total bytes size,
+// evicting the least recently used images when exceeded
+type MemoryStorage struct {
+	// MaxSize maximum total bytes of images held in memory
+	MaxSize int64
+
+	// Expiration duration of image since saved, or since modified of images promoted. Default no expiration
+	Expiration time.Duration
+
+	l     sync.Mutex
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type entry struct {
+	key          string
+	buf          []byte
+	contentType  string
+	modifiedTime time.Time
+}
+
+func New(options ...Option) *MemoryStorage {
+	s := &MemoryStorage{
+		MaxSize: 64 << 20,
+		ll:      list.New(),
+		items:   map[string]*list.Element{},
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func (s *MemoryStorage) Get(r *http.Request, image string) (*imagor.Blob, error) {
+	e, err := s.get(image, true, r != nil && imagor.IsStaleAllowed(r.Context()))
+	if e == nil {
+		return nil, err
+	}
+	blob := imagor.NewBlobFromBytes(e.buf)
+	blob.SetContentType(e.contentType)
+	blob.SetStat(&imagor.Stat{
+		Size:         int64(len(e.buf)),
+		ModifiedTime: e.modifiedTime,
+	})
+	return blob, err
+}
+
+// Put saves image into memory. Image exceeding MaxSize is not saved
+func (s *MemoryStorage) Put(_ context.Context, image string, blob *imagor.Blob) error {
+	return s.put(image, blob, time.Now())
+}
+
+// Promote saves result hit of result storages behind, as hot tier in front of them.
+// Modified time of the result is retained, such that Stat of the hot tier stays the same
+func (s *MemoryStorage) Promote(_ context.Context, image string, blob *imagor.Blob) error {
+	modifiedTime := time.Now()
+	if stat := blob.Stat(); stat != nil && !stat.ModifiedTime.IsZero() {
+		modifiedTime = stat.ModifiedTime
+	}
+	return s.put(image, blob, modifiedTime)
+}
+
+func (s *MemoryStorage) put(image string, blob *imagor.Blob, modifiedTime time.Time) error {
+	buf, err := blob.ReadAll()
+	if err != nil {
+		return err
+	}
+	e := &entry{
+		key:          image,
+		buf:          buf,
+		contentType:  blob.ContentType(),
+		modifiedTime: modifiedTime,
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	if elem, ok := s.items[image]; ok {
+		s.remove(elem)
+	}
+	if int64(len(buf)) > s.MaxSize {
+		return nil
+	}
+	s.items[image] = s.ll.PushFront(e)
+	s.size += int64(len(buf))
+	for s.size > s.MaxSize {
+		s.remove(s.ll.Back())
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Delete(_ context.Context, image string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if elem, ok := s.items[image]; ok {
+		s.remove(elem)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Stat(_ context.Context, image string) (*imagor.Stat, error) {
+	e, err := s.get(image, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return &imagor.Stat{
+		Size:         int64(len(e.buf)),
+		ModifiedTime: e.modifiedTime,
+	}, nil
+}
+
+// Size returns total bytes of images held in memory
+func (s *MemoryStorage) Size() int64 {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.size
+}
+
+// get returns entry of image.
+// Expired entry is kept and returned along with ErrExpired if stale allowed
+func (s *MemoryStorage) get(image string, touch, stale bool) (*entry, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	elem, ok := s.items[image]
+	if !ok {
+		return nil, imagor.ErrNotFound
+	}
+	e := elem.Value.(*entry)
+	if s.Expiration > 0 && time.Now().Sub(e.modifiedTime) > s.Expiration {
+		if stale {
+			return e, imagor.ErrExpired
+		}
+		s.remove(elem)
+		return nil, imagor.ErrExpired
+	}
+	if touch {
+		s.ll.MoveToFront(elem)
+	}
+	return e, nil
+}
+
+func (s *MemoryStorage) remove(elem *list.Element) {
+	e := s.ll.Remove(elem).(*entry)
+	delete(s.items, e.key)
+	s.size -= int64(len(e.buf))
+}
+
+// DeletePrefix deletes all images with key prefix
+func (s *MemoryStorage) DeletePrefix(_ context.Context, prefix string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for key, elem := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			s.remove(elem)
+		}
+	}
+	return nil
+}
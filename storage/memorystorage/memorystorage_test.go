@@ -0,0 +1,205 @@
+package memorystorage
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorage_Load_Save(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("CRUD", func(t *testing.T) {
+		s := New()
+
+		_, err := s.Get(&http.Request{}, "/foo/fooo/asdf")
+		assert.Equal(t, imagor.ErrNotFound, err)
+
+		_, err = s.Stat(ctx, "/foo/fooo/asdf")
+		assert.Equal(t, imagor.ErrNotFound, err)
+
+		blob := imagor.NewBlobFromBytes([]byte("bar"))
+		blob.SetContentType("image/png")
+		require.NoError(t, s.Put(ctx, "/foo/fooo/asdf", blob))
+
+		b, err := s.Get(&http.Request{}, "/foo/fooo/asdf")
+		require.NoError(t, err)
+		buf, err := b.ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, "bar", string(buf))
+		assert.Equal(t, "image/png", b.ContentType())
+
+		stat, err := s.Stat(ctx, "/foo/fooo/asdf")
+		require.NoError(t, err)
+		assert.Equal(t, stat.ModifiedTime.UnixNano(), b.Stat().ModifiedTime.UnixNano())
+		assert.Equal(t, int64(3), stat.Size)
+		assert.False(t, stat.ModifiedTime.After(time.Now()))
+		assert.Equal(t, int64(3), s.Size())
+
+		require.NoError(t, s.Delete(ctx, "/foo/fooo/asdf"))
+		_, err = s.Get(&http.Request{}, "/foo/fooo/asdf")
+		assert.Equal(t, imagor.ErrNotFound, err)
+		assert.Equal(t, int64(0), s.Size())
+	})
+
+	t.Run("lru eviction", func(t *testing.T) {
+		s := New(WithMaxSize(10))
+		require.NoError(t, s.Put(ctx, "a", imagor.NewBlobFromBytes([]byte("aaaa"))))
+		require.NoError(t, s.Put(ctx, "b", imagor.NewBlobFromBytes([]byte("bbbb"))))
+		_, err := s.Get(&http.Request{}, "a")
+		require.NoError(t, err)
+		require.NoError(t, s.Put(ctx, "c", imagor.NewBlobFromBytes([]byte("cccc"))))
+		assert.Equal(t, int64(8), s.Size())
+
+		_, err = s.Get(&http.Request{}, "b")
+		assert.Equal(t, imagor.ErrNotFound, err)
+		_, err = s.Get(&http.Request{}, "a")
+		assert.NoError(t, err)
+		_, err = s.Get(&http.Request{}, "c")
+		assert.NoError(t, err)
+
+		// overwrite replaces size
+		require.NoError(t, s.Put(ctx, "c", imagor.NewBlobFromBytes([]byte("cc"))))
+		assert.Equal(t, int64(6), s.Size())
+
+		// image exceeding max size is not saved
+		require.NoError(t, s.Put(ctx, "d", imagor.NewBlobFromBytes([]byte("ddddddddddd"))))
+		_, err = s.Get(&http.Request{}, "d")
+		assert.Equal(t, imagor.ErrNotFound, err)
+		assert.Equal(t, int64(6), s.Size())
+	})
+
+	t.Run("delete prefix", func(t *testing.T) {
+		s := New()
+		require.NoError(t, s.Put(ctx, "abc/1", imagor.NewBlobFromBytes([]byte("1"))))
+		require.NoError(t, s.Put(ctx, "abc/2", imagor.NewBlobFromBytes([]byte("2"))))
+		require.NoError(t, s.Put(ctx, "abd/3", imagor.NewBlobFromBytes([]byte("3"))))
+		require.NoError(t, s.DeletePrefix(ctx, "abc/"))
+		_, err := s.Get(&http.Request{}, "abc/1")
+		assert.Equal(t, imagor.ErrNotFound, err)
+		_, err = s.Get(&http.Request{}, "abd/3")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), s.Size())
+	})
+
+	t.Run("expiration", func(t *testing.T) {
+		s := New(WithExpiration(time.Millisecond * 10))
+		require.NoError(t, s.Put(ctx, "/foo/bar/asdf", imagor.NewBlobFromBytes([]byte("bar"))))
+		b, err := s.Get(&http.Request{}, "/foo/bar/asdf")
+		require.NoError(t, err)
+		buf, err := b.ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, "bar", string(buf))
+
+		time.Sleep(time.Millisecond * 20)
+		r := (&http.Request{}).WithContext(imagor.AllowStale(context.Background()))
+		b, err = s.Get(r, "/foo/bar/asdf")
+		assert.ErrorIs(t, err, imagor.ErrExpired)
+		buf, err = b.ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, "bar", string(buf), "stale image readable")
+
+		_, err = s.Get(&http.Request{}, "/foo/bar/asdf")
+		assert.ErrorIs(t, err, imagor.ErrExpired)
+		_, err = s.Stat(ctx, "/foo/bar/asdf")
+		assert.ErrorIs(t, err, imagor.ErrNotFound)
+		assert.Equal(t, int64(0), s.Size())
+	})
+}
+
+type loaderFunc func(r *http.Request, image string) (blob *imagor.Blob, err error)
+
+func (f loaderFunc) Get(r *http.Request, image string) (*imagor.Blob, error) {
+	return f(r, image)
+}
+
+type processorFunc func(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error)
+
+func (f processorFunc) Process(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error) {
+	return f(ctx, blob, p, load)
+}
+func (f processorFunc) Startup(_ context.Context) error {
+	return nil
+}
+func (f processorFunc) Shutdown(_ context.Context) error {
+	return nil
+}
+
+func TestResultStorage(t *testing.T) {
+	var processCnt int
+	s := New()
+	app := imagor.New(
+		imagor.WithUnsafe(true),
+		imagor.WithResultStorages(s),
+		imagor.WithLoaders(loaderFunc(func(r *http.Request, image string) (*imagor.Blob, error) {
+			return imagor.NewBlobFromBytes([]byte(image)), nil
+		})),
+		imagor.WithProcessors(processorFunc(func(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error) {
+			processCnt++
+			out := imagor.NewBlobFromBytes([]byte("processed"))
+			out.SetContentType("image/webp")
+			return out, nil
+		})),
+	)
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "processed", w.Body.String())
+		assert.Equal(t, "image/webp", w.Header().Get("Content-Type"))
+	}
+	assert.Equal(t, 1, processCnt)
+}
+
+type lowerStorage struct {
+	imagor.Storage
+}
+
+func TestPromote(t *testing.T) {
+	ctx := context.Background()
+	var processCnt int
+	s := New()
+	lower := New()
+	require.NoError(t, lower.Put(ctx, "foo", imagor.NewBlobFromBytes([]byte("cached"))))
+	app := imagor.New(
+		imagor.WithUnsafe(true),
+		imagor.WithResultStorages(s, lowerStorage{lower}),
+		imagor.WithLoaders(loaderFunc(func(r *http.Request, image string) (*imagor.Blob, error) {
+			return imagor.NewBlobFromBytes([]byte(image)), nil
+		})),
+		imagor.WithProcessors(processorFunc(func(ctx context.Context, blob *imagor.Blob, p imagorpath.Params, load imagor.LoadFunc) (*imagor.Blob, error) {
+			processCnt++
+			return imagor.NewBlobFromBytes([]byte("processed")), nil
+		})),
+	)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "cached", w.Body.String())
+	app.Wait()
+
+	lowerStat, err := lower.Stat(ctx, "foo")
+	require.NoError(t, err)
+	stat, err := s.Stat(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, lowerStat.ModifiedTime, stat.ModifiedTime, "modified time of lower tier retained")
+
+	require.NoError(t, lower.Delete(ctx, "foo"))
+	b, err := s.Get(&http.Request{}, "foo")
+	require.NoError(t, err, "lower tier hit promoted")
+	buf, err := b.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "cached", string(buf))
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "cached", w.Body.String())
+	assert.Equal(t, 0, processCnt)
+}
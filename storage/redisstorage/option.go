@@ -0,0 +1,21 @@
+package redisstorage
+
+import "time"
+
+type Option func(s *RedisStorage)
+
+func WithPrefix(prefix string) Option {
+	return func(s *RedisStorage) {
+		if prefix != "" {
+			s.Prefix = prefix
+		}
+	}
+}
+
+func WithExpiration(exp time.Duration) Option {
+	return func(s *RedisStorage) {
+		if exp > 0 {
+			s.Expiration = exp
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package redisstorage
+
+import (
+	"context"
+	"github.com/cshum/imagor"
+	"github.com/go-redis/redis/v8"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	fieldBody         = "body"
+	fieldContentType  = "content_type"
+	fieldModifiedTime = "modified_time"
+	fieldSize         = "size"
+)
+
+// RedisStorage imagor.Storage backed by Redis,
+// storing image as hash of body, content type and modified time
+type RedisStorage struct {
+	Client     redis.UniversalClient
+	Prefix     string
+	Expiration time.Duration
+}
+
+func New(client redis.UniversalClient, options ...Option) *RedisStorage {
+	s := &RedisStorage{
+		Client: client,
+		Prefix: "imagor:",
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func (s *RedisStorage) Key(image string) string {
+	return s.Prefix + image
+}
+
+func (s *RedisStorage) Get(r *http.Request, image string) (*imagor.Blob, error) {
+	values, err := s.Client.HMGet(r.Context(), s.Key(image),
+		fieldBody, fieldContentType, fieldModifiedTime).Result()
+	if err != nil {
+		return nil, err
+	}
+	body, ok := values[0].(string)
+	if !ok {
+		return nil, imagor.ErrNotFound
+	}
+	modifiedTime := parseTime(values[2])
+	if s.Expiration > 0 && time.Now().Sub(modifiedTime) > s.Expiration {
+		if !imagor.IsStaleAllowed(r.Context()) {
+			return nil, imagor.ErrExpired
+		}
+		// stale image returned along with expired error
+		err = imagor.ErrExpired
+	}
+	blob := imagor.NewBlobFromBytes([]byte(body))
+	if contentType, _ := values[1].(string); contentType != "" {
+		blob.SetContentType(contentType)
+	}
+	blob.SetStat(&imagor.Stat{
+		Size:         int64(len(body)),
+		ModifiedTime: modifiedTime,
+	})
+	return blob, err
+}
+
+func (s *RedisStorage) Put(ctx context.Context, image string, blob *imagor.Blob) error {
+	buf, err := blob.ReadAll()
+	if err != nil {
+		return err
+	}
+	key := s.Key(image)
+	_, err = s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key,
+			fieldBody, buf,
+			fieldContentType, blob.ContentType(),
+			fieldModifiedTime, strconv.FormatInt(time.Now().UnixNano(), 10),
+			fieldSize, strconv.Itoa(len(buf)),
+		)
+		if s.Expiration > 0 {
+			pipe.PExpire(ctx, key, s.Expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStorage) Delete(ctx context.Context, image string) error {
+	return s.Client.Del(ctx, s.Key(image)).Err()
+}
+
+func (s *RedisStorage) Stat(ctx context.Context, image string) (*imagor.Stat, error) {
+	values, err := s.Client.HMGet(ctx, s.Key(image), fieldModifiedTime, fieldSize).Result()
+	if err != nil {
+		return nil, err
+	}
+	if values[0] == nil {
+		return nil, imagor.ErrNotFound
+	}
+	size, _ := values[1].(string)
+	sizeInt, _ := strconv.ParseInt(size, 10, 64)
+	return &imagor.Stat{
+		Size:         sizeInt,
+		ModifiedTime: parseTime(values[0]),
+	}, nil
+}
+
+func parseTime(v interface{}) time.Time {
+	s, _ := v.(string)
+	nano, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(0, nano)
+}
+
+// DeletePrefix deletes all images with key prefix by SCAN
+func (s *RedisStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	if s.Prefix+prefix == "" {
+		return imagor.ErrInvalid
+	}
+	iter := s.Client.Scan(ctx, 0, globEscaper.Replace(s.Key(prefix))+"*", 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) >= 100 {
+			if err := s.Client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return s.Client.Del(ctx, keys...).Err()
+	}
+	return nil
+}
+
+var globEscaper = strings.NewReplacer(
+	`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`,
+)
@@ -0,0 +1,100 @@
+package redisstorage
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cshum/imagor"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newClient(t *testing.T) (*miniredis.Miniredis, redis.UniversalClient) {
+	mr := miniredis.RunT(t)
+	return mr, redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func TestRedisStorage_Load_Save(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("CRUD", func(t *testing.T) {
+		mr, client := newClient(t)
+		s := New(client, WithPrefix("foo:"))
+
+		_, err := s.Get(&http.Request{}, "/foo/fooo/asdf")
+		assert.Equal(t, imagor.ErrNotFound, err)
+
+		_, err = s.Stat(ctx, "/foo/fooo/asdf")
+		assert.Equal(t, imagor.ErrNotFound, err)
+
+		blob := imagor.NewBlobFromBytes([]byte("bar"))
+		blob.SetContentType("image/png")
+		require.NoError(t, s.Put(ctx, "/foo/fooo/asdf", blob))
+		assert.True(t, mr.Exists("foo:/foo/fooo/asdf"))
+		assert.Equal(t, time.Duration(0), mr.TTL("foo:/foo/fooo/asdf"))
+
+		b, err := s.Get(&http.Request{}, "/foo/fooo/asdf")
+		require.NoError(t, err)
+		buf, err := b.ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, "bar", string(buf))
+		assert.Equal(t, "image/png", b.ContentType())
+
+		stat, err := s.Stat(ctx, "/foo/fooo/asdf")
+		require.NoError(t, err)
+		assert.Equal(t, stat.ModifiedTime.UnixNano(), b.Stat().ModifiedTime.UnixNano())
+		assert.Equal(t, int64(3), stat.Size)
+		assert.False(t, stat.ModifiedTime.After(time.Now()))
+		assert.True(t, stat.ModifiedTime.After(time.Now().Add(-time.Minute)))
+
+		require.NoError(t, s.Delete(ctx, "/foo/fooo/asdf"))
+		_, err = s.Get(&http.Request{}, "/foo/fooo/asdf")
+		assert.Equal(t, imagor.ErrNotFound, err)
+	})
+
+	t.Run("delete prefix", func(t *testing.T) {
+		mr, client := newClient(t)
+		s := New(client, WithPrefix("x:"))
+		for i := 0; i < 150; i++ {
+			require.NoError(t, s.Put(ctx, fmt.Sprintf("a*c/%d", i), imagor.NewBlobFromBytes([]byte("1"))))
+		}
+		require.NoError(t, s.Put(ctx, "abc/1", imagor.NewBlobFromBytes([]byte("1"))))
+		require.NoError(t, mr.Set("y:a*c/1", "foo"))
+		require.NoError(t, s.DeletePrefix(ctx, "a*c/"))
+		assert.Equal(t, []string{"x:abc/1", "y:a*c/1"}, mr.Keys())
+	})
+
+	t.Run("expiration", func(t *testing.T) {
+		mr, client := newClient(t)
+		s := New(client, WithExpiration(time.Minute))
+		require.NoError(t, s.Put(ctx, "/foo/bar/asdf", imagor.NewBlobFromBytes([]byte("bar"))))
+		assert.Equal(t, time.Minute, mr.TTL("imagor:/foo/bar/asdf"))
+		_, err := s.Get(&http.Request{}, "/foo/bar/asdf")
+		require.NoError(t, err)
+
+		mr.FastForward(time.Minute)
+		_, err = s.Get(&http.Request{}, "/foo/bar/asdf")
+		assert.Equal(t, imagor.ErrNotFound, err)
+	})
+
+	t.Run("expired by modified time", func(t *testing.T) {
+		_, client := newClient(t)
+		require.NoError(t, New(client).Put(ctx, "/foo/bar/asdf", imagor.NewBlobFromBytes([]byte("bar"))))
+		time.Sleep(time.Millisecond * 20)
+		s := New(client, WithExpiration(time.Millisecond*10))
+		_, err := s.Get(&http.Request{}, "/foo/bar/asdf")
+		assert.ErrorIs(t, err, imagor.ErrExpired)
+
+		r := (&http.Request{}).WithContext(imagor.AllowStale(context.Background()))
+		blob, err := s.Get(r, "/foo/bar/asdf")
+		assert.ErrorIs(t, err, imagor.ErrExpired)
+		require.NotNil(t, blob, "stale image returned if stale allowed")
+		buf, err := blob.ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, "bar", string(buf))
+	})
+}
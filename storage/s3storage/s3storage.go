@@ -68,7 +68,8 @@ func (s *S3Storage) Get(r *http.Request, image string) (*imagor.Blob, error) {
 	if !ok {
 		return nil, imagor.ErrInvalid
 	}
-	return imagor.NewBlob(func() (io.ReadCloser, int64, error) {
+	var blob *imagor.Blob
+	blob = imagor.NewBlob(func() (io.ReadCloser, int64, error) {
 		input := &s3.GetObjectInput{
 			Bucket: aws.String(s.Bucket),
 			Key:    aws.String(image),
@@ -79,17 +80,33 @@ func (s *S3Storage) Get(r *http.Request, image string) (*imagor.Blob, error) {
 		} else if err != nil {
 			return nil, 0, err
 		}
+		var expired bool
 		if s.Expiration > 0 && out.LastModified != nil {
 			if time.Now().Sub(*out.LastModified) > s.Expiration {
-				return nil, 0, imagor.ErrExpired
+				if !imagor.IsStaleAllowed(r.Context()) {
+					_ = out.Body.Close()
+					return nil, 0, imagor.ErrExpired
+				}
+				// stale image readable along with expired error
+				expired = true
 			}
 		}
 		var size int64
 		if out.ContentLength != nil {
 			size = *out.ContentLength
 		}
+		if out.LastModified != nil {
+			blob.SetStat(&imagor.Stat{
+				Size:         size,
+				ModifiedTime: *out.LastModified,
+			})
+		}
+		if expired {
+			return out.Body, size, imagor.ErrExpired
+		}
 		return out.Body, size, nil
-	}), nil
+	})
+	return blob, nil
 }
 
 func (s *S3Storage) Put(ctx context.Context, image string, blob *imagor.Blob) error {
@@ -157,3 +174,37 @@ func (s *S3Storage) Stat(ctx context.Context, image string) (stat *imagor.Stat,
 		ModifiedTime: *head.LastModified,
 	}, nil
 }
+
+// DeletePrefix deletes all objects under the directory of prefix
+func (s *S3Storage) DeletePrefix(ctx context.Context, prefix string) error {
+	if strings.Trim(prefix, "/") == "" {
+		return imagor.ErrInvalid
+	}
+	dir, ok := s.Path(prefix)
+	if !ok {
+		return imagor.ErrInvalid
+	}
+	dir += "/"
+	var deleteErr error
+	err := s.S3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(dir),
+	}, func(out *s3.ListObjectsV2Output, _ bool) bool {
+		if len(out.Contents) == 0 {
+			return true
+		}
+		var objects []*s3.ObjectIdentifier
+		for _, obj := range out.Contents {
+			objects = append(objects, &s3.ObjectIdentifier{Key: obj.Key})
+		}
+		_, deleteErr = s.S3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.Bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		return deleteErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteErr
+}
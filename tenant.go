@@ -0,0 +1,142 @@
+package imagor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Tenant Imagor instance served by hostnames or path prefix,
+// with its own signer, loaders, storages, processors and limits
+type Tenant struct {
+	Name string
+	// Hosts hostnames of tenant, with leading wildcard e.g. *.example.com
+	Hosts []string
+	// PathPrefix path prefix of tenant e.g. /team-a, stripped before passing to tenant
+	PathPrefix string
+	App        *Imagor
+}
+
+// match returns true if request matches hostnames and path prefix of tenant,
+// both being required if both present
+func (t Tenant) match(host, path string) bool {
+	if len(t.Hosts) == 0 && t.PathPrefix == "" {
+		return false
+	}
+	if len(t.Hosts) > 0 && !matchHost(t.Hosts, host) {
+		return false
+	}
+	if t.PathPrefix != "" && path != t.PathPrefix && !strings.HasPrefix(path, t.PathPrefix+"/") {
+		return false
+	}
+	return true
+}
+
+func matchHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if strings.HasPrefix(h, "*.") {
+			if strings.HasSuffix(host, h[1:]) {
+				return true
+			}
+		} else if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Tenants routes requests to Imagor instance of tenant by hostname or path prefix,
+// falls back to the default Imagor if no tenant matched
+type Tenants struct {
+	Default *Imagor
+	Tenants []Tenant
+}
+
+// NewTenants create new Tenants with default Imagor
+func NewTenants(app *Imagor, tenants ...Tenant) *Tenants {
+	t := &Tenants{Default: app}
+	for _, tenant := range tenants {
+		t.Add(tenant)
+	}
+	return t
+}
+
+// Add adds tenant, with hostnames and path prefix normalized
+func (t *Tenants) Add(tenant Tenant) {
+	var hosts []string
+	for _, host := range tenant.Hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	tenant.Hosts = hosts
+	if tenant.PathPrefix = strings.Trim(strings.TrimSpace(tenant.PathPrefix), "/"); tenant.PathPrefix != "" {
+		tenant.PathPrefix = "/" + tenant.PathPrefix
+	}
+	t.Tenants = append(t.Tenants, tenant)
+}
+
+// Match returns tenant matching the request in order of tenants added, false if none matched
+func (t *Tenants) Match(r *http.Request) (Tenant, bool) {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, tenant := range t.Tenants {
+		if tenant.match(host, r.URL.Path) {
+			return tenant, true
+		}
+	}
+	return Tenant{}, false
+}
+
+// ServeHTTP implements http.Handler
+func (t *Tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := t.Match(r)
+	if !ok {
+		if t.Default == nil {
+			w.WriteHeader(ErrNotFound.Code)
+			writeJSON(w, r, ErrNotFound)
+			return
+		}
+		t.Default.ServeHTTP(w, r)
+		return
+	}
+	if tenant.PathPrefix != "" {
+		http.StripPrefix(tenant.PathPrefix, tenant.App).ServeHTTP(w, r)
+		return
+	}
+	tenant.App.ServeHTTP(w, r)
+}
+
+func (t *Tenants) apps() (apps []*Imagor) {
+	if t.Default != nil {
+		apps = append(apps, t.Default)
+	}
+	for _, tenant := range t.Tenants {
+		apps = append(apps, tenant.App)
+	}
+	return
+}
+
+// Startup Tenants startup lifecycle of all Imagor instances
+func (t *Tenants) Startup(ctx context.Context) (err error) {
+	for _, app := range t.apps() {
+		if err = app.Startup(ctx); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Shutdown Tenants shutdown lifecycle of all Imagor instances, in reverse order of startup
+func (t *Tenants) Shutdown(ctx context.Context) (err error) {
+	apps := t.apps()
+	for i := len(apps) - 1; i >= 0; i-- {
+		if e := apps[i].Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
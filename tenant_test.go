@@ -0,0 +1,87 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type countProcessor struct {
+	processorFunc
+	StartupCnt  int
+	ShutdownCnt int
+}
+
+func (p *countProcessor) Startup(_ context.Context) error {
+	p.StartupCnt++
+	return nil
+}
+
+func (p *countProcessor) Shutdown(_ context.Context) error {
+	p.ShutdownCnt++
+	return nil
+}
+
+func TestTenants(t *testing.T) {
+	loader := func(name string) Option {
+		return WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			return NewBlobFromBytes([]byte(name + ":" + image)), nil
+		}))
+	}
+	processor := &countProcessor{processorFunc: func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+		return blob, nil
+	}}
+	tenants := NewTenants(New(WithUnsafe(true), loader("default")),
+		Tenant{Name: "a", Hosts: []string{" Images.A.com ", "*.a.net"}, App: New(loader("a"),
+			WithSigner(imagorpath.NewDefaultSigner("secret-a")), WithProcessors(processor))},
+		Tenant{Name: "b", PathPrefix: "/b/", App: New(WithUnsafe(true), loader("b"))},
+		Tenant{Name: "c", Hosts: []string{"c.com"}, PathPrefix: "c", App: New(WithUnsafe(true), loader("c"))},
+		Tenant{Name: "none", App: New(WithUnsafe(true), loader("none"))},
+	)
+	assert.Equal(t, []string{"images.a.com", "*.a.net"}, tenants.Tenants[0].Hosts)
+	assert.Equal(t, "/b", tenants.Tenants[1].PathPrefix)
+
+	for url, expected := range map[string]string{
+		"https://images.a.com/" + imagorpath.Generate(imagorpath.Params{Image: "foo.jpg"}, imagorpath.NewDefaultSigner("secret-a")):   "a:foo.jpg",
+		"https://foo.a.net:8000/" + imagorpath.Generate(imagorpath.Params{Image: "foo.jpg"}, imagorpath.NewDefaultSigner("secret-a")): "a:foo.jpg",
+		"https://example.com/b/unsafe/foo.jpg":       "b:foo.jpg",
+		"https://example.com/unsafe/b/foo.jpg":       "default:b/foo.jpg",
+		"https://c.com/c/unsafe/foo.jpg":             "c:foo.jpg",
+		"https://c.com/unsafe/foo.jpg":               "default:foo.jpg",
+		"https://example.com/unsafe/a.net/foo.jpg":   "default:a.net/foo.jpg",
+		"https://images.a.com.evil.com/unsafe/x.jpg": "default:x.jpg",
+	} {
+		w := httptest.NewRecorder()
+		tenants.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, 200, w.Code, url)
+		assert.Equal(t, expected, w.Body.String(), url)
+	}
+
+	for url, expected := range map[string]bool{
+		"https://example.com/b":         true,
+		"https://example.com/bar/x.jpg": false,
+		"https://example.com/c/x.jpg":   false,
+		"https://C.com/c/x.jpg":         true,
+		"https://a.net/x.jpg":           false,
+	} {
+		_, ok := tenants.Match(httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, expected, ok, url)
+	}
+
+	w := httptest.NewRecorder()
+	tenants.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://images.a.com/unsafe/foo.jpg", nil))
+	assert.Equal(t, ErrSignatureMismatch.Code, w.Code, "tenant own signer")
+
+	tenants.Default = nil
+	w = httptest.NewRecorder()
+	tenants.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo.jpg", nil))
+	assert.Equal(t, ErrNotFound.Code, w.Code, "no default")
+
+	assert.NoError(t, tenants.Startup(context.Background()))
+	assert.NoError(t, tenants.Shutdown(context.Background()))
+	assert.Equal(t, 1, processor.StartupCnt)
+	assert.Equal(t, 1, processor.ShutdownCnt)
+}
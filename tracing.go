@@ -0,0 +1,51 @@
+package imagor
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// TracerName instrumentation name of Imagor spans
+const TracerName = "github.com/cshum/imagor"
+
+var traceContext = propagation.TraceContext{}
+
+// startRequestSpan starts the root span of an Imagor operation,
+// continuing W3C traceparent of the incoming request if context has no span
+func (app *Imagor) startRequestSpan(
+	r *http.Request, name string, attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	ctx := r.Context()
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = traceContext.Extract(ctx, propagation.HeaderCarrier(r.Header))
+	}
+	return app.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+func (app *Imagor) startSpan(
+	ctx context.Context, name string, attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	return app.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records error if any and ends the span.
+// ErrPass is not considered an error
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrPass) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectTraceContext injects W3C traceparent of the span in context to header,
+// which allows Loader to propagate trace to outbound requests
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	traceContext.Inject(ctx, propagation.HeaderCarrier(header))
+}
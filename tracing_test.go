@@ -0,0 +1,120 @@
+package imagor
+
+import (
+	"context"
+	"errors"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func spanNames(spans tracetest.SpanStubs) map[string]int {
+	names := map[string]int{}
+	for _, span := range spans {
+		names[span.Name]++
+	}
+	return names
+}
+
+func TestWithTracerProvider(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	resultStore := newMapStore()
+	app := New(
+		WithUnsafe(true),
+		WithTracerProvider(provider),
+		WithResultStorages(resultStore),
+		WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+			if image == "boom" {
+				return nil, ErrNotFound
+			}
+			return NewBlobFromBytes([]byte(image)), nil
+		})),
+		WithProcessors(
+			processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				return blob, ErrPass
+			}),
+			processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				_, span := trace.SpanFromContext(ctx).TracerProvider().Tracer("test").Start(ctx, "filter")
+				span.End()
+				if string(blob.Sniff()) == "fail" {
+					return nil, errors.New("process failed")
+				}
+				return blob, nil
+			}),
+		),
+	)
+
+	t.Run("processed", func(t *testing.T) {
+		exporter.Reset()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil)
+		r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		app.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Code)
+		assert.Eventually(t, func() bool {
+			return spanNames(exporter.GetSpans())["imagor.save"] == 1
+		}, time.Second, time.Millisecond)
+
+		spans := exporter.GetSpans()
+		names := spanNames(spans)
+		assert.Equal(t, 1, names["imagor.Do"])
+		assert.Equal(t, 1, names["imagor.loadResult"])
+		assert.Equal(t, 2, names["imagor.load"])
+		assert.Equal(t, 2, names["imagor.process"])
+		assert.Equal(t, 1, names["filter"])
+		spanIDs := map[trace.SpanID]string{}
+		for _, span := range spans {
+			spanIDs[span.SpanContext.SpanID()] = span.Name
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+			assert.NotEqual(t, codes.Error, span.Status.Code, span.Name)
+		}
+		for _, span := range spans {
+			switch span.Name {
+			case "imagor.Do":
+				assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+				assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+			case "imagor.process", "imagor.loadResult", "imagor.save":
+				assert.Equal(t, "imagor.Do", spanIDs[span.Parent.SpanID()], span.Name)
+			case "filter":
+				assert.Equal(t, "imagor.process", spanIDs[span.Parent.SpanID()])
+			}
+		}
+	})
+
+	t.Run("result", func(t *testing.T) {
+		exporter.Reset()
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/foo", nil))
+		assert.Equal(t, 200, w.Code)
+		names := spanNames(exporter.GetSpans())
+		assert.Equal(t, 1, names["imagor.Do"])
+		assert.Equal(t, 1, names["imagor.loadResult"])
+		assert.Equal(t, 1, names["imagor.load"])
+		assert.Equal(t, 0, names["imagor.process"])
+	})
+
+	t.Run("error", func(t *testing.T) {
+		for _, image := range []string{"boom", "fail"} {
+			exporter.Reset()
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/unsafe/"+image, nil))
+			assert.NotEqual(t, 200, w.Code)
+			for _, span := range exporter.GetSpans() {
+				if span.Name == "imagor.Do" {
+					assert.Equal(t, codes.Error, span.Status.Code)
+					assert.NotEmpty(t, span.Events)
+				}
+			}
+		}
+	})
+
+	assert.NoError(t, app.Shutdown(context.Background()))
+}
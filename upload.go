@@ -0,0 +1,192 @@
+package imagor
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/cshum/imagor/imagorpath"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// UploadResult attributes of the uploaded image
+type UploadResult struct {
+	Image       string `json:"image"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// Upload validates image from request body and saves into Storages.
+// Requires Bearer token of UploadSecret, or unsafe path if Unsafe enabled without UploadSecret.
+// Returns processed image if request path contains image params,
+// otherwise returns UploadResult of the uploaded image
+func (app *Imagor) Upload(r *http.Request, p imagorpath.Params) (blob *Blob, err error) {
+	ctx, span := app.startRequestSpan(r, "imagor.Upload",
+		attribute.String("imagor.image", p.Image), attribute.String("imagor.path", p.Path))
+	defer func() {
+		endSpan(span, err)
+	}()
+	ctx = DeferContext(withMetricsContext(ctx, app.Metrics))
+	var cancel func()
+	if app.RequestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, app.RequestTimeout)
+		Defer(ctx, cancel)
+	}
+	r = r.WithContext(ctx)
+	if !app.EnableUpload || len(app.Storages) == 0 {
+		err = ErrMethodNotAllowed
+		return
+	}
+	if app.Unsafe && app.isPresetPath(p) {
+		p.Unsafe = true
+	}
+	if err = app.checkUpload(r, p); err != nil {
+		return
+	}
+	var isProcess = imagorpath.GeneratePath(p) != imagorpath.GeneratePath(imagorpath.Params{Image: p.Image})
+	if _, _, ok := app.splitPreset(p); ok || isProcess {
+		// presets expanded and restricted by PresetsOnly the same as Do
+		if p, err = app.applyPreset(p); err != nil {
+			return
+		}
+		isProcess = true
+	}
+	if p.Image == "" {
+		err = ErrInvalid
+		return
+	}
+	var src *Blob
+	if src, err = app.readUpload(r); err != nil {
+		if app.Debug {
+			app.Logger.Debug("upload-read", zap.Any("params", p), zap.Error(err))
+		}
+		return
+	}
+	if !isBlobImage(src) {
+		err = ErrUnsupportedFormat
+		return
+	}
+	// upload processing admitted the same as Do
+	if err = checkProcessLimit(ctx); err != nil {
+		return
+	}
+	var release func()
+	if release, err = app.acquire(ctx, r, p); err != nil {
+		return
+	}
+	defer release()
+	var releaseMemory func()
+	if releaseMemory, err = app.acquireMemory(ctx, src, p); err != nil {
+		return
+	}
+	defer releaseMemory()
+	if isProcess {
+		if app.BaseParams != "" {
+			p = imagorpath.Apply(p, app.BaseParams)
+			p.Path = imagorpath.GeneratePath(p)
+		}
+		// processed result also validates that the image decodes
+		load := func(image string) (*Blob, error) {
+			blob, _, err := app.loadStorage(r, image)
+			return blob, err
+		}
+		if blob, err = checkBlob(app.process(ctx, src, p, load)); err != nil {
+			return
+		}
+	} else {
+		// decode image metadata for validation
+		if _, err = checkBlob(app.process(ctx, src, imagorpath.Params{
+			Meta: true, Image: p.Image,
+		}, nil)); err != nil {
+			return
+		}
+		blob = NewBlobFromJsonMarshal(UploadResult{
+			Image:       p.Image,
+			Size:        src.Size(),
+			ContentType: src.ContentType(),
+		})
+	}
+	if err = app.save(ctx, "storage", p.Image, src); err != nil {
+		return nil, err
+	}
+	if app.Debug {
+		app.Logger.Debug("uploaded", zap.String("image", p.Image), zap.Int64("size", src.Size()))
+	}
+	if isProcess && !isBlobEmpty(blob) && len(app.ResultStorages) > 0 {
+		app.save(ctx, "result_storage", app.resultKey(p, Variant{}), blob)
+	}
+	return
+}
+
+// checkUpload authorizes upload by UploadSecret instead of URL signature,
+// such that signed GET URLs do not grant write access to the source image
+func (app *Imagor) checkUpload(r *http.Request, p imagorpath.Params) error {
+	if app.UploadSecret == "" {
+		if app.Unsafe && p.Unsafe {
+			return nil
+		}
+		return ErrUnauthorized
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(app.UploadSecret)) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func (app *Imagor) readUpload(r *http.Request) (*Blob, error) {
+	if r.Body == nil {
+		return nil, ErrInvalid
+	}
+	var reader io.Reader = r.Body
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, ErrInvalid
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, ErrInvalid
+			}
+			if err != nil {
+				return nil, err
+			}
+			// first file part of the form
+			if part.FileName() != "" {
+				reader = part
+				break
+			}
+		}
+	}
+	var buf []byte
+	var err error
+	if app.UploadMaxSize > 0 {
+		buf, err = io.ReadAll(io.LimitReader(reader, app.UploadMaxSize+1))
+		if int64(len(buf)) > app.UploadMaxSize {
+			return nil, ErrMaxSizeExceeded
+		}
+	} else {
+		buf, err = io.ReadAll(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, ErrInvalid
+	}
+	return NewBlobFromBytes(buf), nil
+}
+
+func isBlobImage(blob *Blob) bool {
+	switch blob.BlobType() {
+	case BlobTypeJPEG, BlobTypePNG, BlobTypeGIF, BlobTypeWEBP,
+		BlobTypeAVIF, BlobTypeHEIF, BlobTypeTIFF:
+		return true
+	}
+	return false
+}
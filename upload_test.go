@@ -0,0 +1,181 @@
+package imagor
+
+import (
+	"bytes"
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestUpload(t *testing.T) {
+	jpeg, err := os.ReadFile("testdata/demo1.jpg")
+	require.NoError(t, err)
+
+	t.Run("disabled", func(t *testing.T) {
+		store := newMapStore()
+		app := New(WithUnsafe(true), WithStorages(store))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPost, "https://example.com/unsafe/foo.jpg", bytes.NewReader(jpeg)))
+		assert.Equal(t, 405, w.Code)
+		assert.Empty(t, store.Map)
+	})
+
+	t.Run("no storage", func(t *testing.T) {
+		app := New(WithUnsafe(true), WithEnableUpload(true))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPost, "https://example.com/unsafe/foo.jpg", bytes.NewReader(jpeg)))
+		assert.Equal(t, 405, w.Code)
+		assert.Equal(t, jsonStr(ErrMethodNotAllowed), w.Body.String())
+	})
+
+	t.Run("raw body", func(t *testing.T) {
+		store := newMapStore()
+		app := New(
+			WithDebug(true), WithLogger(zap.NewExample()),
+			WithUnsafe(true), WithEnableUpload(true), WithStorages(store))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPut, "https://example.com/unsafe/foo.jpg", bytes.NewReader(jpeg)))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "private, no-cache, no-store, must-revalidate", w.Header().Get("Cache-Control"))
+		assert.Equal(t, jsonStr(UploadResult{
+			Image: "foo.jpg", Size: int64(len(jpeg)), ContentType: "image/jpeg",
+		}), w.Body.String())
+		require.Contains(t, store.Map, "foo.jpg")
+		buf, err := store.Map["foo.jpg"].ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, jpeg, buf)
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		store := newMapStore()
+		app := New(WithUnsafe(true), WithEnableUpload(true), WithStorages(store))
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		require.NoError(t, mw.WriteField("foo", "bar"))
+		fw, err := mw.CreateFormFile("image", "demo1.jpg")
+		require.NoError(t, err)
+		_, err = fw.Write(jpeg)
+		require.NoError(t, err)
+		require.NoError(t, mw.Close())
+		r := httptest.NewRequest(http.MethodPost, "https://example.com/unsafe/bar/foo.jpg", body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		assert.Equal(t, 200, w.Code)
+		require.Contains(t, store.Map, "bar/foo.jpg")
+		buf, err := store.Map["bar/foo.jpg"].ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, jpeg, buf)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		store := newMapStore()
+		app := New(
+			WithSigner(imagorpath.NewDefaultSigner("1234")),
+			WithEnableUpload(true), WithUploadMaxSize(100), WithStorages(store))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPost, "https://example.com/unsafe/foo.jpg", bytes.NewReader(jpeg)))
+		assert.Equal(t, 401, w.Code)
+		assert.Equal(t, jsonStr(ErrUnauthorized), w.Body.String())
+
+		signed := "/" + imagorpath.Generate(imagorpath.Params{Image: "foo.jpg"}, imagorpath.NewDefaultSigner("1234"))
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPost, "https://example.com"+signed, bytes.NewReader(jpeg)))
+		assert.Equal(t, 401, w.Code, "signed GET URL does not authorize upload")
+		assert.Empty(t, store.Map)
+	})
+
+	t.Run("upload secret", func(t *testing.T) {
+		store := newMapStore()
+		app := New(
+			WithSigner(imagorpath.NewDefaultSigner("1234")), WithUploadSecret("s3cret"),
+			WithEnableUpload(true), WithUploadMaxSize(100), WithStorages(store))
+		upload := func(token string, body []byte) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, "https://example.com/unsafe/foo.jpg", bytes.NewReader(body))
+			if body == nil {
+				r.Body = nil
+			}
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+			return w
+		}
+		w := upload("foo", jpeg)
+		assert.Equal(t, 401, w.Code)
+		assert.Equal(t, jsonStr(ErrUnauthorized), w.Body.String())
+
+		w = upload("s3cret", jpeg)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, jsonStr(ErrMaxSizeExceeded), w.Body.String())
+
+		w = upload("s3cret", []byte("foo"))
+		assert.Equal(t, 406, w.Code)
+		assert.Equal(t, jsonStr(ErrUnsupportedFormat), w.Body.String())
+
+		w = upload("s3cret", nil)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, jsonStr(ErrInvalid), w.Body.String())
+		assert.Empty(t, store.Map)
+	})
+
+	t.Run("process limit", func(t *testing.T) {
+		store := newMapStore()
+		app := New(WithUnsafe(true), WithEnableUpload(true), WithStorages(store))
+		r := httptest.NewRequest(
+			http.MethodPost, "https://example.com/unsafe/100x0/foo.jpg", bytes.NewReader(jpeg))
+		r = r.WithContext(WithProcessLimit(r.Context(), func() error {
+			return ErrTooManyRequests
+		}))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		assert.Equal(t, 429, w.Code)
+		assert.Empty(t, store.Map)
+	})
+
+	t.Run("decode error", func(t *testing.T) {
+		store := newMapStore()
+		app := New(
+			WithUnsafe(true), WithEnableUpload(true), WithStorages(store),
+			WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				assert.True(t, p.Meta)
+				return nil, ErrUnsupportedFormat
+			})))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPost, "https://example.com/unsafe/foo.jpg", bytes.NewReader(jpeg)))
+		assert.Equal(t, 406, w.Code)
+		assert.Empty(t, store.Map)
+	})
+
+	t.Run("process params", func(t *testing.T) {
+		store := newMapStore()
+		resultStore := newMapStore()
+		app := New(
+			WithUnsafe(true), WithEnableUpload(true),
+			WithStorages(store), WithResultStorages(resultStore),
+			WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				assert.Equal(t, 100, p.Width)
+				return NewBlobFromBytes([]byte("processed")), nil
+			})))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(
+			http.MethodPost, "https://example.com/unsafe/100x0/foo.jpg", bytes.NewReader(jpeg)))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "processed", w.Body.String())
+		assert.Equal(t, 1, store.SaveCnt["foo.jpg"])
+		assert.Equal(t, 1, resultStore.SaveCnt["100x0/foo.jpg"])
+	})
+}
@@ -0,0 +1,103 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"net/http"
+	"strings"
+)
+
+// Variant content negotiation decisions of a request,
+// which vary the output of the same endpoint params
+type Variant struct {
+	// Accept request Accept header if negotiated, normalised into bucket by NormalizeAccept if AcceptBuckets enabled
+	Accept string
+	// Format output format negotiated by Accept header, empty if not negotiated
+	Format string
+	// DPR device pixel ratio the output scaled by client hints, 0 if not scaled by DPR
+	DPR float64
+	// Vary request headers that influenced the output
+	Vary []string
+}
+
+// VariantResultKey optional ResultKey interface that generates result key from params and Variant,
+// for recording negotiation decisions in the result key
+type VariantResultKey interface {
+	ResultKey
+	GenerateVariant(p imagorpath.Params, v Variant) string
+}
+
+// acceptBuckets image types for bucketing Accept header, in order of preference
+var acceptBuckets = []string{"image/avif", "image/webp"}
+
+// NormalizeAccept normalises Accept header into bucket of image types supported for negotiation,
+// such as "image/avif,image/webp", "image/webp" or empty
+func NormalizeAccept(accept string) string {
+	var types []string
+	for _, t := range acceptBuckets {
+		if strings.Contains(accept, t) {
+			types = append(types, t)
+		}
+	}
+	return strings.Join(types, ",")
+}
+
+type variantKey struct{}
+
+// withVariant context with Variant holder, filled by Do once negotiated
+func withVariant(ctx context.Context, v *Variant) context.Context {
+	return context.WithValue(ctx, variantKey{}, v)
+}
+
+// negotiate applies content negotiation of request on params
+func (app *Imagor) negotiate(r *http.Request, p imagorpath.Params) (imagorpath.Params, Variant) {
+	var v Variant
+	if app.AutoWebP || app.AutoAVIF {
+		var hasFormat bool
+		for _, f := range p.Filters {
+			if f.Name == "format" {
+				hasFormat = true
+			}
+		}
+		if !hasFormat {
+			accept := r.Header.Get("Accept")
+			v.Vary = append(v.Vary, "Accept")
+			v.Accept = accept
+			if app.AcceptBuckets {
+				v.Accept = NormalizeAccept(accept)
+			}
+			if app.AutoAVIF && strings.Contains(accept, "image/avif") {
+				v.Format = "avif"
+			} else if app.AutoWebP && strings.Contains(accept, "image/webp") {
+				v.Format = "webp"
+			}
+			if v.Format != "" {
+				p.Filters = append(p.Filters, imagorpath.Filter{
+					Name: "format",
+					Args: v.Format,
+				})
+				p.Path = imagorpath.GeneratePath(p)
+			}
+		}
+	}
+	if app.ClientHints {
+		for _, h := range clientHints {
+			v.Vary = append(v.Vary, h.Name)
+		}
+		var changed bool
+		if v.DPR, changed = app.applyClientHints(r, &p); changed {
+			p.Path = imagorpath.GeneratePath(p)
+		}
+	}
+	if holder, ok := r.Context().Value(variantKey{}).(*Variant); ok && holder != nil {
+		*holder = v
+	}
+	return p, v
+}
+
+// setVaryHeader sets Vary response header by request headers that influenced the output
+func setVaryHeader(w http.ResponseWriter, v *Variant) {
+	if len(v.Vary) > 0 {
+		w.Header().Set("Vary", strings.Join(v.Vary, ", "))
+	}
+}
@@ -0,0 +1,123 @@
+package imagor
+
+import (
+	"context"
+	"github.com/cshum/imagor/imagorpath"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// variantResultKey VariantResultKey recording Accept of the variant in result key
+type variantResultKey struct{}
+
+func (variantResultKey) Generate(p imagorpath.Params) string {
+	return p.Path
+}
+
+func (variantResultKey) GenerateVariant(p imagorpath.Params, v Variant) string {
+	return p.Path + "|" + v.Accept
+}
+
+func TestNormalizeAccept(t *testing.T) {
+	assert.Equal(t, "image/avif,image/webp", NormalizeAccept("image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"))
+	assert.Equal(t, "image/webp", NormalizeAccept("image/webp,*/*"))
+	assert.Equal(t, "", NormalizeAccept("image/png,image/*;q=0.8"))
+	assert.Equal(t, "", NormalizeAccept(""))
+}
+
+func TestVary(t *testing.T) {
+	loader := WithLoaders(loaderFunc(func(r *http.Request, image string) (*Blob, error) {
+		return NewBlobFromBytes([]byte(image)), nil
+	}))
+	processor := WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+		return NewBlobFromBytes([]byte(p.Path)), nil
+	}))
+	serve := func(app *Imagor, path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil)
+		r.Header.Set("Accept", accept)
+		app.ServeHTTP(w, r)
+		return w
+	}
+	hasKey := func(store *mapStore, key string) func() bool {
+		return func() bool {
+			store.l.Lock()
+			defer store.l.Unlock()
+			_, ok := store.Map[key]
+			return ok
+		}
+	}
+
+	t.Run("no negotiation", func(t *testing.T) {
+		app := New(WithUnsafe(true), loader, processor)
+		w := serve(app, "/unsafe/foo.jpg", "image/webp")
+		assert.Equal(t, "foo.jpg", w.Body.String())
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+
+	t.Run("auto webp", func(t *testing.T) {
+		resultStore := newMapStore()
+		app := New(WithUnsafe(true), WithAutoWebP(true), WithResultStorages(resultStore), loader, processor)
+		w := serve(app, "/unsafe/foo.jpg", "image/webp,*/*")
+		assert.Equal(t, "filters:format(webp)/foo.jpg", w.Body.String())
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+		assert.Eventually(t, hasKey(resultStore, "filters:format(webp)/foo.jpg"), time.Second, time.Millisecond)
+
+		w = serve(app, "/unsafe/foo.jpg", "image/png")
+		assert.Equal(t, "foo.jpg", w.Body.String())
+		assert.Equal(t, "Accept", w.Header().Get("Vary"), "should vary even if not negotiated")
+
+		w = serve(app, "/unsafe/filters:format(png)/foo.jpg", "image/webp")
+		assert.Equal(t, "filters:format(png)/foo.jpg", w.Body.String())
+		assert.Empty(t, w.Header().Get("Vary"), "explicit format should not vary")
+	})
+
+	t.Run("base params format", func(t *testing.T) {
+		app := New(WithUnsafe(true), WithAutoWebP(true), WithBaseParams("filters:format(jpeg)"), loader, processor)
+		w := serve(app, "/unsafe/foo.jpg", "image/webp")
+		assert.Equal(t, "filters:format(jpeg)/foo.jpg", w.Body.String())
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+
+	t.Run("client hints", func(t *testing.T) {
+		app := New(WithUnsafe(true), WithAutoAVIF(true), WithClientHints(true), loader, processor)
+		w := serve(app, "/unsafe/foo.jpg", "image/avif")
+		assert.Equal(t, "filters:format(avif)/foo.jpg", w.Body.String())
+		assert.Equal(t, "Accept, Sec-CH-DPR, Sec-CH-Width, Sec-CH-Viewport-Width", w.Header().Get("Vary"))
+	})
+
+	t.Run("variant result key", func(t *testing.T) {
+		resultStore := newMapStore()
+		app := New(WithUnsafe(true), WithAutoWebP(true), WithResultKey(variantResultKey{}),
+			WithResultStorages(resultStore), loader, processor)
+		serve(app, "/unsafe/foo.jpg", "image/avif,image/webp,image/*,*/*;q=0.8")
+		assert.Eventually(t, hasKey(resultStore,
+			"filters:format(webp)/foo.jpg|image/avif,image/webp,image/*,*/*;q=0.8"), time.Second, time.Millisecond)
+	})
+
+	t.Run("accept buckets", func(t *testing.T) {
+		var processed int32
+		resultStore := newMapStore()
+		app := New(WithUnsafe(true), WithAutoWebP(true), WithAutoAVIF(true), WithAcceptBuckets(true),
+			WithResultKey(variantResultKey{}), WithResultStorages(resultStore), loader,
+			WithProcessors(processorFunc(func(ctx context.Context, blob *Blob, p imagorpath.Params, load LoadFunc) (*Blob, error) {
+				atomic.AddInt32(&processed, 1)
+				return NewBlobFromBytes([]byte(p.Path)), nil
+			})))
+		w := serve(app, "/unsafe/foo.jpg", "image/avif,image/webp,image/apng,image/*,*/*;q=0.8")
+		assert.Equal(t, "filters:format(avif)/foo.jpg", w.Body.String())
+		assert.Eventually(t, hasKey(resultStore,
+			"filters:format(avif)/foo.jpg|image/avif,image/webp"), time.Second, time.Millisecond)
+
+		w = serve(app, "/unsafe/foo.jpg", "image/webp,image/avif;q=0.9,*/*")
+		assert.Equal(t, "filters:format(avif)/foo.jpg", w.Body.String())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&processed), "result shared by Accept of the same bucket")
+		resultStore.l.Lock()
+		defer resultStore.l.Unlock()
+		assert.Len(t, resultStore.Map, 1)
+	})
+}
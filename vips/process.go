@@ -5,6 +5,9 @@ import (
 	"github.com/cshum/imagor"
 	"github.com/cshum/imagor/imagorpath"
 	"github.com/cshum/imagor/vips/vipscontext"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"math"
 	"strconv"
@@ -440,13 +443,21 @@ func (v *Processor) process(
 			args = strings.Split(filter.Args, ",")
 		}
 		if fn := v.Filters[filter.Name]; fn != nil {
-			if err := fn(ctx, img, load, args...); err != nil {
+			spanCtx, span := startFilterSpan(ctx, filter)
+			err := fn(spanCtx, img, load, args...)
+			endFilterSpan(span, err)
+			imagor.ContextMetrics(ctx).ObserveFilter(filter.Name, time.Since(start), err)
+			if err != nil {
 				return err
 			}
 		} else if filter.Name == "fill" {
-			if err := v.fill(ctx, img, w, h,
+			spanCtx, span := startFilterSpan(ctx, filter)
+			err := v.fill(spanCtx, img, w, h,
 				p.PaddingLeft, p.PaddingTop, p.PaddingRight, p.PaddingBottom,
-				filter.Args); err != nil {
+				filter.Args)
+			endFilterSpan(span, err)
+			imagor.ContextMetrics(ctx).ObserveFilter(filter.Name, time.Since(start), err)
+			if err != nil {
 				return err
 			}
 		}
@@ -607,3 +618,22 @@ func findTrim(
 	})
 	return
 }
+
+// startFilterSpan starts filter span under the span of Processor from context,
+// using the TracerProvider of the parent span
+func startFilterSpan(ctx context.Context, filter imagorpath.Filter) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().
+		Tracer(imagor.TracerName+"/vips").
+		Start(ctx, "vips.filter", trace.WithAttributes(
+			attribute.String("imagor.filter", filter.Name),
+			attribute.String("imagor.filter.args", filter.Args),
+		))
+}
+
+func endFilterSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -130,6 +130,11 @@ func (v *Processor) Shutdown(_ context.Context) error {
 	return nil
 }
 
+// MaxDimensions implements imagor.DimensionsLimiter
+func (v *Processor) MaxDimensions() (width, height int) {
+	return v.MaxWidth, v.MaxHeight
+}
+
 func newImageFromBlob(
 	ctx context.Context, blob *imagor.Blob, params *ImportParams,
 ) (*Image, error) {